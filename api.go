@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/state"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/vehicle"
+)
+
+const apiPrefixV1 = "/api/v1/vehicles"
+
+type (
+	apiContainer struct {
+		Fields      map[string]apiField `json:"fields"`
+		FetchedAt   *time.Time          `json:"fetched_at,omitempty"`
+		LastAttempt *time.Time          `json:"last_attempt,omitempty"`
+		LastError   string              `json:"last_error,omitempty"`
+	}
+
+	apiError struct {
+		Error string `json:"error"`
+	}
+
+	apiField struct {
+		Value     any       `json:"value"`
+		Name      string    `json:"name,omitempty"`
+		Timestamp time.Time `json:"timestamp"`
+	}
+
+	apiVehicle struct {
+		VehicleID  string                              `json:"vehicle_id"`
+		Name       string                              `json:"name,omitempty"`
+		Containers map[mercedes.Container]apiContainer `json:"containers"`
+	}
+)
+
+// getAPIHandler serves the latest state from the store:
+//
+//	/api/v1/vehicles                       - all vehicles
+//	/api/v1/vehicles/{vehicle-id}          - one vehicle
+//	/api/v1/vehicles/{vehicle-id}/{container} - one container
+//
+// The vehicle-id is exposed according to the VIN mode of the registry
+func getAPIHandler(store *state.Store, vehicles *vehicle.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apiRespond(w, http.StatusMethodNotAllowed, apiError{"method not allowed"})
+			return
+		}
+
+		var parts []string
+		if p := strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefixV1), "/"); p != "" {
+			parts = strings.Split(p, "/")
+		}
+
+		switch len(parts) {
+		case 0:
+			out := []apiVehicle{}
+			for _, v := range store.Vehicles() {
+				out = append(out, apiVehicleFromState(v, vehicles))
+			}
+			apiRespond(w, http.StatusOK, out)
+
+		case 1, 2: //nolint:gomnd // vehicle / vehicle + container
+			v, ok := apiFindVehicle(store, vehicles, parts[0])
+			if !ok {
+				apiRespond(w, http.StatusNotFound, apiError{"vehicle not found"})
+				return
+			}
+
+			if len(parts) == 1 {
+				apiRespond(w, http.StatusOK, apiVehicleFromState(v, vehicles))
+				return
+			}
+
+			c, err := mercedes.ParseContainer(parts[1])
+			if err != nil {
+				apiRespond(w, http.StatusNotFound, apiError{err.Error()})
+				return
+			}
+
+			apiRespond(w, http.StatusOK, apiContainerFromState(v.Containers[c]))
+
+		default:
+			apiRespond(w, http.StatusNotFound, apiError{"not found"})
+		}
+	}
+}
+
+func apiContainerFromState(cs state.ContainerState) apiContainer {
+	out := apiContainer{Fields: make(map[string]apiField)}
+
+	if !cs.FetchedAt.IsZero() {
+		out.FetchedAt = &cs.FetchedAt
+	}
+	if !cs.LastAttempt.IsZero() {
+		out.LastAttempt = &cs.LastAttempt
+	}
+	if cs.LastError != nil {
+		out.LastError = cs.LastError.Error()
+	}
+
+	for _, f := range mercedes.Fields(cs.Data) {
+		if !f.Value.IsValid() {
+			continue
+		}
+
+		af := apiField{Timestamp: f.Value.Time()}
+		switch v := f.Value.(type) {
+		case mercedes.TimedBool:
+			af.Value = v.Bool()
+		case mercedes.TimedEnum:
+			af.Value = v.Idx()
+			af.Name = v.Value()
+		case mercedes.TimedFloat:
+			af.Value = v.Float()
+		case mercedes.TimedInt:
+			af.Value = v.Int()
+		default:
+			af.Value = f.Value.ToFloat()
+		}
+
+		out.Fields[f.Name] = af
+	}
+
+	return out
+}
+
+// apiFindVehicle resolves the public vehicle-id used in the URL
+func apiFindVehicle(store *state.Store, vehicles *vehicle.Registry, id string) (state.VehicleState, bool) {
+	for _, v := range store.Vehicles() {
+		if vehicles.PublicID(v.VehicleID) == id {
+			return v, true
+		}
+	}
+
+	return state.VehicleState{}, false
+}
+
+func apiRespond(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		logrus.WithError(err).Debug("writing API response")
+	}
+}
+
+func apiVehicleFromState(v state.VehicleState, vehicles *vehicle.Registry) apiVehicle {
+	out := apiVehicle{
+		VehicleID:  vehicles.PublicID(v.VehicleID),
+		Name:       v.Name,
+		Containers: make(map[mercedes.Container]apiContainer),
+	}
+
+	for c, cs := range v.Containers {
+		out.Containers[c] = apiContainerFromState(cs)
+	}
+
+	return out
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/state"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/vehicle"
+)
+
+const testVehicleID = "WDB111111ZZZ22222"
+
+func newTestAPI(t *testing.T, vehicles *vehicle.Registry) *httptest.Server {
+	t.Helper()
+
+	store := state.New()
+	store.SetVehicles(map[string]string{testVehicleID: "Family Car"})
+
+	store.SetLockStatus(testVehicleID, mercedes.LockStatus{
+		VehicleStatus: mercedes.NewTimedEnum(2, []string{"unlocked", "internal locked", "external locked"}, time.Unix(1000, 0)),
+		Heading:       mercedes.NewTimedFloat(90.5, time.Unix(1000, 0)),
+	})
+	store.RecordFetch(testVehicleID, mercedes.ContainerLockStatus, nil)
+	store.RecordFetch(testVehicleID, mercedes.ContainerFuelStatus, errors.New("no data"))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(apiPrefixV1, getAPIHandler(store, vehicles))
+	mux.HandleFunc(apiPrefixV1+"/", getAPIHandler(store, vehicles))
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// apiGet requests the path and decodes the response into out
+func apiGet(t *testing.T, srv *httptest.Server, path string, out any) int {
+	t.Helper()
+
+	resp, err := srv.Client().Get(srv.URL + path)
+	if err != nil {
+		t.Fatalf("requesting %s: %s", path, err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+		t.Fatalf("decoding response of %s: %s", path, err)
+	}
+
+	return resp.StatusCode
+}
+
+func TestAPIVehicles(t *testing.T) {
+	srv := newTestAPI(t, nil)
+
+	for _, path := range []string{apiPrefixV1, apiPrefixV1 + "/"} {
+		var vs []apiVehicle
+		if status := apiGet(t, srv, path, &vs); status != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d", path, status)
+		}
+
+		if len(vs) != 1 || vs[0].VehicleID != testVehicleID || vs[0].Name != "Family Car" {
+			t.Errorf("%s: unexpected vehicles %+v", path, vs)
+		}
+	}
+}
+
+func TestAPIVehicle(t *testing.T) {
+	srv := newTestAPI(t, nil)
+
+	var v apiVehicle
+	if status := apiGet(t, srv, apiPrefixV1+"/"+testVehicleID, &v); status != http.StatusOK {
+		t.Fatalf("unexpected status %d", status)
+	}
+
+	if v.VehicleID != testVehicleID || len(v.Containers) != 2 { //nolint:gomnd // Lock and fuel status
+		t.Fatalf("unexpected vehicle %+v", v)
+	}
+
+	fs := v.Containers[mercedes.ContainerFuelStatus]
+	if fs.LastError != "no data" || fs.LastAttempt == nil || fs.FetchedAt != nil {
+		t.Errorf("unexpected fuel status %+v", fs)
+	}
+}
+
+func TestAPIContainer(t *testing.T) {
+	srv := newTestAPI(t, nil)
+
+	var c apiContainer
+	if status := apiGet(t, srv, apiPrefixV1+"/"+testVehicleID+"/"+string(mercedes.ContainerLockStatus), &c); status != http.StatusOK {
+		t.Fatalf("unexpected status %d", status)
+	}
+
+	if c.FetchedAt == nil || c.LastError != "" || len(c.Fields) != 2 { //nolint:gomnd // Lock status and heading
+		t.Fatalf("unexpected container %+v", c)
+	}
+
+	// Enums carry the index as value and the name of the value
+	if f := c.Fields["doorlockstatusvehicle"]; f.Value != float64(2) || f.Name != "external locked" || !f.Timestamp.Equal(time.Unix(1000, 0)) {
+		t.Errorf("unexpected enum field %+v", f)
+	}
+
+	if f := c.Fields["positionHeading"]; f.Value != 90.5 || f.Name != "" {
+		t.Errorf("unexpected float field %+v", f)
+	}
+}
+
+func TestAPINotFound(t *testing.T) {
+	srv := newTestAPI(t, nil)
+
+	for _, path := range []string{
+		apiPrefixV1 + "/WDB999999ZZZ99999",
+		apiPrefixV1 + "/WDB999999ZZZ99999/" + string(mercedes.ContainerLockStatus),
+		apiPrefixV1 + "/" + testVehicleID + "/unknown",
+		apiPrefixV1 + "/" + testVehicleID + "/" + string(mercedes.ContainerLockStatus) + "/extra",
+	} {
+		var e apiError
+		if status := apiGet(t, srv, path, &e); status != http.StatusNotFound || e.Error == "" {
+			t.Errorf("%s: expected 404 with error, got %d %+v", path, status, e)
+		}
+	}
+}
+
+func TestAPIMethodNotAllowed(t *testing.T) {
+	srv := newTestAPI(t, nil)
+
+	resp, err := srv.Client().Post(srv.URL+apiPrefixV1, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("requesting API: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405, got %d", resp.StatusCode)
+	}
+}
+
+func TestAPIHidesVIN(t *testing.T) {
+	hashed := vehicle.NewRegistry(vehicle.VINModeHash, "salt")
+
+	hidden := vehicle.NewRegistry(vehicle.VINModeHide, "")
+	hidden.Set(map[string]vehicle.Metadata{testVehicleID: {Name: "Family Car"}})
+
+	for name, tc := range map[string]struct {
+		vehicles *vehicle.Registry
+		id       string
+	}{
+		"hash": {hashed, hashed.ID(testVehicleID)},
+		"hide": {hidden, "Family Car"},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			srv := newTestAPI(t, tc.vehicles)
+
+			var vs []apiVehicle
+			apiGet(t, srv, apiPrefixV1, &vs)
+			if len(vs) != 1 || vs[0].VehicleID != tc.id {
+				t.Errorf("expected vehicle-id %q, got %+v", tc.id, vs)
+			}
+
+			var v apiVehicle
+			if status := apiGet(t, srv, apiPrefixV1+"/"+url.PathEscape(tc.id), &v); status != http.StatusOK || v.VehicleID != tc.id {
+				t.Errorf("expected vehicle to be found by %q, got %d %+v", tc.id, status, v)
+			}
+
+			var e apiError
+			if status := apiGet(t, srv, apiPrefixV1+"/"+testVehicleID, &e); status != http.StatusNotFound {
+				t.Errorf("expected VIN not to be accepted, got %d", status)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mockapi"
+	"github.com/Luzifer/rconfig/v2"
+)
+
+var cfg = struct {
+	ClientID      string        `flag:"client-id" default:"mock-client" description:"Client-ID to accept"`
+	ClientSecret  string        `flag:"client-secret" default:"mock-secret" description:"Client-Secret to accept"`
+	Listen        string        `flag:"listen" default:"127.0.0.1:3001" description:"Port/IP to listen on"`
+	LogLevel      string        `flag:"log-level" default:"info" description:"Log level (debug, info, warn, error, fatal)"`
+	TokenLifetime time.Duration `flag:"token-lifetime" default:"1h" description:"Lifetime of issued access tokens"`
+	VehicleID     []string      `flag:"vehicle-id" default:"WDB111111ZZZ22222" description:"Vehicle identification numbers to serve demo data for"`
+}{}
+
+func main() {
+	rconfig.AutoEnv(true)
+	if err := rconfig.ParseAndValidate(&cfg); err != nil {
+		logrus.WithError(err).Fatal("parsing cli options")
+	}
+
+	l, err := logrus.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		logrus.WithError(err).Fatal("parsing log-level")
+	}
+	logrus.SetLevel(l)
+
+	srv := mockapi.New(cfg.ClientID, cfg.ClientSecret)
+	srv.TokenLifetime = cfg.TokenLifetime
+	for _, vehicleID := range cfg.VehicleID {
+		srv.AddDemoVehicle(vehicleID)
+	}
+
+	base := "http://" + cfg.Listen
+	logrus.WithFields(logrus.Fields{
+		"api-base-url":    base + mockapi.PathAPI,
+		"oauth-auth-url":  base + mockapi.PathAuth,
+		"oauth-token-url": base + mockapi.PathToken,
+		"vehicle-id":      strings.Join(cfg.VehicleID, ","),
+	}).Info("mock BYOCAR server started")
+
+	server := http.Server{
+		Addr: cfg.Listen,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logrus.WithFields(logrus.Fields{"method": r.Method, "path": r.URL.Path}).Debug("request received")
+			srv.ServeHTTP(w, r)
+		}),
+		ReadHeaderTimeout: time.Second,
+	}
+	if err = server.ListenAndServe(); err != nil {
+		logrus.WithError(err).Fatal("HTTP server exitted unexpectedly")
+	}
+}
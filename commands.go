@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/credential"
+)
+
+// runCommand executes the command given as positional argument instead
+// of starting the exporter and exits afterwards
+func runCommand(args []string) error {
+	switch args[0] {
+	case "migrate-credentials":
+		if err := migrateCredentials(); err != nil {
+			return errors.Wrap(err, "migrating credentials")
+		}
+
+	default:
+		return errors.Errorf("unknown command %q (available: migrate-credentials)", args[0])
+	}
+
+	os.Exit(0)
+	return nil
+}
+
+// migrateCredentials encrypts the plaintext credential-file using the
+// configured credential-key
+func migrateCredentials() error {
+	key, err := cfg.credentialKey()
+	if err != nil {
+		return errors.Wrap(err, "getting credential-key")
+	}
+
+	if key == "" {
+		return errors.New("credential-key or credential-key-file is required")
+	}
+
+	if err = credential.EncryptJSONFile(cfg.CredentialFile, key); err != nil {
+		return errors.Wrap(err, "encrypting credential-file")
+	}
+
+	logrus.WithField("file", cfg.CredentialFile).Info("credential-file encrypted")
+	return nil
+}
@@ -2,32 +2,67 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"os"
+	"strings"
 	"time"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/scheduler"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/vehicle"
 )
 
 type (
 	cliConfig struct {
-		ClientID       string        `flag:"client-id" default:"" description:"Client-ID of Mercedes Developers Console App"`
-		ClientSecret   string        `flag:"client-secret" default:"" description:"Client-Secret of Mercedes Developers Console App"`
-		CredentialFile string        `flag:"credential-file" default:"credentials.json" description:"Where to store tokens when using client-id from CLI parameters"`
-		FetchInterval  time.Duration `flag:"fetch-interval" default:"15m" description:"How often to ask the Mercedes API for updates"`
-		InfluxExport   string        `flag:"influx-export" default:"" description:"Set to url (http[s]://user:pass@host[:port]/database) to enable Influx exporter"`
-		Listen         string        `flag:"listen" default:":3000" description:"Port/IP to listen on"`
-		LogLevel       string        `flag:"log-level" default:"info" description:"Log level (debug, info, warn, error, fatal)"`
-		RedirectURL    string        `flag:"redirect-url" default:"http://127.0.0.1:3000/store-token" description:"Redirect URL registered in Mercedes Developers Console"`
-		VaultKey       string        `flag:"vault-key" default:"" description:"Use credentials from and update in Vault"`
-		VehicleID      []string      `flag:"vehicle-id" default:"" description:"Vehicle identification number (e.g. WDB111111ZZZ22222)"`
-		VersionAndExit bool          `flag:"version" default:"false" description:"Prints current version and exits"`
+		APIBaseURL          string        `flag:"api-base-url" default:"" description:"Override base URL of the Mercedes vehicle data API (e.g. for a mock server)"`
+		APIRetries          int           `flag:"api-retries" default:"3" description:"How often to retry failed API requests (server errors, rate limiting, network errors)"`
+		APIRetryDelay       time.Duration `flag:"api-retry-delay" default:"1s" description:"Initial delay between retries, doubled on every retry"`
+		APIRetryMax         time.Duration `flag:"api-retry-max-delay" default:"30s" description:"Maximum delay between retries"`
+		ClientID            string        `flag:"client-id" default:"" description:"Client-ID of Mercedes Developers Console App"`
+		ClientSecret        string        `flag:"client-secret" default:"" description:"Client-Secret of Mercedes Developers Console App"`
+		Config              string        `flag:"config" default:"" description:"Path to YAML config file with vehicle, exporter and credential settings (flags take precedence)"`
+		ConfigWatchInterval time.Duration `flag:"config-watch-interval" default:"10s" description:"How often to check the config file for changes to reload it (0 = reload on SIGHUP only)"`
+		CredentialFile      string        `flag:"credential-file" default:"credentials.json" description:"Where to store tokens when using client-id from CLI parameters"`
+		CredentialKey       string        `flag:"credential-key" default:"" description:"Passphrase to encrypt the credential-file with (empty = store plaintext)"`
+		CredentialKeyFile   string        `flag:"credential-key-file" default:"" description:"File to read the credential-key from"`
+		DailyQuota          int           `flag:"daily-quota" default:"0" description:"Number of API requests allowed per day, intervals are stretched to stay within (0 = unlimited)"`
+		FetchInterval       time.Duration `flag:"fetch-interval" default:"15m" description:"How often to ask the Mercedes API for updates (shortest interval when adaptive polling applies)"`
+		FetchTimeout        time.Duration `flag:"fetch-timeout" default:"0" description:"Maximum duration of one fetch cycle for all vehicles (0 = fetch-interval)"`
+		FetchWorkers        int           `flag:"fetch-workers" default:"4" description:"How many requests to execute against the Mercedes API concurrently"`
+		ForcePushInterval   time.Duration `flag:"force-push-interval" default:"1h" description:"When skipping unchanged data push all fields again after this interval (0 = never)"`
+		Influx2Export       string        `flag:"influx2-export" default:"" description:"Set to url (http[s]://:token@host[:port]/org/bucket) to enable InfluxDB v2 exporter"`
+		InfluxExport        string        `flag:"influx-export" default:"" description:"Set to url (http[s]://user:pass@host[:port]/database) to enable Influx exporter"`
+		K8sSecret           string        `flag:"k8s-secret" default:"" description:"Use credentials from and update in Kubernetes Secret ([namespace/]name, in-cluster only)"`
+		Listen              string        `flag:"listen" default:":3000" description:"Port/IP to listen on"`
+		LogLevel            string        `flag:"log-level" default:"info" description:"Log level (debug, info, warn, error, fatal)"`
+		MaxFetchAge         time.Duration `flag:"max-fetch-age" default:"0" description:"Report not ready when a vehicle had no successful fetch for this long (0 = three times its current fetch interval)"`
+		MaxFetchInterval    time.Duration `flag:"max-fetch-interval" default:"1h" description:"Longest interval to slow down to overnight or when data does not change (quota may enforce longer intervals)"`
+		MetricsListen       string        `flag:"metrics-listen" default:"" description:"Port/IP to serve metrics on separately (empty = serve on listen)"`
+		MetricsPath         string        `flag:"metrics-path" default:"/metrics" description:"Path to serve the metrics on"`
+		MQTTExport          string        `flag:"mqtt-export" default:"" description:"Set to url (tcp|ssl|ws|wss://[user:pass@]host[:port][/topic-prefix]) to enable MQTT exporter"`
+		OAuthAuthURL        string        `flag:"oauth-auth-url" default:"" description:"Override OAuth2 authorization endpoint"`
+		OAuthTokenURL       string        `flag:"oauth-token-url" default:"" description:"Override OAuth2 token endpoint"`
+		PrometheusTimestamp bool          `flag:"prometheus-timestamps" default:"false" description:"Expose samples with the time the vehicle reported them instead of the scrape time (Prometheus rejects samples older than ~1h)"`
+		PrometheusSeriesTTL time.Duration `flag:"prometheus-series-ttl" default:"0" description:"Remove values from Prometheus the vehicle did not report again within this duration (0 = keep forever)"`
+		QuietHours          string        `flag:"quiet-hours" default:"" description:"Range of local hours to poll less often (e.g. 22-6)"`
+		QuietSlowdown       int           `flag:"quiet-slowdown" default:"4" description:"Factor to stretch the fetch-interval by during quiet-hours"`
+		RedirectURL         string        `flag:"redirect-url" default:"http://127.0.0.1:3000/store-token" description:"Redirect URL registered in Mercedes Developers Console"`
+		ShutdownTimeout     time.Duration `flag:"shutdown-timeout" default:"20s" description:"How long to wait for running fetches and exporters to finish on shutdown (keep below the termination grace period)"`
+		SkipUnchanged       bool          `flag:"skip-unchanged" default:"true" description:"Only submit fields to exporters whose reported timestamp or value changed"`
+		VaultKey            string        `flag:"vault-key" default:"" description:"Use credentials from and update in Vault"`
+		VehicleID           []string      `flag:"vehicle-id" default:"" description:"Vehicle identification number (e.g. WDB111111ZZZ22222)"`
+		VINHashSalt         string        `flag:"vin-hash-salt" default:"" description:"Salt to prepend to the VIN before hashing it in vin-mode hash (required in that mode)"`
+		VINMode             string        `flag:"vin-mode" default:"plain" description:"How to expose the VIN in metrics (plain, hash, hide)"`
+		VersionAndExit      bool          `flag:"version" default:"false" description:"Prints current version and exits"`
 	}
 )
 
 func (c cliConfig) Validate() error {
 	switch {
 	case len(c.VehicleID) == 0:
-		return errors.New("at least one vehicle-id is required")
+		return errors.New("at least one vehicle-id is required (flag or config file)")
 
-	case c.VaultKey == "" && c.ClientID == "":
-		return errors.New("either vault-key or client-id/secret is required")
+	case c.VaultKey == "" && c.ClientID == "" && c.K8sSecret == "":
+		return errors.New("either vault-key, k8s-secret or client-id/secret is required")
 
 	case c.ClientID != "" && c.ClientSecret == "":
 		return errors.New("client-id is set and client-secret is not")
@@ -35,8 +70,105 @@ func (c cliConfig) Validate() error {
 	case c.ClientID != "" && c.VaultKey != "":
 		return errors.New("client-id and vault-key are configured, use only one of them")
 
+	case c.K8sSecret != "" && (c.ClientID != "" || c.VaultKey != ""):
+		return errors.New("k8s-secret and client-id or vault-key are configured, use only one of them")
+
+	case c.CredentialKey != "" && c.CredentialKeyFile != "":
+		return errors.New("credential-key and credential-key-file are configured, use only one of them")
+
+	case c.APIRetries < 0:
+		return errors.New("api-retries must not be negative")
+
+	case c.ForcePushInterval < 0:
+		return errors.New("force-push-interval must not be negative")
+
+	case c.DailyQuota < 0:
+		return errors.New("daily-quota must not be negative")
+
+	case c.QuietSlowdown < 1:
+		return errors.New("quiet-slowdown must be at least 1")
+
+	case c.ConfigWatchInterval < 0:
+		return errors.New("config-watch-interval must not be negative")
+
+	case c.MaxFetchAge < 0:
+		return errors.New("max-fetch-age must not be negative")
+
+	case !strings.HasPrefix(c.MetricsPath, "/"):
+		return errors.New("metrics-path must start with /")
+
+	case c.PrometheusSeriesTTL < 0:
+		return errors.New("prometheus-series-ttl must not be negative")
+
+	case c.ShutdownTimeout <= 0:
+		return errors.New("shutdown-timeout must be positive")
+
+	case c.FetchWorkers < 1:
+		return errors.New("fetch-workers must be at least 1")
+
+	case c.FetchTimeout < 0 || c.FetchTimeout > c.FetchInterval:
+		return errors.New("fetch-timeout must not be negative and must not exceed fetch-interval")
+
+	case c.QuietHours != "" && !validQuietHours(c.QuietHours):
+		return errors.New("quiet-hours must be formatted as <start>-<end> hours (e.g. 22-6)")
+
+	case !validVINMode(c.VINMode):
+		return errors.New("vin-mode must be one of plain, hash, hide")
+
+	case c.VINMode == string(vehicle.VINModeHash) && c.VINHashSalt == "":
+		// Without salt the hash of a VIN can be looked up by hashing all
+		// VINs of a manufacturer
+		return errors.New("vin-hash-salt is required in vin-mode hash")
+
 	default:
 		// No errors
 		return nil
 	}
 }
+
+// credentialKey returns the passphrase for the credential-file, empty
+// if the file is not encrypted
+func (c cliConfig) credentialKey() (string, error) {
+	if c.CredentialKeyFile == "" {
+		return c.CredentialKey, nil
+	}
+
+	key, err := os.ReadFile(c.CredentialKeyFile)
+	if err != nil {
+		return "", fmt.Errorf("reading credential-key-file: %w", err)
+	}
+
+	return strings.TrimSpace(string(key)), nil
+}
+
+// validateVehicles checks the merged vehicle configs against the
+// global settings
+func validateVehicles(c cliConfig, vehicles []vehicleConfig) error {
+	if c.VINMode != string(vehicle.VINModeHide) {
+		return nil
+	}
+
+	// Without VIN the name is the only way to tell vehicles apart
+	names := make(map[string]bool, len(vehicles))
+	for _, v := range vehicles {
+		if v.Name == "" {
+			return fmt.Errorf("vehicle %s needs a name when vin-mode is hide", v.ID)
+		}
+		if names[v.Name] {
+			return fmt.Errorf("vehicle name %q is used twice, names must be unique when vin-mode is hide", v.Name)
+		}
+		names[v.Name] = true
+	}
+
+	return nil
+}
+
+func validQuietHours(s string) bool {
+	_, _, err := scheduler.ParseQuietHours(s)
+	return err == nil
+}
+
+func validVINMode(s string) bool {
+	_, err := vehicle.ParseVINMode(s)
+	return err == nil
+}
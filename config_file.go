@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/fetcher"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/vehicle"
+)
+
+type (
+	// fileConfig is the structure of the optional YAML config file
+	fileConfig struct {
+		Credentials fileCredentials `yaml:"credentials"`
+		Exporters   fileExporters   `yaml:"exporters"`
+		Vehicles    []fileVehicle   `yaml:"vehicles"`
+	}
+
+	fileCredentials struct {
+		ClientID     string `yaml:"client-id"`
+		ClientSecret string `yaml:"client-secret"`
+		File         string `yaml:"file"`
+		Key          string `yaml:"key"`
+		KeyFile      string `yaml:"key-file"`
+		K8sSecret    string `yaml:"k8s-secret"`
+		VaultKey     string `yaml:"vault-key"`
+	}
+
+	fileExporter struct {
+		URL string `yaml:"url"`
+		// Options are added as query parameters to the URL
+		Options map[string]string `yaml:"options"`
+	}
+
+	fileExporters struct {
+		InfluxDB   *fileExporter `yaml:"influxdb"`
+		InfluxDBv2 *fileExporter `yaml:"influxdb2"`
+		MQTT       *fileExporter `yaml:"mqtt"`
+	}
+
+	fileVehicle struct {
+		ID         string        `yaml:"id"`
+		Name       string        `yaml:"name"`
+		Owner      string        `yaml:"owner"`
+		Model      string        `yaml:"model"`
+		FuelType   string        `yaml:"fuel-type"`
+		Containers []string      `yaml:"containers"`
+		Interval   time.Duration `yaml:"interval"`
+	}
+
+	// vehicleConfig is the merged configuration of one vehicle
+	vehicleConfig struct {
+		fetcher.Vehicle
+		vehicle.Metadata
+	}
+)
+
+var (
+	yamlDurationError = regexp.MustCompile("cannot unmarshal !!\\w+ (`[^`]*`) into time.Duration")
+	yamlTypeName      = regexp.MustCompile(`(\[\])?main\.\w+`)
+	yamlUnknownField  = regexp.MustCompile(`field (\S+) not found in type`)
+
+	// yamlTypeNames maps the types of the config file to the sections
+	// named in error messages
+	yamlTypeNames = map[string]string{
+		"main.fileConfig":      "config file",
+		"main.fileCredentials": "credentials",
+		"main.fileExporter":    "exporter",
+		"main.fileExporters":   "exporters",
+		"main.fileVehicle":     "vehicle",
+		"[]main.fileVehicle":   "list of vehicles",
+	}
+)
+
+// loadConfigFile reads the config file and merges it into the CLI
+// config: flags and environment variables take precedence over the
+// file, vehicles are merged by their ID.
+func loadConfigFile(c *cliConfig, filename string) ([]vehicleConfig, error) {
+	f, err := os.Open(filename) //nolint:gosec // Intended to read user given file
+	if err != nil {
+		return nil, errors.Wrap(err, "opening config file")
+	}
+	defer f.Close()
+
+	var fc fileConfig
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err = dec.Decode(&fc); err != nil {
+		return nil, errors.Wrap(describeYAMLError(err), "decoding config file")
+	}
+
+	vehicles, err := fc.vehicles(c.FetchInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = fc.Exporters.apply(c); err != nil {
+		return nil, err
+	}
+
+	fc.Credentials.apply(c)
+
+	// Vehicles given through flags are added with default settings
+	// unless they are configured in the file
+	known := make(map[string]bool, len(vehicles))
+	for _, v := range vehicles {
+		known[v.ID] = true
+	}
+	for _, id := range c.VehicleID {
+		if !known[id] {
+			vehicles = append(vehicles, vehicleConfig{Vehicle: fetcher.Vehicle{ID: id}})
+			known[id] = true
+		}
+	}
+
+	c.VehicleID = make([]string, 0, len(vehicles))
+	for _, v := range vehicles {
+		c.VehicleID = append(c.VehicleID, v.ID)
+	}
+
+	return vehicles, nil
+}
+
+// describeYAMLError rewrites the type errors of the decoder to name
+// the keys and sections of the config file instead of Go types
+func describeYAMLError(err error) error {
+	var te *yaml.TypeError
+	if !errors.As(err, &te) {
+		return err
+	}
+
+	msgs := make([]string, 0, len(te.Errors))
+	for _, msg := range te.Errors {
+		msg = yamlDurationError.ReplaceAllString(msg, "invalid duration $1 (expected a value like 90s, 15m or 2h)")
+		msg = yamlUnknownField.ReplaceAllString(msg, `unknown key "$1" in`)
+		msg = yamlTypeName.ReplaceAllStringFunc(msg, func(name string) string {
+			if section, ok := yamlTypeNames[name]; ok {
+				return section
+			}
+			return name
+		})
+		msgs = append(msgs, msg)
+	}
+
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+// vehiclesFromFlags creates the vehicle configs when no config file
+// is used
+func vehiclesFromFlags(c cliConfig) []vehicleConfig {
+	out := make([]vehicleConfig, 0, len(c.VehicleID))
+	for _, id := range c.VehicleID {
+		out = append(out, vehicleConfig{Vehicle: fetcher.Vehicle{ID: id}})
+	}
+	return out
+}
+
+// cliDefault returns the default value of the given cliConfig field
+// to detect whether the flag was changed
+func cliDefault(field string) string {
+	f, _ := reflect.TypeOf(cliConfig{}).FieldByName(field)
+	return f.Tag.Get("default")
+}
+
+func (f fileConfig) vehicles(fetchInterval time.Duration) ([]vehicleConfig, error) {
+	var (
+		out  = make([]vehicleConfig, 0, len(f.Vehicles))
+		seen = make(map[string]int, len(f.Vehicles))
+	)
+
+	for i, v := range f.Vehicles {
+		path := fmt.Sprintf("vehicles[%d]", i)
+
+		if v.ID == "" {
+			return nil, errors.Errorf("%s.id: is required", path)
+		}
+
+		if prev, ok := seen[v.ID]; ok {
+			return nil, errors.Errorf("%s.id: vehicle %q is already defined in vehicles[%d]", path, v.ID, prev)
+		}
+		seen[v.ID] = i
+
+		if v.Interval < 0 || (v.Interval > 0 && v.Interval < fetchInterval) {
+			return nil, errors.Errorf("%s.interval: must not be shorter than fetch-interval (%s)", path, fetchInterval)
+		}
+
+		vc := vehicleConfig{
+			Vehicle: fetcher.Vehicle{ID: v.ID, Interval: v.Interval},
+			Metadata: vehicle.Metadata{
+				Name:     v.Name,
+				Owner:    v.Owner,
+				Model:    v.Model,
+				FuelType: v.FuelType,
+			},
+		}
+
+		for j, name := range v.Containers {
+			container, err := mercedes.ParseContainer(name)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s.containers[%d]", path, j)
+			}
+			vc.Containers = append(vc.Containers, container)
+		}
+
+		out = append(out, vc)
+	}
+
+	return out, nil
+}
+
+func (f fileCredentials) apply(c *cliConfig) {
+	if c.ClientID == "" && c.VaultKey == "" && c.K8sSecret == "" {
+		c.ClientID = f.ClientID
+		c.ClientSecret = f.ClientSecret
+		c.K8sSecret = f.K8sSecret
+		c.VaultKey = f.VaultKey
+	}
+
+	if f.File != "" && c.CredentialFile == cliDefault("CredentialFile") {
+		c.CredentialFile = f.File
+	}
+
+	if c.CredentialKey == "" && c.CredentialKeyFile == "" {
+		c.CredentialKey = f.Key
+		c.CredentialKeyFile = f.KeyFile
+	}
+}
+
+func (f fileExporters) apply(c *cliConfig) error {
+	for _, e := range []struct {
+		name   string
+		block  *fileExporter
+		target *string
+	}{
+		{"influxdb", f.InfluxDB, &c.InfluxExport},
+		{"influxdb2", f.InfluxDBv2, &c.Influx2Export},
+		{"mqtt", f.MQTT, &c.MQTTExport},
+	} {
+		if e.block == nil || *e.target != "" {
+			// Exporter not configured in file or overridden by flag
+			continue
+		}
+
+		u, err := e.block.url()
+		if err != nil {
+			return errors.Wrapf(err, "exporters.%s", e.name)
+		}
+		*e.target = u
+	}
+
+	return nil
+}
+
+func (f fileExporter) url() (string, error) {
+	if f.URL == "" {
+		return "", errors.New("url: is required")
+	}
+
+	u, err := url.Parse(f.URL)
+	if err != nil {
+		return "", errors.Wrap(err, "url")
+	}
+
+	q := u.Query()
+	for k, v := range f.Options {
+		q.Set(k, v)
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/fetcher"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/vehicle"
+)
+
+const otherVehicleID = "WDB333333ZZZ44444"
+
+// testFlagConfig returns the config as parsed from the CLI without
+// any flags given
+func testFlagConfig() cliConfig {
+	return cliConfig{
+		CredentialFile: cliDefault("CredentialFile"),
+		FetchInterval:  15 * time.Minute,
+	}
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(filename, []byte(content), 0o600); err != nil {
+		t.Fatalf("writing config file: %s", err)
+	}
+
+	return filename
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	for name, tc := range map[string]struct {
+		file     string
+		flags    func(*cliConfig)
+		vehicles []vehicleConfig
+		check    func(*testing.T, cliConfig)
+	}{
+		"vehicles with settings": {
+			file: `
+vehicles:
+  - id: WDB111111ZZZ22222
+    name: Family Car
+    owner: Alex
+    model: EQA
+    fuel-type: electric
+    containers: [electricvehicle, vehiclelockstatus]
+    interval: 1h
+  - id: WDB333333ZZZ44444
+`,
+			vehicles: []vehicleConfig{
+				{
+					Vehicle: fetcher.Vehicle{
+						ID:         testVehicleID,
+						Containers: []mercedes.Container{mercedes.ContainerElectricStatus, mercedes.ContainerLockStatus},
+						Interval:   time.Hour,
+					},
+					Metadata: vehicle.Metadata{Name: "Family Car", Owner: "Alex", Model: "EQA", FuelType: "electric"},
+				},
+				{Vehicle: fetcher.Vehicle{ID: otherVehicleID}},
+			},
+			check: func(t *testing.T, c cliConfig) {
+				if expect := []string{testVehicleID, otherVehicleID}; !reflect.DeepEqual(c.VehicleID, expect) {
+					t.Errorf("expected vehicle-ids %v, got %v", expect, c.VehicleID)
+				}
+			},
+		},
+		"flag vehicles are merged": {
+			file: `
+vehicles:
+  - id: WDB111111ZZZ22222
+    name: Family Car
+`,
+			flags: func(c *cliConfig) { c.VehicleID = []string{otherVehicleID, testVehicleID} },
+			vehicles: []vehicleConfig{
+				{Vehicle: fetcher.Vehicle{ID: testVehicleID}, Metadata: vehicle.Metadata{Name: "Family Car"}},
+				{Vehicle: fetcher.Vehicle{ID: otherVehicleID}},
+			},
+			check: func(t *testing.T, c cliConfig) {
+				if expect := []string{testVehicleID, otherVehicleID}; !reflect.DeepEqual(c.VehicleID, expect) {
+					t.Errorf("expected vehicle-ids %v, got %v", expect, c.VehicleID)
+				}
+			},
+		},
+		"exporters with options": {
+			file: `
+exporters:
+  mqtt:
+    url: tcp://broker:1883/cars
+    options:
+      qos: "1"
+      retain: "false"
+  influxdb:
+    url: http://influx:8086/cars
+`,
+			vehicles: []vehicleConfig{},
+			check: func(t *testing.T, c cliConfig) {
+				if expect := "tcp://broker:1883/cars?qos=1&retain=false"; c.MQTTExport != expect {
+					t.Errorf("expected mqtt-export %q, got %q", expect, c.MQTTExport)
+				}
+				if expect := "http://influx:8086/cars"; c.InfluxExport != expect {
+					t.Errorf("expected influx-export %q, got %q", expect, c.InfluxExport)
+				}
+			},
+		},
+		"flags override exporters": {
+			file: `
+exporters:
+  mqtt:
+    url: tcp://broker:1883
+`,
+			flags:    func(c *cliConfig) { c.MQTTExport = "tcp://other:1883" },
+			vehicles: []vehicleConfig{},
+			check: func(t *testing.T, c cliConfig) {
+				if expect := "tcp://other:1883"; c.MQTTExport != expect {
+					t.Errorf("expected mqtt-export %q, got %q", expect, c.MQTTExport)
+				}
+			},
+		},
+		"credentials": {
+			file: `
+credentials:
+  client-id: file-id
+  client-secret: file-secret
+  file: /data/credentials.json
+  key: secret
+`,
+			vehicles: []vehicleConfig{},
+			check: func(t *testing.T, c cliConfig) {
+				if c.ClientID != "file-id" || c.ClientSecret != "file-secret" {
+					t.Errorf("expected client credentials from file, got %q / %q", c.ClientID, c.ClientSecret)
+				}
+				if c.CredentialFile != "/data/credentials.json" || c.CredentialKey != "secret" {
+					t.Errorf("expected credential file and key from file, got %q / %q", c.CredentialFile, c.CredentialKey)
+				}
+			},
+		},
+		"flags override credentials": {
+			file: `
+credentials:
+  client-id: file-id
+  client-secret: file-secret
+  file: /data/credentials.json
+`,
+			flags: func(c *cliConfig) {
+				c.VaultKey = "secret/mercedes"
+				c.CredentialFile = "/flag/credentials.json"
+			},
+			vehicles: []vehicleConfig{},
+			check: func(t *testing.T, c cliConfig) {
+				if c.ClientID != "" || c.VaultKey != "secret/mercedes" {
+					t.Errorf("expected flag credentials to be kept, got client-id %q, vault-key %q", c.ClientID, c.VaultKey)
+				}
+				if c.CredentialFile != "/flag/credentials.json" {
+					t.Errorf("expected credential file from flag, got %q", c.CredentialFile)
+				}
+			},
+		},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			c := testFlagConfig()
+			if tc.flags != nil {
+				tc.flags(&c)
+			}
+
+			vehicles, err := loadConfigFile(&c, writeConfigFile(t, tc.file))
+			if err != nil {
+				t.Fatalf("loading config file: %s", err)
+			}
+
+			if !reflect.DeepEqual(vehicles, tc.vehicles) {
+				t.Errorf("unexpected vehicles:\n got %+v\nwant %+v", vehicles, tc.vehicles)
+			}
+
+			tc.check(t, c)
+		})
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		file   string
+		expect string
+	}{
+		"unknown top level key": {
+			file:   "vehicle:\n  - id: WDB111111ZZZ22222\n",
+			expect: `decoding config file: line 1: unknown key "vehicle" in config file`,
+		},
+		"unknown vehicle key": {
+			file:   "vehicles:\n  - id: WDB111111ZZZ22222\n    colour: red\n",
+			expect: `decoding config file: line 3: unknown key "colour" in vehicle`,
+		},
+		"unknown exporter key": {
+			file:   "exporters:\n  mqtt:\n    url: tcp://broker:1883\n    qos: 1\n",
+			expect: `decoding config file: line 4: unknown key "qos" in exporter`,
+		},
+		"bad duration": {
+			file:   "vehicles:\n  - id: WDB111111ZZZ22222\n    interval: 1 hour\n",
+			expect: "decoding config file: line 3: invalid duration `1 hour` (expected a value like 90s, 15m or 2h)",
+		},
+		"duration without unit": {
+			file:   "vehicles:\n  - id: WDB111111ZZZ22222\n    interval: 60\n",
+			expect: "decoding config file: line 3: invalid duration `60` (expected a value like 90s, 15m or 2h)",
+		},
+		"vehicles not a list": {
+			file:   "vehicles:\n  id: WDB111111ZZZ22222\n",
+			expect: "decoding config file: line 2: cannot unmarshal !!map into list of vehicles",
+		},
+		"vehicle without id": {
+			file:   "vehicles:\n  - id: WDB111111ZZZ22222\n  - name: Family Car\n",
+			expect: "vehicles[1].id: is required",
+		},
+		"duplicate vehicle": {
+			file:   "vehicles:\n  - id: WDB111111ZZZ22222\n  - id: WDB111111ZZZ22222\n",
+			expect: `vehicles[1].id: vehicle "WDB111111ZZZ22222" is already defined in vehicles[0]`,
+		},
+		"interval below fetch-interval": {
+			file:   "vehicles:\n  - id: WDB111111ZZZ22222\n    interval: 5m\n",
+			expect: "vehicles[0].interval: must not be shorter than fetch-interval (15m0s)",
+		},
+		"unknown container": {
+			file:   "vehicles:\n  - id: WDB111111ZZZ22222\n    containers: [fuelstatus, tyres]\n",
+			expect: `vehicles[0].containers[1]: unknown container "tyres"`,
+		},
+		"exporter without url": {
+			file:   "exporters:\n  mqtt:\n    options:\n      qos: \"1\"\n",
+			expect: "exporters.mqtt: url: is required",
+		},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			c := testFlagConfig()
+
+			_, err := loadConfigFile(&c, writeConfigFile(t, tc.file))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if err.Error() != tc.expect {
+				t.Errorf("unexpected error:\n got %s\nwant %s", err, tc.expect)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/exporters"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/exporters/influxdb"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/exporters/influxdb2"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/exporters/mqtt"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/vehicle"
+)
+
+const (
+	exporterCloseTimeout = 30 * time.Second
+	exporterStartTimeout = 30 * time.Second
+)
+
+type (
+	// exporterSet holds the optional exporters and the URLs they were
+	// created from to take them over on reload
+	exporterSet struct {
+		names     []string
+		urls      map[string]string
+		instances map[string]exporters.Exporter
+	}
+
+	exporterDef struct {
+		name, desc string
+		url        string
+		create     func(url string) (exporters.Exporter, error)
+	}
+)
+
+// buildExporters creates the optional exporters configured in c.
+// Exporters whose URL did not change are taken over from prev to keep
+// their buffers and connections, new ones are started.
+func buildExporters(ctx context.Context, c cliConfig, vehicles *vehicle.Registry, prev *exporterSet) (*exporterSet, error) {
+	out := &exporterSet{
+		urls:      make(map[string]string),
+		instances: make(map[string]exporters.Exporter),
+	}
+
+	// created is released when a later exporter fails
+	var created exporters.Set
+
+	for _, def := range exporterDefs(c, vehicles) {
+		if def.url == "" {
+			continue
+		}
+
+		if prev != nil && prev.urls[def.name] == def.url {
+			out.names = append(out.names, def.name)
+			out.urls[def.name] = def.url
+			out.instances[def.name] = prev.instances[def.name]
+			continue
+		}
+
+		logrus.Infof("creating %s exporter", def.desc)
+		e, err := def.create(def.url)
+		if err != nil {
+			closeExporters(created)
+			return nil, errors.Wrapf(err, "creating %s exporter", def.desc)
+		}
+		created = append(created, e)
+
+		if st, ok := e.(exporters.Starter); ok {
+			if err = st.Start(ctx); err != nil {
+				closeExporters(created)
+				return nil, errors.Wrapf(err, "starting %s exporter", def.desc)
+			}
+		}
+
+		out.names = append(out.names, def.name)
+		out.urls[def.name] = def.url
+		out.instances[def.name] = e
+	}
+
+	return out, nil
+}
+
+func exporterDefs(c cliConfig, vehicles *vehicle.Registry) []exporterDef {
+	return []exporterDef{
+		{name: "influxdb", desc: "influxdb", url: c.InfluxExport, create: func(url string) (exporters.Exporter, error) {
+			return influxdb.New(url, vehicles)
+		}},
+
+		{name: "influxdb2", desc: "influxdb v2", url: c.Influx2Export, create: func(url string) (exporters.Exporter, error) {
+			return influxdb2.New(url, vehicles)
+		}},
+
+		{name: "mqtt", desc: "mqtt", url: c.MQTTExport, create: func(url string) (exporters.Exporter, error) {
+			return mqtt.New(url, vehicles)
+		}},
+	}
+}
+
+// set returns the exporters in a stable order
+func (s *exporterSet) set() exporters.Set {
+	out := exporters.Set{}
+	for _, name := range s.names {
+		out = append(out, s.instances[name])
+	}
+	return out
+}
+
+// replacedBy returns the exporters of s not taken over into next
+func (s *exporterSet) replacedBy(next *exporterSet) exporters.Set {
+	var out exporters.Set
+	for _, name := range s.names {
+		if next.instances[name] != s.instances[name] {
+			out = append(out, s.instances[name])
+		}
+	}
+	return out
+}
+
+func closeExporters(set exporters.Set) {
+	if len(set) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), exporterCloseTimeout)
+	defer cancel()
+
+	if err := set.Close(ctx); err != nil {
+		logrus.WithError(err).Error("closing replaced exporters")
+	}
+}
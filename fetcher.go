@@ -2,54 +2,62 @@ package main
 
 import (
 	"errors"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
-	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/fetcher"
 )
 
-func getCronFunc(mc mercedes.Client) func() {
-	return func() {
-		for i := range cfg.VehicleID {
-			runFetcher(mc, cfg.VehicleID[i])
-		}
-	}
-}
-
-func runFetcher(mc mercedes.Client, vehicleID string) {
-	logger := logrus.WithField("vehicle_id", vehicleID)
-	logger.Info("fetching data")
-
-	s1, err := mc.GetPayAsYouDriveInsurance(cfg.VehicleID[0])
-	handleMetricsEntries(logger, "pay-as-you-go", err, func() { enabledExporters.SetPayAsYouGo(vehicleID, s1) })
+// runCycle fetches the data of all due vehicles and accounts the
+// requests in the planner
+func (p *pipeline) runCycle() {
+	// Keep the configuration stable during the cycle
+	p.lock.RLock()
+	defer p.lock.RUnlock()
 
-	s2, err := mc.GetFuelStatus(cfg.VehicleID[0])
-	handleMetricsEntries(logger, "fuel-status", err, func() { enabledExporters.SetFuelStatus(vehicleID, s2) })
+	vehicles := p.current.Load().vehicles
+	logrus.WithField("vehicles", len(vehicles)).Info("fetching data")
 
-	s3, err := mc.GetVehicleStatus(cfg.VehicleID[0])
-	handleMetricsEntries(logger, "vehicle-status", err, func() { enabledExporters.SetVehicleStatus(vehicleID, s3) })
-
-	s4, err := mc.GetLockStatus(cfg.VehicleID[0])
-	handleMetricsEntries(logger, "lock-status", err, func() { enabledExporters.SetLockStatus(vehicleID, s4) })
-
-	s5, err := mc.GetElectricStatus(cfg.VehicleID[0])
-	handleMetricsEntries(logger, "electric-status", err, func() { enabledExporters.SetElectricStatus(vehicleID, s5) })
-
-	logger.Info("data updated")
-}
-
-func handleMetricsEntries(logger *logrus.Entry, dataType string, err error, submit func()) {
+	results, err := p.engine.Run(p.fetchCtx, fetchVehicles(vehicles))
 	switch {
 	case err == nil:
-		submit()
+		// Cycle completed, results are logged by the engine
 
-	case errors.Is(err, mercedes.ErrNoDataAvailable):
-		logger.Warnf("%s data is not available", dataType)
+	case errors.Is(err, fetcher.ErrCycleRunning):
+		// The running cycle accounts its own requests
+		logrus.Warn("previous fetch cycle still running, skipping this one")
 		return
 
 	default:
-		logger.WithError(err).Errorf("fetching %s data", dataType)
-		return
+		// Still accounted below, the cycle is no longer pending
+		logrus.WithError(err).Error("running fetch cycle")
+	}
+
+	var (
+		latestData time.Time
+		requests   int
+	)
+	for _, r := range results {
+		requests += r.Requests
+		if r.LatestData.After(latestData) {
+			latestData = r.LatestData
+		}
+	}
+
+	now := time.Now()
+	p.planner.RecordCycle(now, requests, latestData)
+	logrus.WithFields(logrus.Fields{
+		"quota_remaining": p.planner.Remaining(now),
+		"requests":        requests,
+	}).Debug("fetch cycle accounted")
+}
 
+// fetchVehicles converts the configured vehicles for the fetch engine
+func fetchVehicles(vehicles []vehicleConfig) []fetcher.Vehicle {
+	out := make([]fetcher.Vehicle, 0, len(vehicles))
+	for _, v := range vehicles {
+		out = append(out, v.Vehicle)
 	}
+	return out
 }
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/exporters"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/scheduler"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/state"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/vehicle"
+)
+
+const (
+	healthStatusFail    = "fail"
+	healthStatusOK      = "ok"
+	healthStatusPending = "pending"
+
+	// staleIntervals is the number of fetch intervals a vehicle may go
+	// without successful fetch when no max-fetch-age is configured
+	staleIntervals = 3
+)
+
+type (
+	// healthChecker checks the state of the parts the exporter depends
+	// on to tell whether it is able to deliver current data
+	healthChecker struct {
+		exporterHealth func() []exporters.Health
+		maxFetchAge    time.Duration
+		planner        *scheduler.Planner
+		registry       *vehicle.Registry
+		stateStore     *state.Store
+		// tokenState reports the token and the credential store state as
+		// of the last check of the token manager, probes must not query
+		// (possibly remote) stores themselves
+		tokenState func() mercedes.TokenState
+		vehicles   func() []vehicleConfig
+	}
+
+	healthCheck struct {
+		Name        string     `json:"name"`
+		Status      string     `json:"status"`
+		Message     string     `json:"message,omitempty"`
+		Expiry      *time.Time `json:"expiry,omitempty"`
+		LastSuccess *time.Time `json:"last_success,omitempty"`
+	}
+
+	healthReport struct {
+		Status string        `json:"status"`
+		Checks []healthCheck `json:"checks"`
+	}
+)
+
+// getLivenessHandler responds OK as long as the process serves
+// requests: neither missing tokens nor unavailable services are fixed
+// by restarting the process
+func getLivenessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("OK")) //nolint:errcheck,gosec // Client might be gone, nothing to do
+	}
+}
+
+// getReadinessHandler runs all checks and responds 503 if any of them
+// failed. The breakdown is rendered as JSON when the verbose parameter
+// is given.
+func getReadinessHandler(hc healthChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := hc.run(time.Now())
+
+		code := http.StatusOK
+		if report.Status != healthStatusOK {
+			code = http.StatusServiceUnavailable
+		}
+
+		if _, verbose := r.URL.Query()["verbose"]; verbose {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				logrus.WithError(err).Error("encoding health report")
+			}
+			return
+		}
+
+		var body strings.Builder
+		if code == http.StatusOK {
+			body.WriteString("OK\n")
+		} else {
+			body.WriteString("NOT READY\n")
+			for _, c := range report.Checks {
+				if c.Status == healthStatusFail {
+					body.WriteString(c.Name + ": " + c.Message + "\n")
+				}
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(code)
+		w.Write([]byte(body.String())) //nolint:errcheck,gosec // Client might be gone, nothing to do
+	}
+}
+
+// run executes all checks, the report fails if one of them failed
+func (h healthChecker) run(now time.Time) healthReport {
+	var checks []healthCheck
+	checks = append(checks, h.checkCredentials(now)...)
+	checks = append(checks, h.checkVehicles(now)...)
+	checks = append(checks, h.checkExporters()...)
+
+	report := healthReport{Status: healthStatusOK, Checks: checks}
+	for _, c := range checks {
+		if c.Status == healthStatusFail {
+			report.Status = healthStatusFail
+		}
+	}
+
+	return report
+}
+
+func (h healthChecker) checkCredentials(now time.Time) []healthCheck {
+	credCheck := healthCheck{Name: "credentials", Status: healthStatusOK}
+	tokenCheck := healthCheck{Name: "token", Status: healthStatusOK}
+
+	ts := h.tokenState()
+	switch {
+	case ts.CredentialsChecked.IsZero():
+		credCheck.Status, credCheck.Message = healthStatusPending, "credential store not checked yet"
+
+	case ts.CredentialsError != nil:
+		credCheck.Status, credCheck.Message = healthStatusFail, ts.CredentialsError.Error()
+
+	case !ts.HasCredentials:
+		credCheck.Status, credCheck.Message = healthStatusFail, "no token stored, authorize using /auth"
+	}
+
+	if credCheck.Status != healthStatusOK {
+		tokenCheck.Status, tokenCheck.Message = credCheck.Status, "credentials not available"
+		return []healthCheck{credCheck, tokenCheck}
+	}
+
+	expired := !ts.Expiry.IsZero() && ts.Expiry.Before(now)
+	switch {
+	case !ts.HasToken && ts.LastError != nil:
+		tokenCheck.Status, tokenCheck.Message = healthStatusFail, ts.LastError.Error()
+
+	case !ts.HasToken:
+		tokenCheck.Status, tokenCheck.Message = healthStatusPending, "token not loaded yet"
+
+	case expired && !ts.HasRefreshToken:
+		tokenCheck.Status, tokenCheck.Message = healthStatusFail, "access token expired and no refresh token available, authorize using /auth"
+
+	case expired && ts.LastError != nil:
+		tokenCheck.Status, tokenCheck.Message = healthStatusFail, "access token expired, refresh failed: "+ts.LastError.Error()
+
+	case expired:
+		// The access token is refreshed on the next request
+		tokenCheck.Message = "access token expired, will be refreshed on next fetch"
+
+	case ts.LastError != nil:
+		// Still usable, the refresh is retried in the background
+		tokenCheck.Message = "refresh failed, retrying: " + ts.LastError.Error()
+	}
+
+	if !ts.Expiry.IsZero() {
+		tokenCheck.Expiry = &ts.Expiry
+	}
+	if !ts.LastRefresh.IsZero() {
+		tokenCheck.LastSuccess = &ts.LastRefresh
+	}
+
+	return []healthCheck{credCheck, tokenCheck}
+}
+
+func (h healthChecker) checkExporters() []healthCheck {
+	var checks []healthCheck
+
+	for _, eh := range h.exporterHealth() {
+		c := healthCheck{Name: "exporter:" + eh.Name, Status: healthStatusOK}
+		if !eh.Healthy {
+			c.Status = healthStatusFail
+			if eh.LastError != nil {
+				c.Message = eh.LastError.Error()
+			}
+		}
+
+		if !eh.LastSuccessAt.IsZero() {
+			lastSuccess := eh.LastSuccessAt
+			c.LastSuccess = &lastSuccess
+		}
+
+		checks = append(checks, c)
+	}
+
+	return checks
+}
+
+func (h healthChecker) checkVehicles(now time.Time) []healthCheck {
+	var checks []healthCheck
+
+	for _, v := range h.vehicles() {
+		c := healthCheck{Name: "vehicle:" + h.registry.PublicID(v.ID), Status: healthStatusOK}
+
+		maxAge := h.maxFetchAge
+		if maxAge == 0 {
+			interval := h.planner.CurrentInterval(now)
+			if v.Interval > interval {
+				interval = v.Interval
+			}
+			maxAge = staleIntervals * interval
+		}
+
+		vs, ok := h.stateStore.Vehicle(v.ID)
+		lastSuccess, lastErr := vs.LastSuccess()
+
+		// Vehicles without successful fetch get maxAge from the time they
+		// were added (on startup or reload)
+		since := lastSuccess
+		switch {
+		case !ok:
+			since = now
+		case since.IsZero():
+			since = vs.AddedAt
+		}
+
+		switch {
+		case now.Sub(since) > maxAge:
+			c.Status, c.Message = healthStatusFail, "no successful fetch within "+maxAge.String()
+			if lastErr != nil {
+				c.Message += ": " + lastErr.Error()
+			}
+
+		case lastSuccess.IsZero():
+			c.Status, c.Message = healthStatusPending, "waiting for first successful fetch"
+		}
+
+		if !lastSuccess.IsZero() {
+			c.LastSuccess = &lastSuccess
+		}
+
+		checks = append(checks, c)
+	}
+
+	return checks
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/exporters"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/fetcher"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/scheduler"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/state"
+)
+
+func newTestHealthChecker(t *testing.T, ts mercedes.TokenState, vehicles ...vehicleConfig) healthChecker {
+	t.Helper()
+
+	planner, err := scheduler.New(scheduler.Options{MinInterval: 15 * time.Minute, MaxInterval: time.Hour}, 1)
+	if err != nil {
+		t.Fatalf("creating planner: %s", err)
+	}
+
+	return healthChecker{
+		exporterHealth: func() []exporters.Health { return nil },
+		planner:        planner,
+		stateStore:     state.New(),
+		tokenState:     func() mercedes.TokenState { return ts },
+		vehicles:       func() []vehicleConfig { return vehicles },
+	}
+}
+
+// checkStatus returns the status and message of the named check
+func checkStatus(t *testing.T, report healthReport, name string) (string, string) {
+	t.Helper()
+
+	for _, c := range report.Checks {
+		if c.Name == name {
+			return c.Status, c.Message
+		}
+	}
+
+	t.Fatalf("check %s not found in %+v", name, report.Checks)
+	return "", ""
+}
+
+func TestHealthCredentials(t *testing.T) {
+	var (
+		now     = time.Now()
+		checked = now.Add(-time.Minute)
+	)
+
+	for name, tc := range map[string]struct {
+		state       mercedes.TokenState
+		expectCreds string
+		expectToken string
+		expectMsg   string
+	}{
+		"not checked yet": {
+			state:       mercedes.TokenState{},
+			expectCreds: healthStatusPending,
+			expectToken: healthStatusPending,
+		},
+		"store error": {
+			state:       mercedes.TokenState{CredentialsChecked: checked, CredentialsError: errors.New("vault sealed")},
+			expectCreds: healthStatusFail,
+			expectToken: healthStatusFail,
+			expectMsg:   "vault sealed",
+		},
+		"no token stored": {
+			state:       mercedes.TokenState{CredentialsChecked: checked},
+			expectCreds: healthStatusFail,
+			expectToken: healthStatusFail,
+			expectMsg:   "no token stored, authorize using /auth",
+		},
+		"token not loaded": {
+			state:       mercedes.TokenState{CredentialsChecked: checked, HasCredentials: true},
+			expectCreds: healthStatusOK,
+			expectToken: healthStatusPending,
+		},
+		"valid token": {
+			state: mercedes.TokenState{
+				CredentialsChecked: checked, HasCredentials: true,
+				HasToken: true, HasRefreshToken: true, Expiry: now.Add(time.Hour),
+			},
+			expectCreds: healthStatusOK,
+			expectToken: healthStatusOK,
+		},
+		"expired without refresh token": {
+			state: mercedes.TokenState{
+				CredentialsChecked: checked, HasCredentials: true,
+				HasToken: true, Expiry: now.Add(-time.Minute),
+			},
+			expectCreds: healthStatusOK,
+			expectToken: healthStatusFail,
+		},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			report := newTestHealthChecker(t, tc.state).run(now)
+
+			status, msg := checkStatus(t, report, "credentials")
+			if status != tc.expectCreds {
+				t.Errorf("expected credentials %s, got %s (%s)", tc.expectCreds, status, msg)
+			}
+			if tc.expectMsg != "" && msg != tc.expectMsg {
+				t.Errorf("expected message %q, got %q", tc.expectMsg, msg)
+			}
+
+			if status, msg = checkStatus(t, report, "token"); status != tc.expectToken {
+				t.Errorf("expected token %s, got %s (%s)", tc.expectToken, status, msg)
+			}
+		})
+	}
+}
+
+func TestHealthVehicles(t *testing.T) {
+	var (
+		now = time.Now()
+		ts  = mercedes.TokenState{
+			CredentialsChecked: now, HasCredentials: true,
+			HasToken: true, HasRefreshToken: true, Expiry: now.Add(time.Hour),
+		}
+		v = vehicleConfig{Vehicle: fetcher.Vehicle{ID: testVehicleID}}
+	)
+
+	hc := newTestHealthChecker(t, ts, v)
+	hc.stateStore.SetVehicles(map[string]string{testVehicleID: ""})
+
+	if status, _ := checkStatus(t, hc.run(now), "vehicle:"+testVehicleID); status != healthStatusPending {
+		t.Errorf("expected vehicle without fetch to be pending, got %s", status)
+	}
+
+	// Three intervals of 15m without successful fetch
+	if status, msg := checkStatus(t, hc.run(now.Add(time.Hour)), "vehicle:"+testVehicleID); status != healthStatusFail || !strings.HasPrefix(msg, "no successful fetch within 45m0s") {
+		t.Errorf("expected stale vehicle to fail, got %s (%s)", status, msg)
+	}
+
+	hc.stateStore.SetFuelStatus(testVehicleID, mercedes.FuelStatus{RangeLiquid: mercedes.NewTimedInt(500, time.Unix(1000, 0))})
+	hc.stateStore.RecordFetch(testVehicleID, mercedes.ContainerFuelStatus, nil)
+	if status, _ := checkStatus(t, hc.run(time.Now()), "vehicle:"+testVehicleID); status != healthStatusOK {
+		t.Errorf("expected fetched vehicle to be ok, got %s", status)
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	hc := newTestHealthChecker(t, mercedes.TokenState{CredentialsChecked: time.Now()})
+	hc.exporterHealth = func() []exporters.Health {
+		return []exporters.Health{{Name: "mqtt", Healthy: false, LastError: errors.New("not connected to broker")}}
+	}
+	handler := getReadinessHandler(hc)
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503, got %d", rec.Code)
+	}
+	for _, line := range []string{"NOT READY", "credentials: no token stored, authorize using /auth", "exporter:mqtt: not connected to broker"} {
+		if !strings.Contains(rec.Body.String(), line+"\n") {
+			t.Errorf("expected %q in body %q", line, rec.Body.String())
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/readyz?verbose", nil))
+
+	var report healthReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("decoding verbose report: %s", err)
+	}
+	if report.Status != healthStatusFail || len(report.Checks) != 3 { //nolint:gomnd // Credentials, token, exporter
+		t.Errorf("unexpected verbose report %+v", report)
+	}
+}
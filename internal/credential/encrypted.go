@@ -0,0 +1,283 @@
+package credential
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	encryptedFormatVersion = 1
+	encryptedKDF           = "scrypt"
+
+	// scrypt parameters recommended for interactive logins, the key is
+	// derived once per salt and cached
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	scryptSalt   = 16
+)
+
+type (
+	// EncryptedJSONStore keeps the token in a file like the JSONStore
+	// but encrypts it using AES-256-GCM with a key derived from a
+	// passphrase
+	EncryptedJSONStore struct {
+		clientID, clientSecret string
+
+		file       storeFile
+		passphrase []byte
+		key        *derivedKey
+	}
+
+	// encryptedContent is the envelope written to disk, the plaintext
+	// is the JSON encoded jsonContent
+	encryptedContent struct {
+		Version int    `json:"version"`
+		KDF     string `json:"kdf"`
+		Salt    []byte `json:"salt"`
+		Nonce   []byte `json:"nonce"`
+		Data    []byte `json:"data"`
+	}
+
+	derivedKey struct {
+		lock sync.Mutex
+		salt []byte
+		key  []byte
+	}
+)
+
+var _ Store = EncryptedJSONStore{}
+
+// ErrNotEncrypted is returned when reading a plaintext file through
+// the EncryptedJSONStore
+var ErrNotEncrypted = errors.New("credential file is not encrypted")
+
+func NewEncryptedJSONStore(filename, clientID, clientSecret, passphrase string) (EncryptedJSONStore, error) {
+	store := EncryptedJSONStore{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		file:         newStoreFile(filename),
+		passphrase:   []byte(passphrase),
+		key:          &derivedKey{},
+	}
+
+	if passphrase == "" {
+		return store, errors.New("empty passphrase")
+	}
+
+	_, _, _, err := store.GetToken()
+	switch {
+	case errors.Is(err, nil), errors.Is(err, fs.ErrNotExist):
+		return store, nil
+
+	default:
+		return store, errors.Wrap(err, "probing store file")
+	}
+}
+
+// EncryptJSONFile replaces the plaintext file written by the JSONStore
+// with its encrypted form
+func EncryptJSONFile(filename, passphrase string) error {
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return errors.Wrap(err, "reading store file")
+	}
+
+	if isEncrypted(raw) {
+		return errors.New("credential file is already encrypted")
+	}
+
+	var c jsonContent
+	if err = json.Unmarshal(raw, &c); err != nil {
+		return errors.Wrap(err, "decoding plaintext store")
+	}
+
+	store, err := NewEncryptedJSONStore(filename, "", "", passphrase)
+	if err != nil && !errors.Is(err, ErrNotEncrypted) {
+		return errors.Wrap(err, "creating encrypted store")
+	}
+
+	if err = store.UpdateToken(c.AccessToken, c.RefreshToken, c.Expiry); err != nil {
+		return err
+	}
+
+	// The backups still contain the plaintext token
+	if err = os.Remove(store.file.backupName()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return errors.Wrap(err, "removing plaintext backup")
+	}
+
+	return nil
+}
+
+func (e EncryptedJSONStore) GetClientCredentials() (clientID, clientSecret string, err error) {
+	return e.clientID, e.clientSecret, nil
+}
+
+func (e EncryptedJSONStore) GetToken() (accessToken, refreshToken string, expiry time.Time, err error) {
+	var c jsonContent
+
+	if err = e.file.load(func(data []byte) (err error) {
+		c, err = e.decrypt(data)
+		return err
+	}); err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	return c.AccessToken, c.RefreshToken, c.Expiry, nil
+}
+
+func (e EncryptedJSONStore) HasCredentials() (bool, error) {
+	_, r, _, err := e.GetToken()
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return false, nil
+
+	case errors.Is(err, nil):
+		return r != "", nil
+
+	default:
+		return false, errors.Wrap(err, "getting credentials")
+	}
+}
+
+func (e EncryptedJSONStore) UpdateToken(accessToken, refreshToken string, expiry time.Time) error {
+	plain, err := json.Marshal(jsonContent{accessToken, refreshToken, expiry})
+	if err != nil {
+		return errors.Wrap(err, "encoding token")
+	}
+
+	salt, key, err := e.key.current(e.passphrase)
+	if err != nil {
+		return errors.Wrap(err, "deriving key")
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	env := encryptedContent{
+		Version: encryptedFormatVersion,
+		KDF:     encryptedKDF,
+		Salt:    salt,
+		Nonce:   make([]byte, aead.NonceSize()),
+	}
+	if _, err = io.ReadFull(rand.Reader, env.Nonce); err != nil {
+		return errors.Wrap(err, "generating nonce")
+	}
+	env.Data = aead.Seal(nil, env.Nonce, plain, nil)
+
+	raw, err := json.Marshal(env)
+	if err != nil {
+		return errors.Wrap(err, "encoding store")
+	}
+
+	return errors.Wrap(e.file.write(raw), "writing store file")
+}
+
+func (e EncryptedJSONStore) decrypt(raw []byte) (c jsonContent, err error) {
+	if !isEncrypted(raw) {
+		return c, errors.Wrap(ErrNotEncrypted, "run migrate-credentials to encrypt it")
+	}
+
+	var env encryptedContent
+	if err = json.Unmarshal(raw, &env); err != nil {
+		return c, errors.Wrap(err, "decoding store")
+	}
+
+	if env.Version != encryptedFormatVersion || env.KDF != encryptedKDF {
+		return c, errors.Errorf("unsupported store format %d/%s", env.Version, env.KDF)
+	}
+
+	key, err := e.key.derive(e.passphrase, env.Salt)
+	if err != nil {
+		return c, errors.Wrap(err, "deriving key")
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return c, err
+	}
+
+	if len(env.Nonce) != aead.NonceSize() {
+		// Open panics on invalid nonces
+		return c, errors.New("invalid nonce in store")
+	}
+
+	plain, err := aead.Open(nil, env.Nonce, env.Data, nil)
+	if err != nil {
+		return c, errors.Wrap(err, "decrypting store (wrong passphrase?)")
+	}
+
+	return c, errors.Wrap(json.Unmarshal(plain, &c), "decoding store")
+}
+
+// current returns the cached key or derives one from a new salt
+func (d *derivedKey) current(passphrase []byte) (salt, key []byte, err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.key != nil {
+		return d.salt, d.key, nil
+	}
+
+	salt = make([]byte, scryptSalt)
+	if _, err = io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, nil, errors.Wrap(err, "generating salt")
+	}
+
+	return d.deriveLocked(passphrase, salt)
+}
+
+// derive returns the key for the given salt, deriving it only if the
+// salt changed since the last call
+func (d *derivedKey) derive(passphrase, salt []byte) ([]byte, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.key != nil && bytes.Equal(d.salt, salt) {
+		return d.key, nil
+	}
+
+	_, key, err := d.deriveLocked(passphrase, salt)
+	return key, err
+}
+
+func (d *derivedKey) deriveLocked(passphrase, salt []byte) ([]byte, []byte, error) {
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "running scrypt")
+	}
+
+	d.salt, d.key = salt, key
+	return salt, key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating cipher")
+	}
+
+	aead, err := cipher.NewGCM(block)
+	return aead, errors.Wrap(err, "creating GCM")
+}
+
+// isEncrypted tells whether the file content is an encrypted envelope
+// rather than the plaintext written by the JSONStore
+func isEncrypted(raw []byte) bool {
+	var env encryptedContent
+	return json.Unmarshal(raw, &env) == nil && env.Version > 0 && len(env.Data) > 0
+}
@@ -0,0 +1,170 @@
+package credential_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/credential"
+)
+
+const (
+	testClientID     = "test-client"
+	testClientSecret = "test-secret"
+	testPassphrase   = "correct horse battery staple"
+)
+
+func newEncryptedTestStore(t *testing.T, filename string) credential.EncryptedJSONStore {
+	t.Helper()
+
+	store, err := credential.NewEncryptedJSONStore(filename, testClientID, testClientSecret, testPassphrase)
+	if err != nil {
+		t.Fatalf("creating store: %s", err)
+	}
+
+	return store
+}
+
+// assertNoPlaintext ensures the token is not readable from the file
+func assertNoPlaintext(t *testing.T, filename, token string) {
+	t.Helper()
+
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("reading %s: %s", filepath.Base(filename), err)
+	}
+
+	if bytes.Contains(raw, []byte(token)) {
+		t.Errorf("%s contains the plaintext token", filepath.Base(filename))
+	}
+}
+
+func TestEncryptedStoreRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "creds.json")
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	store := newEncryptedTestStore(t, filename)
+	if has, err := store.HasCredentials(); err != nil || has {
+		t.Fatalf("expected empty store, got %v / %v", has, err)
+	}
+
+	if err := store.UpdateToken("access", "refresh", expiry); err != nil {
+		t.Fatalf("updating token: %s", err)
+	}
+
+	assertNoPlaintext(t, filename, "refresh")
+
+	// A fresh instance has to derive the key from the stored salt
+	at, rt, exp, err := newEncryptedTestStore(t, filename).GetToken()
+	if err != nil {
+		t.Fatalf("reading token: %s", err)
+	}
+
+	if at != "access" || rt != "refresh" || !exp.Equal(expiry) {
+		t.Errorf("unexpected token %q / %q / %s", at, rt, exp)
+	}
+}
+
+func TestEncryptedStoreRejectsWrongPassphrase(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "creds.json")
+
+	if err := newEncryptedTestStore(t, filename).UpdateToken("access", "refresh", time.Now()); err != nil {
+		t.Fatalf("updating token: %s", err)
+	}
+
+	if _, err := credential.NewEncryptedJSONStore(filename, testClientID, testClientSecret, "wrong"); err == nil {
+		t.Error("expected store with wrong passphrase to fail")
+	}
+
+	if _, err := credential.NewEncryptedJSONStore(filename, testClientID, testClientSecret, ""); err == nil {
+		t.Error("expected store with empty passphrase to fail")
+	}
+
+	// Store instance is kept even though probing failed (i.e. to report
+	// the error in health checks)
+	store, _ := credential.NewEncryptedJSONStore(filename, testClientID, testClientSecret, "wrong") //nolint:errcheck // Tested above
+	if at, rt, _, err := store.GetToken(); err == nil || at != "" || rt != "" {
+		t.Errorf("expected error without token, got %q / %q / %v", at, rt, err)
+	}
+
+	if _, err := store.HasCredentials(); err == nil {
+		t.Error("expected HasCredentials to fail with wrong passphrase")
+	}
+}
+
+func TestEncryptJSONFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "creds.json")
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	plain, err := credential.NewJSONStore(filename, testClientID, testClientSecret)
+	if err != nil {
+		t.Fatalf("creating plaintext store: %s", err)
+	}
+
+	// Second write leaves the first token in the backup
+	if err = plain.UpdateToken("old-access", "old-refresh", expiry); err != nil {
+		t.Fatalf("updating token: %s", err)
+	}
+	if err = plain.UpdateToken("access", "refresh", expiry); err != nil {
+		t.Fatalf("updating token: %s", err)
+	}
+
+	if _, err = credential.NewEncryptedJSONStore(filename, testClientID, testClientSecret, testPassphrase); !errors.Is(err, credential.ErrNotEncrypted) {
+		t.Fatalf("expected ErrNotEncrypted for plaintext file, got %v", err)
+	}
+
+	if err = credential.EncryptJSONFile(filename, testPassphrase); err != nil {
+		t.Fatalf("encrypting file: %s", err)
+	}
+
+	assertNoPlaintext(t, filename, "refresh")
+
+	if _, err = os.Stat(filename + ".bak"); !os.IsNotExist(err) {
+		t.Errorf("expected plaintext backup to be removed, got %v", err)
+	}
+
+	at, rt, exp, err := newEncryptedTestStore(t, filename).GetToken()
+	if err != nil {
+		t.Fatalf("reading migrated token: %s", err)
+	}
+
+	if at != "access" || rt != "refresh" || !exp.Equal(expiry) {
+		t.Errorf("unexpected token %q / %q / %s", at, rt, exp)
+	}
+
+	if err = credential.EncryptJSONFile(filename, testPassphrase); err == nil {
+		t.Error("expected encrypting twice to fail")
+	}
+}
+
+func TestEncryptedStoreFallsBackToBackup(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "creds.json")
+	store := newEncryptedTestStore(t, filename)
+
+	if err := store.UpdateToken("old-access", "old-refresh", time.Now()); err != nil {
+		t.Fatalf("updating token: %s", err)
+	}
+	if err := store.UpdateToken("access", "refresh", time.Now()); err != nil {
+		t.Fatalf("updating token: %s", err)
+	}
+
+	// The backup is encrypted as well
+	assertNoPlaintext(t, filename+".bak", "old-refresh")
+
+	if err := os.WriteFile(filename, []byte(`{"version":1,"kdf":"scrypt","data":"Y29ycnVwdA=="}`), 0o600); err != nil {
+		t.Fatalf("corrupting store: %s", err)
+	}
+
+	at, rt, _, err := newEncryptedTestStore(t, filename).GetToken()
+	if err != nil {
+		t.Fatalf("reading token: %s", err)
+	}
+
+	if at != "old-access" || rt != "old-refresh" {
+		t.Errorf("expected token from backup, got %q / %q", at, rt)
+	}
+}
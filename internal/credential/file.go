@@ -0,0 +1,155 @@
+package credential
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// storeFilePerms restricts credential files to the user running the
+// exporter
+const storeFilePerms fs.FileMode = 0o600
+
+type (
+	// storeFile is a credential file which might be shared between
+	// multiple instances: writes are serialized through a lock file and
+	// replace the file atomically keeping the previous content as
+	// backup. Reads are lock-free and served from memory as long as
+	// the file did not change.
+	storeFile struct {
+		filename string
+		cache    *fileCache
+	}
+
+	fileCache struct {
+		lock    sync.Mutex
+		data    []byte
+		modTime time.Time
+		size    int64
+	}
+)
+
+func newStoreFile(filename string) storeFile {
+	return storeFile{filename: filename, cache: &fileCache{}}
+}
+
+// load passes the content of the file to decode. If the file cannot be
+// decoded the backup is tried before giving up.
+func (s storeFile) load(decode func([]byte) error) error {
+	data, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	if err = decode(data); err == nil {
+		return nil
+	}
+
+	backup, berr := os.ReadFile(s.backupName())
+	if berr != nil || decode(backup) != nil {
+		return err
+	}
+
+	logrus.WithError(err).WithField("file", s.filename).Warn("credential file is corrupt, using backup")
+	return nil
+}
+
+// read returns the content of the file, reading it only if size or
+// modification time changed since the last read
+func (s storeFile) read() ([]byte, error) {
+	s.cache.lock.Lock()
+	defer s.cache.lock.Unlock()
+
+	info, err := os.Stat(s.filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening store")
+	}
+
+	if s.cache.data != nil && info.ModTime().Equal(s.cache.modTime) && info.Size() == s.cache.size {
+		return s.cache.data, nil
+	}
+
+	data, err := os.ReadFile(s.filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening store")
+	}
+
+	s.cache.data, s.cache.modTime, s.cache.size = data, info.ModTime(), info.Size()
+	return data, nil
+}
+
+// write replaces the file content while holding the lock, the current
+// content is kept as backup
+func (s storeFile) write(data []byte) error {
+	unlock, err := lockFile(s.filename + ".lock")
+	if err != nil {
+		return errors.Wrap(err, "locking store file")
+	}
+	defer unlock()
+
+	current, err := os.ReadFile(s.filename)
+	switch {
+	case err == nil && len(current) > 0:
+		if err = writeFileAtomic(s.backupName(), current); err != nil {
+			return errors.Wrap(err, "writing backup")
+		}
+
+	case err != nil && !errors.Is(err, fs.ErrNotExist):
+		return errors.Wrap(err, "reading current store file")
+	}
+
+	if err = writeFileAtomic(s.filename, data); err != nil {
+		return err
+	}
+
+	s.cache.lock.Lock()
+	defer s.cache.lock.Unlock()
+
+	s.cache.data = nil
+	if info, err := os.Stat(s.filename); err == nil {
+		s.cache.data, s.cache.modTime, s.cache.size = data, info.ModTime(), info.Size()
+	}
+
+	return nil
+}
+
+func (s storeFile) backupName() string { return s.filename + ".bak" }
+
+// writeFileAtomic writes the data into a temporary file next to the
+// target and renames it over the target afterwards so readers never
+// see a partially written file
+func writeFileAtomic(filename string, data []byte) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary file")
+	}
+	defer func() {
+		if err != nil {
+			f.Close()           //nolint:errcheck,gosec // Already failed, file is removed
+			os.Remove(f.Name()) //nolint:errcheck,gosec // Best effort cleanup
+		}
+	}()
+
+	if err = f.Chmod(storeFilePerms); err != nil {
+		return errors.Wrap(err, "setting permissions")
+	}
+
+	if _, err = f.Write(data); err != nil {
+		return errors.Wrap(err, "writing temporary file")
+	}
+
+	if err = f.Sync(); err != nil {
+		return errors.Wrap(err, "syncing temporary file")
+	}
+
+	if err = f.Close(); err != nil {
+		return errors.Wrap(err, "closing temporary file")
+	}
+
+	return errors.Wrap(os.Rename(f.Name(), filename), "replacing store file")
+}
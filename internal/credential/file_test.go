@@ -0,0 +1,163 @@
+package credential
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writeTestContent(t *testing.T, filename, refreshToken string, modTime time.Time) {
+	t.Helper()
+
+	data, err := json.Marshal(jsonContent{AccessToken: "access", RefreshToken: refreshToken})
+	if err != nil {
+		t.Fatalf("encoding content: %s", err)
+	}
+
+	if err = os.WriteFile(filename, data, storeFilePerms); err != nil {
+		t.Fatalf("writing file: %s", err)
+	}
+
+	if err = os.Chtimes(filename, modTime, modTime); err != nil {
+		t.Fatalf("setting modification time: %s", err)
+	}
+}
+
+func TestStoreFileCacheInvalidation(t *testing.T) {
+	var (
+		filename = filepath.Join(t.TempDir(), "creds.json")
+		modTime  = time.Now().Add(-time.Hour).Truncate(time.Second)
+	)
+
+	writeTestContent(t, filename, "first", modTime)
+
+	store, err := NewJSONStore(filename, "", "")
+	if err != nil {
+		t.Fatalf("creating store: %s", err)
+	}
+
+	assertRefreshToken := func(expect string) {
+		t.Helper()
+
+		_, rt, _, err := store.GetToken()
+		if err != nil {
+			t.Fatalf("reading token: %s", err)
+		}
+
+		if rt != expect {
+			t.Errorf("expected %q, got %q", expect, rt)
+		}
+	}
+
+	assertRefreshToken("first")
+
+	// Same size and modification time: served from the cache
+	writeTestContent(t, filename, "secnd", modTime)
+	assertRefreshToken("first")
+
+	// Changed modification time invalidates the cache
+	writeTestContent(t, filename, "secnd", modTime.Add(time.Second))
+	assertRefreshToken("secnd")
+
+	// Changed size invalidates the cache
+	writeTestContent(t, filename, "third-token", modTime.Add(time.Second))
+	assertRefreshToken("third-token")
+}
+
+func TestStoreFileFallsBackToBackup(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "creds.json")
+
+	store, err := NewJSONStore(filename, "", "")
+	if err != nil {
+		t.Fatalf("creating store: %s", err)
+	}
+
+	for _, rt := range []string{"old", "new"} {
+		if err = store.UpdateToken("access", rt, time.Now()); err != nil {
+			t.Fatalf("updating token: %s", err)
+		}
+	}
+
+	if err = os.WriteFile(filename, []byte("{corrupt"), storeFilePerms); err != nil {
+		t.Fatalf("corrupting file: %s", err)
+	}
+
+	_, rt, _, err := store.GetToken()
+	if err != nil {
+		t.Fatalf("reading token: %s", err)
+	}
+
+	if rt != "old" {
+		t.Errorf("expected token from backup, got %q", rt)
+	}
+
+	// Without usable backup the original error is reported
+	if err = os.WriteFile(filename+".bak", []byte("{corrupt"), storeFilePerms); err != nil {
+		t.Fatalf("corrupting backup: %s", err)
+	}
+
+	if _, _, _, err = store.GetToken(); err == nil {
+		t.Error("expected error with corrupt file and backup")
+	}
+}
+
+func TestStoreFileConcurrentUpdates(t *testing.T) {
+	var (
+		dir      = t.TempDir()
+		filename = filepath.Join(dir, "creds.json")
+		wg       sync.WaitGroup
+		tokens   = make(map[string]bool)
+	)
+
+	for i := 0; i < 20; i++ {
+		rt := fmt.Sprintf("refresh-%d", i)
+		tokens[rt] = true
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// Every writer has its own instance like separate processes
+			store, err := NewJSONStore(filename, "", "")
+			if err != nil {
+				t.Errorf("creating store: %s", err)
+				return
+			}
+
+			if err = store.UpdateToken("access", rt, time.Now()); err != nil {
+				t.Errorf("updating token: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, name := range []string{filename, filename + ".bak"} {
+		var c jsonContent
+
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("reading %s: %s", filepath.Base(name), err)
+		}
+
+		if err = json.Unmarshal(data, &c); err != nil || !tokens[c.RefreshToken] {
+			t.Errorf("%s has unexpected content %q (%v)", filepath.Base(name), data, err)
+		}
+	}
+
+	// Only the store, its backup and the lock file are left
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("listing directory: %s", err)
+	}
+	if len(entries) != 3 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("unexpected files left: %v", names)
+	}
+}
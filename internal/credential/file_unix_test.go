@@ -0,0 +1,79 @@
+//go:build !windows
+
+package credential
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreFilePermissions(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "creds.json")
+
+	// Existing file readable by others is replaced by a private one
+	if err := os.WriteFile(filename, []byte("{}"), 0o644); err != nil { //nolint:gosec // Testing the permissions are fixed
+		t.Fatalf("writing file: %s", err)
+	}
+
+	store, err := NewJSONStore(filename, "", "")
+	if err != nil {
+		t.Fatalf("creating store: %s", err)
+	}
+
+	if err = store.UpdateToken("access", "refresh", time.Now()); err != nil {
+		t.Fatalf("updating token: %s", err)
+	}
+
+	for _, name := range []string{filename, filename + ".bak"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("getting file info: %s", err)
+		}
+
+		if perm := info.Mode().Perm(); perm != storeFilePerms {
+			t.Errorf("expected %s to have permissions %s, got %s", filepath.Base(name), storeFilePerms, perm)
+		}
+	}
+}
+
+func TestStoreFileWritesWaitForLock(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "creds.json")
+
+	store, err := NewJSONStore(filename, "", "")
+	if err != nil {
+		t.Fatalf("creating store: %s", err)
+	}
+
+	// Simulate another instance writing the file
+	unlock, err := lockFile(filename + ".lock")
+	if err != nil {
+		t.Fatalf("acquiring lock: %s", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- store.UpdateToken("access", "refresh", time.Now()) }()
+
+	select {
+	case err = <-done:
+		t.Fatalf("update finished while the lock was held: %v", err)
+	case <-time.After(100 * time.Millisecond):
+		// Still waiting for the lock
+	}
+
+	unlock()
+
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatalf("updating token: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("update did not finish after the lock was released")
+	}
+
+	if _, rt, _, err := store.GetToken(); err != nil || rt != "refresh" {
+		t.Errorf("unexpected token %q (%v)", rt, err)
+	}
+}
@@ -1,6 +1,14 @@
 package credential
 
-import "time"
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// conflictRetries is the number of attempts to update the token when
+// the store detects concurrent modifications
+const conflictRetries = 3
 
 type (
 	Store interface {
@@ -10,3 +18,19 @@ type (
 		UpdateToken(accessToken, refreshToken string, expiry time.Time) error
 	}
 )
+
+var (
+	// ErrConflict is returned when the stored credentials were modified
+	// concurrently more often than the update was retried
+	ErrConflict = errors.New("credentials were modified concurrently")
+	// ErrMissingKey is returned when a value is not present in the store
+	ErrMissingKey = errors.New("missing key")
+)
+
+// storedTokenIsNewer tells whether the token another writer stored
+// concurrently expires after the given one and should be kept instead
+// of being replaced by an older token
+func storedTokenIsNewer(storedExpiry string, expiry time.Time) bool {
+	stored, err := time.Parse(time.RFC3339Nano, storedExpiry)
+	return err == nil && stored.After(expiry)
+}
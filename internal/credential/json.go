@@ -10,8 +10,12 @@ import (
 )
 
 type (
+	// JSONStore keeps the token in a plaintext file. See storeFile for
+	// the handling of concurrent access.
 	JSONStore struct {
-		clientID, clientSecret, filename string
+		clientID, clientSecret string
+
+		file storeFile
 	}
 
 	jsonContent struct {
@@ -26,7 +30,7 @@ func NewJSONStore(filename, clientID, clientSecret string) (JSONStore, error) {
 	store := JSONStore{
 		clientID:     clientID,
 		clientSecret: clientSecret,
-		filename:     filename,
+		file:         newStoreFile(filename),
 	}
 
 	_, err := os.Stat(filename)
@@ -46,14 +50,10 @@ func (j JSONStore) GetClientCredentials() (clientID, clientSecret string, err er
 func (j JSONStore) GetToken() (accessToken, refreshToken string, expiry time.Time, err error) {
 	var c jsonContent
 
-	f, err := os.Open(j.filename)
-	if err != nil {
-		return "", "", time.Time{}, errors.Wrap(err, "opening store")
-	}
-	defer f.Close()
-
-	if err = json.NewDecoder(f).Decode(&c); err != nil {
-		return "", "", time.Time{}, errors.Wrap(err, "decoding store")
+	if err = j.file.load(func(data []byte) error {
+		return errors.Wrap(json.Unmarshal(data, &c), "decoding store")
+	}); err != nil {
+		return "", "", time.Time{}, err
 	}
 
 	return c.AccessToken, c.RefreshToken, c.Expiry, nil
@@ -74,13 +74,10 @@ func (j JSONStore) HasCredentials() (bool, error) {
 }
 
 func (j JSONStore) UpdateToken(accessToken, refreshToken string, expiry time.Time) error {
-	c := jsonContent{accessToken, refreshToken, expiry}
-
-	f, err := os.Create(j.filename)
+	data, err := json.Marshal(jsonContent{accessToken, refreshToken, expiry})
 	if err != nil {
-		return errors.Wrap(err, "creating store file")
+		return errors.Wrap(err, "encoding store")
 	}
-	defer f.Close()
 
-	return json.NewEncoder(f).Encode(c)
+	return errors.Wrap(j.file.write(data), "writing store file")
 }
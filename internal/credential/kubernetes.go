@@ -0,0 +1,268 @@
+package credential
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	kubernetesRequestTimeout = 10 * time.Second
+
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+type (
+	// KubernetesConfig describes how to access the Kubernetes API
+	KubernetesConfig struct {
+		// APIServer is the base URL of the API (i.e. https://10.0.0.1:443)
+		APIServer string
+		// Namespace is used when the secret name does not contain one
+		Namespace string
+		// TokenFile is read on every request as service-account tokens
+		// are rotated
+		TokenFile string
+		// HTTPClient is used for all requests (defaults to a client
+		// without custom TLS configuration)
+		HTTPClient *http.Client
+	}
+
+	// KubernetesStore keeps the credentials in a Kubernetes Secret using
+	// the keys client-id, client-secret, access-token, refresh-token and
+	// expiry. It requires get and patch permissions on the Secret.
+	KubernetesStore struct {
+		cfg             KubernetesConfig
+		namespace, name string
+	}
+
+	kubernetesSecret struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Data map[string][]byte `json:"data"`
+	}
+)
+
+var _ Store = KubernetesStore{}
+
+// InClusterKubernetesConfig creates the config from the service-account
+// mounted into the pod
+func InClusterKubernetesConfig() (KubernetesConfig, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return KubernetesConfig{}, errors.New("not running in Kubernetes (KUBERNETES_SERVICE_HOST / _PORT not set)")
+	}
+
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return KubernetesConfig{}, errors.Wrap(err, "reading cluster CA")
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return KubernetesConfig{}, errors.New("no certificates found in cluster CA")
+	}
+
+	namespace, err := os.ReadFile(serviceAccountDir + "/namespace")
+	if err != nil {
+		return KubernetesConfig{}, errors.Wrap(err, "reading namespace")
+	}
+
+	return KubernetesConfig{
+		APIServer: "https://" + net.JoinHostPort(host, port),
+		Namespace: strings.TrimSpace(string(namespace)),
+		TokenFile: serviceAccountDir + "/token",
+		HTTPClient: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+			},
+		},
+	}, nil
+}
+
+// NewKubernetesStore creates a store for the Secret given as
+// [namespace/]name
+func NewKubernetesStore(cfg KubernetesConfig, secret string) (KubernetesStore, error) {
+	k := KubernetesStore{cfg: cfg, namespace: cfg.Namespace, name: secret}
+	if ns, name, ok := strings.Cut(secret, "/"); ok {
+		k.namespace, k.name = ns, name
+	}
+
+	if k.namespace == "" || k.name == "" {
+		return k, errors.Errorf("invalid secret %q, expected [namespace/]name", secret)
+	}
+
+	if k.cfg.HTTPClient == nil {
+		k.cfg.HTTPClient = http.DefaultClient
+	}
+
+	_, err := k.getSecret()
+	return k, errors.Wrap(err, "reading secret")
+}
+
+func (k KubernetesStore) GetClientCredentials() (clientID, clientSecret string, err error) {
+	secret, err := k.getSecret()
+	if err != nil {
+		return "", "", errors.Wrap(err, "reading secret")
+	}
+
+	if clientID, err = secret.get("client-id"); err != nil {
+		return "", "", err
+	}
+	if clientSecret, err = secret.get("client-secret"); err != nil {
+		return "", "", err
+	}
+
+	return clientID, clientSecret, nil
+}
+
+func (k KubernetesStore) GetToken() (accessToken, refreshToken string, expiry time.Time, err error) {
+	secret, err := k.getSecret()
+	if err != nil {
+		return "", "", time.Time{}, errors.Wrap(err, "reading secret")
+	}
+
+	if accessToken, err = secret.get("access-token"); err != nil {
+		return "", "", time.Time{}, err
+	}
+	if refreshToken, err = secret.get("refresh-token"); err != nil {
+		return "", "", time.Time{}, err
+	}
+	exp, err := secret.get("expiry")
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	if expiry, err = time.Parse(time.RFC3339Nano, exp); err != nil {
+		return "", "", time.Time{}, errors.Wrap(err, "parsing stored expiry")
+	}
+
+	return accessToken, refreshToken, expiry, nil
+}
+
+func (k KubernetesStore) HasCredentials() (bool, error) {
+	_, r, _, err := k.GetToken()
+	switch {
+	case err == nil:
+		return r != "", nil
+
+	case errors.Is(err, ErrMissingKey):
+		return false, nil
+
+	default:
+		return false, errors.Wrap(err, "getting credentials")
+	}
+}
+
+// UpdateToken patches the token into the Secret. The patch is bound to
+// the resourceVersion read before so concurrent modifications are
+// detected and the update is retried on a fresh copy unless the
+// concurrent writer stored a newer token.
+func (k KubernetesStore) UpdateToken(accessToken, refreshToken string, expiry time.Time) error {
+	for attempt := 0; attempt < conflictRetries; attempt++ {
+		secret, err := k.getSecret()
+		if err != nil {
+			return errors.Wrap(err, "reading secret")
+		}
+
+		if attempt > 0 && storedTokenIsNewer(string(secret.Data["expiry"]), expiry) {
+			// Another replica refreshed the token in the meantime
+			return nil
+		}
+
+		patch := map[string]any{
+			"metadata": map[string]any{"resourceVersion": secret.Metadata.ResourceVersion},
+			"data": map[string][]byte{
+				"access-token":  []byte(accessToken),
+				"refresh-token": []byte(refreshToken),
+				"expiry":        []byte(expiry.Format(time.RFC3339Nano)),
+			},
+		}
+
+		err = k.do(http.MethodPatch, patch, nil)
+		if !errors.Is(err, ErrConflict) {
+			return errors.Wrap(err, "patching secret")
+		}
+	}
+
+	return errors.Wrapf(ErrConflict, "updating secret after %d attempts", conflictRetries)
+}
+
+func (k KubernetesStore) getSecret() (kubernetesSecret, error) {
+	var secret kubernetesSecret
+	return secret, k.do(http.MethodGet, nil, &secret)
+}
+
+func (k KubernetesStore) do(method string, body, out any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), kubernetesRequestTimeout)
+	defer cancel()
+
+	var reqBody io.Reader
+	if body != nil {
+		buf := new(bytes.Buffer)
+		if err := json.NewEncoder(buf).Encode(body); err != nil {
+			return errors.Wrap(err, "encoding body")
+		}
+		reqBody = buf
+	}
+
+	u := fmt.Sprintf("%s/api/v1/namespaces/%s/secrets/%s",
+		strings.TrimRight(k.cfg.APIServer, "/"), url.PathEscape(k.namespace), url.PathEscape(k.name))
+
+	req, err := http.NewRequestWithContext(ctx, method, u, reqBody)
+	if err != nil {
+		return errors.Wrap(err, "creating request")
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+	}
+
+	if k.cfg.TokenFile != "" {
+		token, err := os.ReadFile(k.cfg.TokenFile)
+		if err != nil {
+			return errors.Wrap(err, "reading service-account token")
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := k.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "executing request")
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if out == nil {
+			return nil
+		}
+		return errors.Wrap(json.NewDecoder(resp.Body).Decode(out), "decoding response")
+
+	case http.StatusConflict:
+		return ErrConflict
+
+	default:
+		respBody, _ := io.ReadAll(resp.Body) //nolint:errcheck // Only used for the error message
+		return errors.Errorf("http status code %d, body %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+}
+
+func (s kubernetesSecret) get(key string) (string, error) {
+	v, ok := s.Data[key]
+	if !ok {
+		return "", errors.Wrapf(ErrMissingKey, "getting %s", key)
+	}
+	return string(v), nil
+}
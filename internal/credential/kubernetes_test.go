@@ -0,0 +1,247 @@
+package credential_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/credential"
+)
+
+const (
+	testNamespace    = "byocar"
+	testSecretName   = "mercedes-credentials"
+	testSecretPath   = "/api/v1/namespaces/" + testNamespace + "/secrets/" + testSecretName
+	testAccountToken = "service-account-token"
+)
+
+// fakeKubernetes serves a single Secret and rejects patches with an
+// outdated resourceVersion like the Kubernetes API does
+type fakeKubernetes struct {
+	lock            sync.Mutex
+	data            map[string][]byte
+	resourceVersion int
+
+	// conflicts is the number of upcoming patches to be answered with a
+	// conflict caused by a concurrent modification
+	conflicts int
+	// concurrentData is stored by the simulated concurrent modification
+	concurrentData map[string][]byte
+	// patchVersions records the resourceVersion of every patch
+	patchVersions []string
+}
+
+func (f *fakeKubernetes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != testSecretPath {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer "+testAccountToken {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		f.writeSecret(w)
+
+	case http.MethodPatch:
+		var patch struct {
+			Metadata struct {
+				ResourceVersion string `json:"resourceVersion"`
+			} `json:"metadata"`
+			Data map[string][]byte `json:"data"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.patchVersions = append(f.patchVersions, patch.Metadata.ResourceVersion)
+
+		if f.conflicts > 0 {
+			// Someone else modified the secret in the meantime
+			f.conflicts--
+			f.resourceVersion++
+			for k, v := range f.concurrentData {
+				f.data[k] = v
+			}
+		}
+
+		if patch.Metadata.ResourceVersion != strconv.Itoa(f.resourceVersion) {
+			http.Error(w, `{"reason":"Conflict"}`, http.StatusConflict)
+			return
+		}
+
+		for k, v := range patch.Data {
+			f.data[k] = v
+		}
+		f.resourceVersion++
+		f.writeSecret(w)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeKubernetes) writeSecret(w http.ResponseWriter) {
+	secret := map[string]any{
+		"metadata": map[string]any{"resourceVersion": strconv.Itoa(f.resourceVersion)},
+		"data":     f.data,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(secret) //nolint:errchkjson,errcheck // Test server
+}
+
+func newTestKubernetesStore(t *testing.T, api *fakeKubernetes) credential.KubernetesStore {
+	t.Helper()
+
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte(testAccountToken+"\n"), 0o600); err != nil {
+		t.Fatalf("writing token file: %s", err)
+	}
+
+	store, err := credential.NewKubernetesStore(credential.KubernetesConfig{
+		APIServer:  srv.URL,
+		Namespace:  "default",
+		TokenFile:  tokenFile,
+		HTTPClient: srv.Client(),
+	}, testNamespace+"/"+testSecretName)
+	if err != nil {
+		t.Fatalf("creating store: %s", err)
+	}
+
+	return store
+}
+
+func TestKubernetesStoreRetriesConflict(t *testing.T) {
+	api := &fakeKubernetes{
+		data: map[string][]byte{
+			"client-id":     []byte("id"),
+			"client-secret": []byte("secret"),
+		},
+		resourceVersion: 10,
+		conflicts:       1,
+	}
+	store := newTestKubernetesStore(t, api)
+
+	expiry := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := store.UpdateToken("access", "refresh", expiry); err != nil {
+		t.Fatalf("updating token: %s", err)
+	}
+
+	if len(api.patchVersions) != 2 {
+		t.Fatalf("expected 2 patches, got %d", len(api.patchVersions))
+	}
+
+	if api.patchVersions[0] != "10" || api.patchVersions[1] != "11" {
+		t.Errorf("expected retry with fresh resourceVersion, got patches for %v", api.patchVersions)
+	}
+
+	at, rt, exp, err := store.GetToken()
+	if err != nil {
+		t.Fatalf("reading token: %s", err)
+	}
+
+	if at != "access" || rt != "refresh" || !exp.Equal(expiry) {
+		t.Errorf("unexpected token %q / %q / %s", at, rt, exp)
+	}
+
+	id, secret, err := store.GetClientCredentials()
+	if err != nil || id != "id" || secret != "secret" {
+		t.Errorf("client credentials were modified: %q / %q / %v", id, secret, err)
+	}
+}
+
+func TestKubernetesStoreKeepsNewerConcurrentToken(t *testing.T) {
+	var (
+		expiry      = time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+		otherExpiry = expiry.Add(time.Minute)
+	)
+
+	for _, tc := range []struct {
+		name          string
+		otherExpiry   time.Time
+		expectRT      string
+		expectPatches int
+	}{
+		{"newer token is kept", otherExpiry, "other-refresh", 1},
+		{"older token is replaced", expiry.Add(-time.Minute), "refresh", 2},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			api := &fakeKubernetes{
+				data:      map[string][]byte{},
+				conflicts: 1,
+				concurrentData: map[string][]byte{
+					"access-token":  []byte("other-access"),
+					"refresh-token": []byte("other-refresh"),
+					"expiry":        []byte(tc.otherExpiry.Format(time.RFC3339Nano)),
+				},
+			}
+			store := newTestKubernetesStore(t, api)
+
+			if err := store.UpdateToken("access", "refresh", expiry); err != nil {
+				t.Fatalf("updating token: %s", err)
+			}
+
+			if len(api.patchVersions) != tc.expectPatches {
+				t.Errorf("expected %d patches, got %d", tc.expectPatches, len(api.patchVersions))
+			}
+
+			_, rt, _, err := store.GetToken()
+			if err != nil {
+				t.Fatalf("reading token: %s", err)
+			}
+
+			if rt != tc.expectRT {
+				t.Errorf("expected refresh token %q, got %q", tc.expectRT, rt)
+			}
+		})
+	}
+}
+
+func TestKubernetesStoreGivesUpOnPersistentConflict(t *testing.T) {
+	api := &fakeKubernetes{
+		data:      map[string][]byte{},
+		conflicts: 100,
+	}
+	store := newTestKubernetesStore(t, api)
+
+	err := store.UpdateToken("access", "refresh", time.Now())
+	if !errors.Is(err, credential.ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+
+	if len(api.patchVersions) != 3 {
+		t.Errorf("expected 3 attempts, got %d", len(api.patchVersions))
+	}
+}
+
+func TestKubernetesStoreWithoutToken(t *testing.T) {
+	store := newTestKubernetesStore(t, &fakeKubernetes{data: map[string][]byte{}})
+
+	ok, err := store.HasCredentials()
+	if err != nil {
+		t.Fatalf("checking credentials: %s", err)
+	}
+
+	if ok {
+		t.Error("empty secret reported to contain credentials")
+	}
+}
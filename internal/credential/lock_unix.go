@@ -0,0 +1,29 @@
+//go:build !windows
+
+package credential
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// lockFile acquires an exclusive lock on the given file, creating it if
+// required, and returns a function to release it
+func lockFile(filename string) (func(), error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, storeFilePerms) //nolint:gosec // Path is derived from user given credential-file
+	if err != nil {
+		return nil, errors.Wrap(err, "opening lock file")
+	}
+
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close() //nolint:errcheck,gosec // Already failed
+		return nil, errors.Wrap(err, "acquiring lock")
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN) //nolint:errcheck,gosec // Released on close anyway
+		f.Close()                                   //nolint:errcheck,gosec // Nothing to do on error
+	}, nil
+}
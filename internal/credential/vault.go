@@ -1,25 +1,33 @@
 package credential
 
 import (
-	"os"
+	"context"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/vault/api"
-	"github.com/mitchellh/go-homedir"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
 type (
+	// VaultStore keeps the credentials in a KV v1 or v2 secrets engine.
+	// The version is detected from the mount of the key unless set
+	// through VAULT_KV_VERSION.
 	VaultStore struct {
+		auth   *vaultAuth
 		client *api.Client
 		key    string
+
+		kvMount   string
+		kvVersion int
 	}
 )
 
 var _ Store = VaultStore{}
 
-var ErrMissingKey = errors.New("missing key")
+var errNoData = errors.New("no data found at key")
 
 func NewVaultStore(vaultKey string) (VaultStore, error) {
 	var (
@@ -27,7 +35,7 @@ func NewVaultStore(vaultKey string) (VaultStore, error) {
 		v   VaultStore
 	)
 
-	v.key = vaultKey
+	v.key = strings.Trim(vaultKey, "/")
 
 	c := api.DefaultConfig()
 	if err = c.ReadEnvironment(); err != nil {
@@ -39,28 +47,34 @@ func NewVaultStore(vaultKey string) (VaultStore, error) {
 		return v, errors.Wrap(err, "creating Vault client")
 	}
 
-	return v, errors.Wrap(v.authorizeVault(), "authorizing Vault")
-}
+	v.auth = &vaultAuth{client: v.client}
+	if err = v.auth.ensureToken(context.Background()); err != nil {
+		return v, errors.Wrap(err, "authorizing Vault")
+	}
 
-func (v VaultStore) GetClientCredentials() (clientID, clientSecret string, err error) {
-	if err = v.authorizeVault(); err != nil {
-		return "", "", errors.Wrap(err, "authorizing Vault")
+	if err = v.detectKV(context.Background()); err != nil {
+		return v, errors.Wrap(err, "detecting KV version")
 	}
 
-	secret, err := v.client.Logical().Read(v.key)
+	logrus.WithFields(logrus.Fields{
+		"mount":   v.kvMount,
+		"version": v.kvVersion,
+	}).Debug("using Vault KV secrets engine")
+
+	return v, nil
+}
+
+func (v VaultStore) GetClientCredentials() (clientID, clientSecret string, err error) {
+	data, _, err := v.read()
 	if err != nil {
-		return "", "", errors.Wrap(err, "reading Vault key")
-	}
-	if secret == nil || secret.Data == nil {
-		logrus.Warnf("%s %v", v.key, secret)
-		return "", "", errors.New("no data found at key")
+		return "", "", err
 	}
 
 	var ok bool
-	if clientID, ok = secret.Data["client-id"].(string); !ok {
+	if clientID, ok = data["client-id"].(string); !ok {
 		return "", "", errors.Wrap(ErrMissingKey, "getting client-id")
 	}
-	if clientSecret, ok = secret.Data["client-secret"].(string); !ok {
+	if clientSecret, ok = data["client-secret"].(string); !ok {
 		return "", "", errors.Wrap(ErrMissingKey, "getting client-secret")
 	}
 
@@ -68,26 +82,19 @@ func (v VaultStore) GetClientCredentials() (clientID, clientSecret string, err e
 }
 
 func (v VaultStore) GetToken() (accessToken, refreshToken string, expiry time.Time, err error) {
-	if err = v.authorizeVault(); err != nil {
-		return "", "", time.Time{}, errors.Wrap(err, "authorizing Vault")
-	}
-
-	secret, err := v.client.Logical().Read(v.key)
+	data, _, err := v.read()
 	if err != nil {
-		return "", "", time.Time{}, errors.Wrap(err, "reading Vault key")
-	}
-	if secret == nil || secret.Data == nil {
-		return "", "", time.Time{}, errors.New("no data found at key")
+		return "", "", time.Time{}, err
 	}
 
 	var ok bool
-	if accessToken, ok = secret.Data["access-token"].(string); !ok {
+	if accessToken, ok = data["access-token"].(string); !ok {
 		return "", "", time.Time{}, errors.Wrap(ErrMissingKey, "getting access-token")
 	}
-	if refreshToken, ok = secret.Data["refresh-token"].(string); !ok {
+	if refreshToken, ok = data["refresh-token"].(string); !ok {
 		return "", "", time.Time{}, errors.Wrap(ErrMissingKey, "getting refresh-token")
 	}
-	exp, ok := secret.Data["expiry"].(string)
+	exp, ok := data["expiry"].(string)
 	if !ok {
 		return "", "", time.Time{}, errors.Wrap(ErrMissingKey, "getting expiry")
 	}
@@ -112,56 +119,133 @@ func (v VaultStore) HasCredentials() (bool, error) {
 	}
 }
 
-func (v VaultStore) UpdateToken(accessToken, refreshToken string, expiry time.Time) (err error) {
-	if err = v.authorizeVault(); err != nil {
-		return errors.Wrap(err, "authorizing Vault")
-	}
+// UpdateToken writes the token into the key keeping all other values.
+// On KV v2 the write is bound to the version read before and retried
+// when another writer updated the key in between, unless that writer
+// stored a newer token.
+func (v VaultStore) UpdateToken(accessToken, refreshToken string, expiry time.Time) error {
+	for attempt := 0; attempt < conflictRetries; attempt++ {
+		data, version, err := v.read()
+		if err != nil {
+			return err
+		}
 
-	secret, err := v.client.Logical().Read(v.key)
-	if err != nil {
-		return errors.Wrap(err, "reading Vault key")
-	}
-	if secret == nil || secret.Data == nil {
-		return errors.New("no data found at key")
-	}
+		if stored, _ := data["expiry"].(string); attempt > 0 && storedTokenIsNewer(stored, expiry) {
+			// Another instance refreshed the token in the meantime
+			return nil
+		}
 
-	data := secret.Data
-	data["access-token"] = accessToken
-	data["refresh-token"] = refreshToken
-	data["expiry"] = expiry.Format(time.RFC3339Nano)
+		data["access-token"] = accessToken
+		data["refresh-token"] = refreshToken
+		data["expiry"] = expiry.Format(time.RFC3339Nano)
 
-	_, err = v.client.Logical().Write(v.key, data)
-	return errors.Wrap(err, "writing back data")
+		err = v.write(data, version)
+		if !errors.Is(err, ErrConflict) {
+			return errors.Wrap(err, "writing back data")
+		}
+	}
+
+	return errors.Wrapf(ErrConflict, "writing back data after %d attempts", conflictRetries)
 }
 
-func (v VaultStore) authorizeVault() error {
-	if role := os.Getenv("VAULT_ROLE_ID"); role != "" {
-		data := map[string]interface{}{
-			"role_id": role,
-		}
-		if secret := os.Getenv("VAULT_SECRET_ID"); secret != "" {
-			data["secret_id"] = secret
+// detectKV asks Vault for the mount of the key the same way the Vault
+// CLI does. Tokens not allowed to do so (or old Vault versions) fall
+// back to KV v1.
+func (v *VaultStore) detectKV(ctx context.Context) error {
+	switch ver := envOrDefault("VAULT_KV_VERSION", "auto"); ver {
+	case "1":
+		v.kvVersion = 1
+		return nil
+
+	case "2", "auto":
+		secret, err := v.client.Logical().ReadWithContext(ctx, "sys/internal/ui/mounts/"+v.key)
+		if err != nil || secret == nil || secret.Data == nil {
+			if ver == "2" {
+				// Assume the first path element is the mount
+				v.kvMount, v.kvVersion = strings.SplitN(v.key, "/", 2)[0], 2 //nolint:gomnd // Mount and path
+				return nil
+			}
+
+			logrus.WithError(err).Debug("looking up Vault mount, assuming KV v1")
+			v.kvVersion = 1
+			return nil
 		}
-		loginSecret, lserr := v.client.Logical().Write("auth/approle/login", data)
-		if lserr != nil || loginSecret.Auth == nil {
-			return errors.Wrap(lserr, "fetching token for approle")
+
+		v.kvMount, _ = secret.Data["path"].(string)
+		v.kvMount = strings.Trim(v.kvMount, "/")
+
+		v.kvVersion = 1
+		if opts, ok := secret.Data["options"].(map[string]interface{}); ok && opts["version"] == "2" {
+			v.kvVersion = 2
 		}
 
-		v.client.SetToken(loginSecret.Auth.ClientToken)
+		if ver == "2" && v.kvVersion != 2 {
+			return errors.Errorf("mount %q is not a KV v2 secrets engine", v.kvMount)
+		}
 		return nil
+
+	default:
+		return errors.Errorf("invalid VAULT_KV_VERSION %q, expected 1 or 2", ver)
 	}
+}
 
-	if token := os.Getenv(api.EnvVaultToken); token != "" {
-		v.client.SetToken(token)
-		return nil
+// read returns the data at the key and its version (always 0 for KV v1)
+func (v VaultStore) read() (map[string]interface{}, int, error) {
+	ctx := context.Background()
+
+	if err := v.auth.ensureToken(ctx); err != nil {
+		return nil, 0, errors.Wrap(err, "authorizing Vault")
 	}
 
-	if tokenFile, err := homedir.Expand("~/.vault-token"); err == nil {
-		if token, err := os.ReadFile(tokenFile); err == nil {
-			v.client.SetToken(string(token))
-			return nil
+	if v.kvVersion == 1 {
+		secret, err := v.client.Logical().ReadWithContext(ctx, v.key)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "reading Vault key")
+		}
+		if secret == nil || secret.Data == nil {
+			return nil, 0, errNoData
 		}
+		return secret.Data, 0, nil
+	}
+
+	secret, err := v.client.KVv2(v.kvMount).Get(ctx, v.kvPath())
+	switch {
+	case errors.Is(err, api.ErrSecretNotFound):
+		return nil, 0, errNoData
+	case err != nil:
+		return nil, 0, errors.Wrap(err, "reading Vault key")
+	case secret.Data == nil:
+		// Latest version was deleted
+		return nil, 0, errNoData
 	}
 
-	return errors.New("no valid auth method found")
+	return secret.Data, secret.VersionMetadata.Version, nil
+}
+
+// write stores the data at the key, on KV v2 only if the current
+// version still matches the given one
+func (v VaultStore) write(data map[string]interface{}, version int) error {
+	ctx := context.Background()
+
+	if err := v.auth.ensureToken(ctx); err != nil {
+		return errors.Wrap(err, "authorizing Vault")
+	}
+
+	if v.kvVersion == 1 {
+		_, err := v.client.Logical().WriteWithContext(ctx, v.key, data)
+		return errors.Wrap(err, "writing Vault key")
+	}
+
+	_, err := v.client.KVv2(v.kvMount).Put(ctx, v.kvPath(), data, api.WithCheckAndSet(version))
+
+	var respErr *api.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusBadRequest && strings.Contains(strings.Join(respErr.Errors, " "), "check-and-set") {
+		return ErrConflict
+	}
+
+	return errors.Wrap(err, "writing Vault key")
+}
+
+func (v VaultStore) kvPath() string {
+	return strings.TrimPrefix(strings.TrimPrefix(v.key, v.kvMount), "/")
 }
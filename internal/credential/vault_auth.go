@@ -0,0 +1,168 @@
+package credential
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// vaultAuth keeps the Vault token of the client valid: the token is
+	// acquired once, renewed while it is renewable and only acquired
+	// again when renewal is not possible
+	vaultAuth struct {
+		client *api.Client
+
+		lock      sync.Mutex
+		hasToken  bool
+		expiry    time.Time // zero for tokens without TTL
+		renewAt   time.Time
+		renewable bool
+	}
+)
+
+// ensureToken makes sure the client has a token which is valid for a
+// while, renewing or acquiring it if required
+func (a *vaultAuth) ensureToken(ctx context.Context) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	now := time.Now()
+
+	switch {
+	case !a.hasToken:
+		// Nothing to renew
+
+	case a.expiry.IsZero() || now.Before(a.renewAt):
+		return nil
+
+	case a.renewable && now.Before(a.expiry):
+		secret, err := a.client.Auth().Token().RenewSelfWithContext(ctx, 0)
+		if err == nil {
+			if err = a.setLease(secret); err == nil {
+				return nil
+			}
+		}
+		logrus.WithError(err).Warn("renewing Vault token failed, logging in again")
+	}
+
+	return errors.Wrap(a.login(ctx), "logging in")
+}
+
+// login acquires a token through the first auth method configured
+// through the environment
+func (a *vaultAuth) login(ctx context.Context) error {
+	a.hasToken = false
+
+	if role := os.Getenv("VAULT_ROLE_ID"); role != "" {
+		data := map[string]interface{}{
+			"role_id": role,
+		}
+		if secret := os.Getenv("VAULT_SECRET_ID"); secret != "" {
+			data["secret_id"] = secret
+		}
+		return errors.Wrap(a.loginWith(ctx, "approle", data), "fetching token for approle")
+	}
+
+	if role := os.Getenv("VAULT_K8S_ROLE"); role != "" {
+		jwt, err := os.ReadFile(envOrDefault("VAULT_K8S_TOKEN_FILE", serviceAccountDir+"/token"))
+		if err != nil {
+			return errors.Wrap(err, "reading service-account token")
+		}
+		data := map[string]interface{}{
+			"role": role,
+			"jwt":  strings.TrimSpace(string(jwt)),
+		}
+		return errors.Wrap(a.loginWith(ctx, envOrDefault("VAULT_K8S_MOUNT", "kubernetes"), data), "fetching token for kubernetes")
+	}
+
+	if role := os.Getenv("VAULT_JWT_ROLE"); role != "" {
+		jwt, err := os.ReadFile(os.Getenv("VAULT_JWT_FILE"))
+		if err != nil {
+			return errors.Wrap(err, "reading JWT from VAULT_JWT_FILE")
+		}
+		data := map[string]interface{}{
+			"role": role,
+			"jwt":  strings.TrimSpace(string(jwt)),
+		}
+		return errors.Wrap(a.loginWith(ctx, envOrDefault("VAULT_JWT_MOUNT", "jwt"), data), "fetching token for jwt")
+	}
+
+	if token := os.Getenv(api.EnvVaultToken); token != "" {
+		return a.useToken(ctx, token)
+	}
+
+	if tokenFile, err := homedir.Expand("~/.vault-token"); err == nil {
+		if token, err := os.ReadFile(tokenFile); err == nil {
+			return a.useToken(ctx, strings.TrimSpace(string(token)))
+		}
+	}
+
+	return errors.New("no valid auth method found")
+}
+
+func (a *vaultAuth) loginWith(ctx context.Context, mount string, data map[string]interface{}) error {
+	secret, err := a.client.Logical().WriteWithContext(ctx, "auth/"+mount+"/login", data)
+	if err != nil {
+		return errors.Wrap(err, "writing login")
+	}
+	if secret == nil || secret.Auth == nil {
+		return errors.New("login returned no token")
+	}
+
+	a.client.SetToken(secret.Auth.ClientToken)
+	return a.setLease(secret)
+}
+
+// useToken sets a static token and looks up its TTL. Tokens which
+// cannot be looked up are used without renewal.
+func (a *vaultAuth) useToken(ctx context.Context, token string) error {
+	a.client.SetToken(token)
+
+	secret, err := a.client.Auth().Token().LookupSelfWithContext(ctx)
+	if err != nil {
+		logrus.WithError(err).Debug("looking up Vault token, not renewing it")
+		a.hasToken, a.expiry = true, time.Time{}
+		return nil
+	}
+
+	return a.setLease(secret)
+}
+
+// setLease stores TTL and renewability of the token in the secret and
+// schedules the renewal after two thirds of the TTL
+func (a *vaultAuth) setLease(secret *api.Secret) error {
+	ttl, err := secret.TokenTTL()
+	if err != nil {
+		return errors.Wrap(err, "getting token TTL")
+	}
+
+	renewable, err := secret.TokenIsRenewable()
+	if err != nil {
+		return errors.Wrap(err, "getting token renewability")
+	}
+
+	now := time.Now()
+	a.hasToken, a.renewable = true, renewable
+	a.expiry, a.renewAt = time.Time{}, time.Time{}
+	if ttl > 0 {
+		a.expiry = now.Add(ttl)
+		a.renewAt = now.Add(ttl * 2 / 3) //nolint:gomnd // Renew after two thirds of the TTL
+	}
+
+	return nil
+}
+
+func envOrDefault(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,477 @@
+package credential
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testVaultKey   = "secret/byocar"
+	testVaultToken = "vault-token"
+)
+
+// fakeVault serves a single key in a KV v1 or v2 mount and the auth
+// endpoints used by the VaultStore
+type fakeVault struct {
+	lock sync.Mutex
+
+	// kvVersion of the mount serving the key
+	kvVersion int
+	// mountsForbidden denies the lookup of the mount
+	mountsForbidden bool
+	data            map[string]interface{}
+	version         int
+
+	// conflicts is the number of upcoming writes to be answered with a
+	// check-and-set error caused by a concurrent modification
+	conflicts int
+	// concurrentData is stored by the simulated concurrent modification
+	concurrentData map[string]interface{}
+	// casVersions records the cas option of every KV v2 write
+	casVersions []int
+
+	// ttl and renewable describe the issued tokens
+	ttl       int
+	renewable bool
+	// logins records the mount and data of every login
+	logins   []fakeVaultLogin
+	renewals int
+}
+
+type fakeVaultLogin struct {
+	mount string
+	data  map[string]string
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+
+	if strings.HasPrefix(path, "auth/") && strings.HasSuffix(path, "/login") {
+		var data map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			f.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		f.logins = append(f.logins, fakeVaultLogin{strings.TrimSuffix(strings.TrimPrefix(path, "auth/"), "/login"), data})
+		f.writeAuth(w)
+		return
+	}
+
+	if r.Header.Get("X-Vault-Token") != testVaultToken {
+		f.writeError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	switch {
+	case path == "auth/token/lookup-self":
+		f.writeJSON(w, map[string]interface{}{
+			"data": map[string]interface{}{"ttl": f.ttl, "renewable": f.renewable},
+		})
+
+	case path == "auth/token/renew-self":
+		f.renewals++
+		f.writeAuth(w)
+
+	case path == "sys/internal/ui/mounts/"+testVaultKey:
+		if f.mountsForbidden {
+			f.writeError(w, http.StatusForbidden, "permission denied")
+			return
+		}
+
+		mount := map[string]interface{}{"path": "secret/", "type": "kv"}
+		if f.kvVersion == 2 {
+			mount["options"] = map[string]interface{}{"version": "2"}
+		}
+		f.writeJSON(w, map[string]interface{}{"data": mount})
+
+	case f.kvVersion == 1 && path == testVaultKey:
+		f.serveKVv1(w, r)
+
+	case f.kvVersion == 2 && path == "secret/data/byocar":
+		f.serveKVv2(w, r)
+
+	default:
+		f.writeError(w, http.StatusNotFound, "no handler for route "+path)
+	}
+}
+
+func (f *fakeVault) serveKVv1(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		f.writeJSON(w, map[string]interface{}{"data": f.data})
+
+	case http.MethodPut, http.MethodPost:
+		var data map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			f.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		f.data = data
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		f.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (f *fakeVault) serveKVv2(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		f.writeJSON(w, map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     f.data,
+				"metadata": f.metadata(),
+			},
+		})
+
+	case http.MethodPut, http.MethodPost:
+		var body struct {
+			Data    map[string]interface{} `json:"data"`
+			Options struct {
+				CAS *int `json:"cas"`
+			} `json:"options"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			f.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		cas := -1
+		if body.Options.CAS != nil {
+			cas = *body.Options.CAS
+		}
+		f.casVersions = append(f.casVersions, cas)
+
+		if f.conflicts > 0 {
+			// Someone else modified the key in the meantime
+			f.conflicts--
+			f.version++
+			for k, v := range f.concurrentData {
+				f.data[k] = v
+			}
+		}
+
+		if cas >= 0 && cas != f.version {
+			f.writeError(w, http.StatusBadRequest, "check-and-set parameter did not match the current version")
+			return
+		}
+
+		f.data = body.Data
+		f.version++
+		f.writeJSON(w, map[string]interface{}{"data": f.metadata()})
+
+	default:
+		f.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (f *fakeVault) metadata() map[string]interface{} {
+	return map[string]interface{}{
+		"version":       f.version,
+		"created_time":  "2023-05-01T12:00:00Z",
+		"deletion_time": "",
+		"destroyed":     false,
+	}
+}
+
+func (f *fakeVault) writeAuth(w http.ResponseWriter) {
+	f.writeJSON(w, map[string]interface{}{
+		"auth": map[string]interface{}{
+			"client_token":   testVaultToken,
+			"lease_duration": f.ttl,
+			"renewable":      f.renewable,
+		},
+	})
+}
+
+func (*fakeVault) writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{msg}}) //nolint:errchkjson,errcheck // Test server
+}
+
+func (*fakeVault) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v) //nolint:errchkjson,errcheck // Test server
+}
+
+// newTestVaultStore starts the fake and configures the environment to
+// use it, env is applied on top of a clean Vault environment
+func newTestVaultStore(t *testing.T, api *fakeVault, env map[string]string) VaultStore {
+	t.Helper()
+
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+
+	for _, name := range []string{
+		"VAULT_TOKEN", "VAULT_KV_VERSION",
+		"VAULT_ROLE_ID", "VAULT_SECRET_ID",
+		"VAULT_K8S_ROLE", "VAULT_K8S_MOUNT", "VAULT_K8S_TOKEN_FILE",
+		"VAULT_JWT_ROLE", "VAULT_JWT_MOUNT", "VAULT_JWT_FILE",
+	} {
+		t.Setenv(name, env[name])
+	}
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("HOME", t.TempDir())
+
+	store, err := NewVaultStore(testVaultKey)
+	if err != nil {
+		t.Fatalf("creating store: %s", err)
+	}
+
+	return store
+}
+
+func newTestVaultData() map[string]interface{} {
+	return map[string]interface{}{"client-id": "id", "client-secret": "secret"}
+}
+
+func TestVaultStoreDetectsKV(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		kvVersion       int
+		mountsForbidden bool
+		env             string
+		expectVersion   int
+	}{
+		{"v1 mount", 1, false, "", 1},
+		{"v2 mount", 2, false, "", 2},
+		{"v1 without mount lookup", 1, true, "", 1},
+		{"v2 without mount lookup", 2, true, "2", 2},
+		{"v1 forced", 1, false, "1", 1},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			api := &fakeVault{
+				kvVersion:       tc.kvVersion,
+				mountsForbidden: tc.mountsForbidden,
+				data:            newTestVaultData(),
+				version:         1,
+			}
+			store := newTestVaultStore(t, api, map[string]string{
+				"VAULT_TOKEN":      testVaultToken,
+				"VAULT_KV_VERSION": tc.env,
+			})
+
+			if store.kvVersion != tc.expectVersion {
+				t.Errorf("expected KV v%d, got v%d", tc.expectVersion, store.kvVersion)
+			}
+
+			expiry := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+			if err := store.UpdateToken("access", "refresh", expiry); err != nil {
+				t.Fatalf("updating token: %s", err)
+			}
+
+			at, rt, exp, err := store.GetToken()
+			if err != nil {
+				t.Fatalf("reading token: %s", err)
+			}
+			if at != "access" || rt != "refresh" || !exp.Equal(expiry) {
+				t.Errorf("unexpected token %q / %q / %s", at, rt, exp)
+			}
+
+			id, secret, err := store.GetClientCredentials()
+			if err != nil || id != "id" || secret != "secret" {
+				t.Errorf("client credentials were modified: %q / %q / %v", id, secret, err)
+			}
+		})
+	}
+}
+
+func TestVaultStoreRejectsV2OnV1Mount(t *testing.T) {
+	api := &fakeVault{kvVersion: 1, data: newTestVaultData()}
+
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", testVaultToken)
+	t.Setenv("VAULT_KV_VERSION", "2")
+
+	if _, err := NewVaultStore(testVaultKey); err == nil {
+		t.Error("expected error for KV v2 on v1 mount")
+	}
+}
+
+func TestVaultStoreChecksAndSets(t *testing.T) {
+	var (
+		expiry    = time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+		otherData = func(otherExpiry time.Time) map[string]interface{} {
+			return map[string]interface{}{
+				"access-token":  "other-access",
+				"refresh-token": "other-refresh",
+				"expiry":        otherExpiry.Format(time.RFC3339Nano),
+			}
+		}
+	)
+
+	for _, tc := range []struct {
+		name      string
+		conflicts int
+		other     map[string]interface{}
+		expectCAS []int
+		expectRT  string
+	}{
+		{"no conflict", 0, nil, []int{3}, "refresh"},
+		{"older concurrent token", 1, otherData(expiry.Add(-time.Minute)), []int{3, 4}, "refresh"},
+		{"newer concurrent token", 1, otherData(expiry.Add(time.Minute)), []int{3}, "other-refresh"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			api := &fakeVault{
+				kvVersion:      2,
+				data:           newTestVaultData(),
+				version:        3,
+				conflicts:      tc.conflicts,
+				concurrentData: tc.other,
+			}
+			store := newTestVaultStore(t, api, map[string]string{"VAULT_TOKEN": testVaultToken})
+
+			if err := store.UpdateToken("access", "refresh", expiry); err != nil {
+				t.Fatalf("updating token: %s", err)
+			}
+
+			if len(api.casVersions) != len(tc.expectCAS) {
+				t.Fatalf("expected writes with cas %v, got %v", tc.expectCAS, api.casVersions)
+			}
+			for i := range tc.expectCAS {
+				if api.casVersions[i] != tc.expectCAS[i] {
+					t.Errorf("expected writes with cas %v, got %v", tc.expectCAS, api.casVersions)
+				}
+			}
+
+			_, rt, _, err := store.GetToken()
+			if err != nil {
+				t.Fatalf("reading token: %s", err)
+			}
+			if rt != tc.expectRT {
+				t.Errorf("expected refresh token %q, got %q", tc.expectRT, rt)
+			}
+		})
+	}
+}
+
+func TestVaultStoreGivesUpOnPersistentConflict(t *testing.T) {
+	api := &fakeVault{kvVersion: 2, data: newTestVaultData(), version: 1, conflicts: 100}
+	store := newTestVaultStore(t, api, map[string]string{"VAULT_TOKEN": testVaultToken})
+
+	if err := store.UpdateToken("access", "refresh", time.Now()); err == nil || len(api.casVersions) != conflictRetries {
+		t.Errorf("expected conflict after %d attempts, got %v after %d", conflictRetries, err, len(api.casVersions))
+	}
+}
+
+func TestVaultAuthLogin(t *testing.T) {
+	jwtFile := filepath.Join(t.TempDir(), "jwt")
+	if err := os.WriteFile(jwtFile, []byte("my-jwt\n"), 0o600); err != nil {
+		t.Fatalf("writing JWT: %s", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		env    map[string]string
+		expect fakeVaultLogin
+	}{
+		{
+			"approle",
+			map[string]string{"VAULT_ROLE_ID": "role", "VAULT_SECRET_ID": "secret"},
+			fakeVaultLogin{"approle", map[string]string{"role_id": "role", "secret_id": "secret"}},
+		},
+		{
+			"kubernetes",
+			map[string]string{"VAULT_K8S_ROLE": "byocar", "VAULT_K8S_TOKEN_FILE": jwtFile},
+			fakeVaultLogin{"kubernetes", map[string]string{"role": "byocar", "jwt": "my-jwt"}},
+		},
+		{
+			"jwt with custom mount",
+			map[string]string{"VAULT_JWT_ROLE": "byocar", "VAULT_JWT_FILE": jwtFile, "VAULT_JWT_MOUNT": "gitlab"},
+			fakeVaultLogin{"gitlab", map[string]string{"role": "byocar", "jwt": "my-jwt"}},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			api := &fakeVault{kvVersion: 1, data: newTestVaultData(), ttl: 3600, renewable: true}
+			store := newTestVaultStore(t, api, tc.env)
+
+			if _, _, err := store.GetClientCredentials(); err != nil {
+				t.Fatalf("reading with logged in token: %s", err)
+			}
+
+			if len(api.logins) != 1 {
+				t.Fatalf("expected one login, got %d", len(api.logins))
+			}
+
+			login := api.logins[0]
+			if login.mount != tc.expect.mount || len(login.data) != len(tc.expect.data) {
+				t.Fatalf("expected login %+v, got %+v", tc.expect, login)
+			}
+			for k, v := range tc.expect.data {
+				if login.data[k] != v {
+					t.Errorf("expected login %+v, got %+v", tc.expect, login)
+				}
+			}
+		})
+	}
+}
+
+func TestVaultAuthRenewsToken(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		renewable      bool
+		expectLogins   int
+		expectRenewals int
+	}{
+		{"renewable", true, 1, 1},
+		{"not renewable", false, 2, 0},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			api := &fakeVault{kvVersion: 1, data: newTestVaultData(), ttl: 3600, renewable: tc.renewable}
+			store := newTestVaultStore(t, api, map[string]string{"VAULT_ROLE_ID": "role"})
+
+			// Token is valid for a while: no renewal
+			if _, _, err := store.GetClientCredentials(); err != nil {
+				t.Fatalf("reading: %s", err)
+			}
+			if len(api.logins) != 1 || api.renewals != 0 {
+				t.Fatalf("expected one login without renewal, got %d / %d", len(api.logins), api.renewals)
+			}
+
+			// Renewal is due
+			store.auth.lock.Lock()
+			store.auth.renewAt = time.Now().Add(-time.Second)
+			store.auth.lock.Unlock()
+
+			if _, _, err := store.GetClientCredentials(); err != nil {
+				t.Fatalf("reading: %s", err)
+			}
+
+			if len(api.logins) != tc.expectLogins || api.renewals != tc.expectRenewals {
+				t.Errorf("expected %d logins and %d renewals, got %d / %d", tc.expectLogins, tc.expectRenewals, len(api.logins), api.renewals)
+			}
+		})
+	}
+}
+
+func TestVaultAuthStaticToken(t *testing.T) {
+	api := &fakeVault{kvVersion: 1, data: newTestVaultData(), ttl: 3600, renewable: true}
+	store := newTestVaultStore(t, api, map[string]string{"VAULT_TOKEN": testVaultToken})
+
+	if len(api.logins) != 0 {
+		t.Errorf("expected no login with static token, got %d", len(api.logins))
+	}
+
+	if store.auth.expiry.IsZero() || !store.auth.renewable {
+		t.Errorf("expected TTL of static token to be looked up, got expiry %s", store.auth.expiry)
+	}
+}
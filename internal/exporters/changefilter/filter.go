@@ -0,0 +1,220 @@
+package changefilter
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/exporters"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+)
+
+type (
+	// Filter sits in front of another exporter and only forwards the
+	// fields whose reported timestamp or value changed since the last
+	// submission. Unchanged fields are reset to their zero value which
+	// all exporters treat as "not reported".
+	Filter struct {
+		lock          sync.Mutex
+		next          exporters.Exporter
+		forceInterval time.Duration
+
+		lastFull map[string]time.Time
+		seen     map[string]seenValue
+	}
+
+	seenValue struct {
+		t time.Time
+		v float64
+	}
+)
+
+var (
+	_ exporters.Closer         = (*Filter)(nil)
+	_ exporters.Exporter       = (*Filter)(nil)
+	_ exporters.Flusher        = (*Filter)(nil)
+	_ exporters.HealthReporter = (*Filter)(nil)
+	_ exporters.Starter        = (*Filter)(nil)
+	_ exporters.VehiclePruner  = (*Filter)(nil)
+)
+
+// New creates a Filter forwarding to next. If forceInterval is greater
+// than zero all fields of a container are forwarded again once the
+// interval has passed since the last full push.
+func New(next exporters.Exporter, forceInterval time.Duration) *Filter {
+	return &Filter{
+		next:          next,
+		forceInterval: forceInterval,
+
+		lastFull: make(map[string]time.Time),
+		seen:     make(map[string]seenValue),
+	}
+}
+
+// Close passes through to the next exporter if it is a Closer
+func (f *Filter) Close(ctx context.Context) error {
+	if c, ok := f.getNext().(exporters.Closer); ok {
+		return c.Close(ctx)
+	}
+	return nil
+}
+
+// Flush passes through to the next exporter if it is a Flusher
+func (f *Filter) Flush(ctx context.Context) error {
+	if fl, ok := f.getNext().(exporters.Flusher); ok {
+		return fl.Flush(ctx)
+	}
+	return nil
+}
+
+// Health passes through to the next exporter if it is a HealthReporter
+func (f *Filter) Health() []exporters.Health {
+	if h, ok := f.getNext().(exporters.HealthReporter); ok {
+		return h.Health()
+	}
+	return nil
+}
+
+// PruneVehicles forgets the values seen for all vehicles not in keep
+// and passes through to the next exporter if it is a VehiclePruner
+func (f *Filter) PruneVehicles(keep []string) {
+	keepSet := make(map[string]bool, len(keep))
+	for _, id := range keep {
+		keepSet[id] = true
+	}
+
+	f.lock.Lock()
+	for key := range f.lastFull {
+		if !keepSet[keyVehicle(key)] {
+			delete(f.lastFull, key)
+		}
+	}
+	for key := range f.seen {
+		if !keepSet[keyVehicle(key)] {
+			delete(f.seen, key)
+		}
+	}
+	f.lock.Unlock()
+
+	if p, ok := f.getNext().(exporters.VehiclePruner); ok {
+		p.PruneVehicles(keep)
+	}
+}
+
+// Reset forgets all values seen so far so the next submission of
+// every container is forwarded in full
+func (f *Filter) Reset() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.lastFull = make(map[string]time.Time)
+	f.seen = make(map[string]seenValue)
+}
+
+// SetNext swaps the exporter to forward to and the forced push interval
+// while keeping the values seen so far
+func (f *Filter) SetNext(next exporters.Exporter, forceInterval time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.next = next
+	f.forceInterval = forceInterval
+}
+
+// Start passes through to the next exporter if it is a Starter
+func (f *Filter) Start(ctx context.Context) error {
+	if st, ok := f.getNext().(exporters.Starter); ok {
+		return st.Start(ctx)
+	}
+	return nil
+}
+
+func (f *Filter) SetElectricStatus(vehicleID string, es mercedes.ElectricStatus) {
+	if f.filter(vehicleID, mercedes.ContainerElectricStatus, &es) {
+		f.getNext().SetElectricStatus(vehicleID, es)
+	}
+}
+
+func (f *Filter) SetFuelStatus(vehicleID string, fs mercedes.FuelStatus) {
+	if f.filter(vehicleID, mercedes.ContainerFuelStatus, &fs) {
+		f.getNext().SetFuelStatus(vehicleID, fs)
+	}
+}
+
+func (f *Filter) SetLockStatus(vehicleID string, ls mercedes.LockStatus) {
+	if f.filter(vehicleID, mercedes.ContainerLockStatus, &ls) {
+		f.getNext().SetLockStatus(vehicleID, ls)
+	}
+}
+
+func (f *Filter) SetPayAsYouGo(vehicleID string, p mercedes.PayAsYouDriveInsurance) {
+	if f.filter(vehicleID, mercedes.ContainerPayAsYouDrive, &p) {
+		f.getNext().SetPayAsYouGo(vehicleID, p)
+	}
+}
+
+func (f *Filter) SetVehicleStatus(vehicleID string, vs mercedes.VehicleStatus) {
+	if f.filter(vehicleID, mercedes.ContainerVehicleStatus, &vs) {
+		f.getNext().SetVehicleStatus(vehicleID, vs)
+	}
+}
+
+// filter resets all unchanged fields within the given container struct
+// pointer and reports whether there is anything left to submit
+func (f *Filter) filter(vehicleID string, container mercedes.Container, data any) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var (
+		containerKey = vehicleID + "/" + string(container)
+		forward      = false
+		now          = time.Now()
+		st           = reflect.ValueOf(data).Elem()
+	)
+
+	force := f.forceInterval > 0 && now.Sub(f.lastFull[containerKey]) >= f.forceInterval
+	if force {
+		f.lastFull[containerKey] = now
+	}
+
+	for i := 0; i < st.NumField(); i++ {
+		name := st.Type().Field(i).Tag.Get("apiField")
+		value, ok := st.Field(i).Interface().(mercedes.MetricValue)
+		if name == "" || !ok || !value.IsValid() {
+			continue
+		}
+
+		var (
+			key  = containerKey + "/" + name
+			last = f.seen[key]
+			cur  = seenValue{t: value.Time(), v: value.ToFloat()}
+		)
+
+		f.seen[key] = cur
+
+		if !force && last.t.Equal(cur.t) && last.v == cur.v {
+			st.Field(i).Set(reflect.Zero(st.Field(i).Type()))
+			continue
+		}
+
+		forward = true
+	}
+
+	return forward
+}
+
+// getNext returns the exporter to forward to
+func (f *Filter) getNext() exporters.Exporter {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.next
+}
+
+// keyVehicle returns the vehicle-id from a key in lastFull / seen
+func keyVehicle(key string) string {
+	id, _, _ := strings.Cut(key, "/")
+	return id
+}
@@ -0,0 +1,202 @@
+package changefilter
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+)
+
+const testVehicleID = "WDB111111ZZZ22222"
+
+type (
+	// recorder keeps the lock status submissions passed through the
+	// filter (other containers are ignored)
+	recorder struct {
+		lock   sync.Mutex
+		locks  []mercedes.LockStatus
+		pruned [][]string
+	}
+)
+
+func (r *recorder) PruneVehicles(keep []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.pruned = append(r.pruned, keep)
+}
+
+func (*recorder) SetElectricStatus(string, mercedes.ElectricStatus)     {}
+func (*recorder) SetFuelStatus(string, mercedes.FuelStatus)             {}
+func (*recorder) SetPayAsYouGo(string, mercedes.PayAsYouDriveInsurance) {}
+func (*recorder) SetVehicleStatus(string, mercedes.VehicleStatus)       {}
+func (r *recorder) SetLockStatus(_ string, ls mercedes.LockStatus) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.locks = append(r.locks, ls)
+}
+
+func (r *recorder) submissions() []mercedes.LockStatus {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return append([]mercedes.LockStatus(nil), r.locks...)
+}
+
+func testLockStatus(heading float64, ts time.Time) mercedes.LockStatus {
+	return mercedes.LockStatus{
+		DeckLidUnlocked: mercedes.NewTimedBool(false, ts),
+		Heading:         mercedes.NewTimedFloat(heading, ts),
+	}
+}
+
+func TestFilterSkipsUnchangedFields(t *testing.T) {
+	var (
+		r  = &recorder{}
+		f  = New(r, 0)
+		t0 = time.Unix(1000, 0)
+		t1 = time.Unix(2000, 0)
+	)
+
+	f.SetLockStatus(testVehicleID, testLockStatus(90, t0))
+	f.SetLockStatus(testVehicleID, testLockStatus(90, t0))
+
+	// Same value with a new timestamp is a new report
+	ls := testLockStatus(90, t0)
+	ls.Heading = mercedes.NewTimedFloat(90, t1)
+	f.SetLockStatus(testVehicleID, ls)
+
+	// Same timestamp with a new value as well
+	ls.DeckLidUnlocked = mercedes.NewTimedBool(true, t0)
+	f.SetLockStatus(testVehicleID, ls)
+
+	subs := r.submissions()
+	if len(subs) != 3 {
+		t.Fatalf("expected 3 submissions, got %d", len(subs))
+	}
+
+	if !subs[0].Heading.IsValid() || !subs[0].DeckLidUnlocked.IsValid() {
+		t.Error("expected first submission to contain all fields")
+	}
+
+	if !subs[1].Heading.IsValid() || subs[1].DeckLidUnlocked.IsValid() {
+		t.Errorf("expected only the heading in second submission, got %+v", subs[1])
+	}
+
+	if subs[2].Heading.IsValid() || !subs[2].DeckLidUnlocked.Bool() {
+		t.Errorf("expected only the deck lid in third submission, got %+v", subs[2])
+	}
+}
+
+func TestFilterSeparatesVehicles(t *testing.T) {
+	r := &recorder{}
+	f := New(r, 0)
+
+	f.SetLockStatus(testVehicleID, testLockStatus(90, time.Unix(1000, 0)))
+	f.SetLockStatus("WDB333333ZZZ44444", testLockStatus(90, time.Unix(1000, 0)))
+
+	if n := len(r.submissions()); n != 2 {
+		t.Errorf("expected both vehicles to be forwarded, got %d submissions", n)
+	}
+}
+
+func TestFilterForcesPushAfterInterval(t *testing.T) {
+	var (
+		r  = &recorder{}
+		f  = New(r, time.Hour)
+		ls = testLockStatus(90, time.Unix(1000, 0))
+	)
+
+	f.SetLockStatus(testVehicleID, ls)
+	f.SetLockStatus(testVehicleID, ls)
+
+	if n := len(r.submissions()); n != 1 {
+		t.Fatalf("expected unchanged data within interval to be skipped, got %d submissions", n)
+	}
+
+	// Pretend the last full push happened an interval ago
+	key := testVehicleID + "/" + string(mercedes.ContainerLockStatus)
+	f.lock.Lock()
+	f.lastFull[key] = f.lastFull[key].Add(-time.Hour)
+	f.lock.Unlock()
+
+	f.SetLockStatus(testVehicleID, ls)
+	f.SetLockStatus(testVehicleID, ls)
+
+	subs := r.submissions()
+	if len(subs) != 2 {
+		t.Fatalf("expected 2 submissions with one forced, got %d", len(subs))
+	}
+
+	if !subs[1].Heading.IsValid() || !subs[1].DeckLidUnlocked.IsValid() {
+		t.Errorf("expected forced submission to contain all fields, got %+v", subs[1])
+	}
+}
+
+func TestFilterPrunesVehicles(t *testing.T) {
+	var (
+		r     = &recorder{}
+		f     = New(r, time.Hour)
+		ls    = testLockStatus(90, time.Unix(1000, 0))
+		other = "WDB333333ZZZ44444"
+	)
+
+	f.SetLockStatus(testVehicleID, ls)
+	f.SetLockStatus(other, ls)
+
+	f.PruneVehicles([]string{testVehicleID})
+
+	f.lock.Lock()
+	for key := range f.seen {
+		if keyVehicle(key) == other {
+			t.Errorf("expected seen value %s to be pruned", key)
+		}
+	}
+	for key := range f.lastFull {
+		if keyVehicle(key) == other {
+			t.Errorf("expected last full push %s to be pruned", key)
+		}
+	}
+	f.lock.Unlock()
+
+	// The kept vehicle is still filtered, a re-added one starts over
+	f.SetLockStatus(testVehicleID, ls)
+	f.SetLockStatus(other, ls)
+
+	if n := len(r.submissions()); n != 3 {
+		t.Errorf("expected only the pruned vehicle to be forwarded again, got %d submissions", n)
+	}
+
+	if len(r.pruned) != 1 || len(r.pruned[0]) != 1 || r.pruned[0][0] != testVehicleID {
+		t.Errorf("expected prune to be passed through, got %v", r.pruned)
+	}
+}
+
+func TestFilterKeepsSeenValuesOnSetNext(t *testing.T) {
+	var (
+		r1 = &recorder{}
+		r2 = &recorder{}
+		f  = New(r1, 0)
+		ls = testLockStatus(90, time.Unix(1000, 0))
+	)
+
+	f.SetLockStatus(testVehicleID, ls)
+	f.SetNext(r2, 0)
+	f.SetLockStatus(testVehicleID, ls)
+
+	if n := len(r1.submissions()); n != 1 {
+		t.Errorf("expected 1 submission to the replaced exporter, got %d", n)
+	}
+	if n := len(r2.submissions()); n != 0 {
+		t.Errorf("expected unchanged values not to be forwarded after swap, got %d submissions", n)
+	}
+
+	f.Reset()
+	f.SetLockStatus(testVehicleID, ls)
+
+	if n := len(r2.submissions()); n != 1 {
+		t.Errorf("expected values to be forwarded after reset, got %d submissions", n)
+	}
+}
@@ -0,0 +1,151 @@
+package exporters
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/selfmetrics"
+)
+
+const labelExporter = "exporter"
+
+type (
+	// Health describes the state of an exporter
+	Health struct {
+		Name    string
+		Healthy bool
+		// LastError is the most recent error (might be older than the
+		// last success)
+		LastError     error
+		LastErrorAt   time.Time
+		LastSuccessAt time.Time
+	}
+
+	// Overflow tracks an episode of dropping points while a buffer is
+	// full so it is logged when it starts and ends instead of for every
+	// dropped point. Callers guard it with the lock of the buffer.
+	Overflow struct {
+		buffer  string
+		dropped int
+		status  *Status
+	}
+
+	// Status tracks the outcome of the operations of an exporter. It
+	// logs and counts errors and is used to implement HealthReporter.
+	Status struct {
+		name string
+
+		lock          sync.Mutex
+		lastError     error
+		lastErrorAt   time.Time
+		lastSuccessAt time.Time
+	}
+)
+
+var (
+	exporterErrors = selfmetrics.Factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "mercedes_byocar",
+		Subsystem: "exporter",
+		Name:      "errors_total",
+		Help:      "Errors reported by the exporter",
+	}, []string{labelExporter})
+
+	exporterHealthy = selfmetrics.Factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "mercedes_byocar",
+		Subsystem: "exporter",
+		Name:      "healthy",
+		Help:      "Whether the last operation of the exporter succeeded - 1 = healthy",
+	}, []string{labelExporter})
+
+	exporterLastSuccess = selfmetrics.Factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "mercedes_byocar",
+		Subsystem: "exporter",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Time of the last successful operation of the exporter",
+	}, []string{labelExporter})
+)
+
+// NewOverflow creates an Overflow for the named buffer recording the
+// start of an episode in the given Status
+func NewOverflow(status *Status, buffer string) *Overflow {
+	return &Overflow{buffer: buffer, status: status}
+}
+
+// Drop accounts dropped points, the first drop of an episode is
+// recorded as error
+func (o *Overflow) Drop(n int) {
+	if n <= 0 {
+		return
+	}
+
+	if o.dropped == 0 {
+		o.status.RecordError(errors.Errorf("%s full, dropping the oldest points", o.buffer))
+	}
+	o.dropped += n
+}
+
+// End finishes the current episode (i.e. when the buffer was written)
+// and logs the number of points dropped in it
+func (o *Overflow) End() {
+	if o.dropped == 0 {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"dropped":     o.dropped,
+		labelExporter: o.status.name,
+	}).Warnf("%s no longer full", o.buffer)
+	o.dropped = 0
+}
+
+// NewStatus creates a healthy Status for the exporter with given name
+func NewStatus(name string) *Status {
+	exporterErrors.WithLabelValues(name).Add(0)
+	exporterHealthy.WithLabelValues(name).Set(1)
+
+	return &Status{name: name}
+}
+
+// Health reports the current state, the exporter is healthy unless the
+// most recent operation failed
+func (s *Status) Health() Health {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return Health{
+		Name:          s.name,
+		Healthy:       !s.lastErrorAt.After(s.lastSuccessAt),
+		LastError:     s.lastError,
+		LastErrorAt:   s.lastErrorAt,
+		LastSuccessAt: s.lastSuccessAt,
+	}
+}
+
+// RecordError logs the error and marks the exporter unhealthy
+func (s *Status) RecordError(err error) {
+	logrus.WithError(err).WithField(labelExporter, s.name).Error("exporter failed")
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.lastError = err
+	s.lastErrorAt = time.Now()
+
+	exporterErrors.WithLabelValues(s.name).Inc()
+	exporterHealthy.WithLabelValues(s.name).Set(0)
+}
+
+// RecordSuccess marks the exporter healthy
+func (s *Status) RecordSuccess() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.lastSuccessAt = time.Now()
+
+	exporterHealthy.WithLabelValues(s.name).Set(1)
+	exporterLastSuccess.WithLabelValues(s.name).Set(float64(s.lastSuccessAt.UnixNano()) / float64(time.Second))
+}
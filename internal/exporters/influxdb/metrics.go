@@ -1,45 +1,117 @@
 package influxdb
 
 import (
+	"context"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	influx "github.com/influxdata/influxdb1-client/v2"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/Luzifer/mercedes-byocar-exporter/internal/exporters"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/exporters/influxpoint"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/selfmetrics"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/spool"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/vehicle"
 )
 
 const (
+	defaultBufferSize = 10000
+
 	influxTimeout       = 2 * time.Second
 	influxWriteInterval = 10 * time.Second
 )
 
 type (
 	Exporter struct {
-		batch     influx.BatchPoints
-		batchLock sync.Mutex
-		client    influx.Client
-		database  string
-		errs      chan error
+		influxpoint.Setter
+
+		batch      influx.BatchPoints
+		batchLock  sync.Mutex
+		bufferSize int
+		client     influx.Client
+		database   string
+		spool      *spool.Spool
+		status     *exporters.Status
+
+		// batchOverflow is guarded by batchLock, spoolOverflow by
+		// writeLock
+		batchOverflow *exporters.Overflow
+		spoolOverflow *exporters.Overflow
+
+		// writeLock serializes the writes of the send loop and Flush
+		writeLock sync.Mutex
+
+		closeOnce sync.Once
+		done      chan struct{}
+		started   atomic.Bool
+		stop      chan struct{}
 	}
 )
 
-var _ exporters.Exporter = (*Exporter)(nil)
+var (
+	_ exporters.Closer         = (*Exporter)(nil)
+	_ exporters.Exporter       = (*Exporter)(nil)
+	_ exporters.Flusher        = (*Exporter)(nil)
+	_ exporters.HealthReporter = (*Exporter)(nil)
+	_ exporters.Starter        = (*Exporter)(nil)
+)
 
-func New(connURL string) (*Exporter, error) {
+var (
+	droppedPoints = selfmetrics.Factory.NewCounter(prometheus.CounterOpts{
+		Namespace: "mercedes_byocar",
+		Subsystem: "influxdb",
+		Name:      "dropped_points_total",
+		Help:      "Number of points dropped because the batch was full",
+	})
+
+	writeBatchSize = selfmetrics.Factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "mercedes_byocar",
+		Subsystem: "influxdb",
+		Name:      "write_batch_points",
+		Help:      "Number of points written per batch",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 8), //nolint:gomnd // 1 to ~16k points
+	})
+
+	writeFailures = selfmetrics.Factory.NewCounter(prometheus.CounterOpts{
+		Namespace: "mercedes_byocar",
+		Subsystem: "influxdb",
+		Name:      "write_failures_total",
+		Help:      "Number of failed batch writes",
+	})
+
+	writtenPoints = selfmetrics.Factory.NewCounter(prometheus.CounterOpts{
+		Namespace: "mercedes_byocar",
+		Subsystem: "influxdb",
+		Name:      "written_points_total",
+		Help:      "Number of points written to InfluxDB",
+	})
+)
+
+// New creates an Exporter from a connection URL:
+// http[s]://user:pass@host[:port]/database
+// To persist points which could not be written add spool-dir and
+// optionally spool-max-mb and spool-max-age as query parameters.
+// Without spool failed points are kept in memory for the next write,
+// up to buffer-size points (the oldest points are dropped).
+// Points are tagged using the given registry (might be nil).
+func New(connURL string, vehicles *vehicle.Registry) (*Exporter, error) {
 	out := &Exporter{
-		errs: make(chan error, 10), //nolint: gomnd // Is a constant but makes no sense to name
+		done:   make(chan struct{}),
+		status: exporters.NewStatus("influxdb"),
+		stop:   make(chan struct{}),
 	}
+	out.batchOverflow = exporters.NewOverflow(out.status, "batch")
+	out.spoolOverflow = exporters.NewOverflow(out.status, "spool")
+	out.Setter = influxpoint.NewSetter(vehicles, out.RecordPoint)
 	return out, out.initialize(connURL)
 }
 
-func (e *Exporter) Errors() <-chan error {
-	return e.errs
-}
-
 func (e *Exporter) RecordPoint(name string, tags map[string]string, fields map[string]interface{}, updatedAt time.Time) error {
 	pt, err := influx.NewPoint(name, tags, fields, updatedAt)
 	if err != nil {
@@ -50,6 +122,18 @@ func (e *Exporter) RecordPoint(name string, tags map[string]string, fields map[s
 	defer e.batchLock.Unlock()
 	e.batch.AddPoint(pt)
 
+	if overflow := len(e.batch.Points()) - e.bufferSize; overflow > 0 {
+		keep := e.batch.Points()[overflow:]
+		if err = e.resetBatch(); err != nil {
+			return err
+		}
+		e.batch.AddPoints(keep)
+
+		// Logged once per episode, every drop is counted
+		droppedPoints.Add(float64(overflow))
+		e.batchOverflow.Drop(overflow)
+	}
+
 	return nil
 }
 
@@ -65,19 +149,147 @@ func (e *Exporter) resetBatch() error {
 	return nil
 }
 
+// Close stops the background writes, writes the pending points (or
+// spools them) and closes the connection
+func (e *Exporter) Close(ctx context.Context) error {
+	e.closeOnce.Do(func() {
+		close(e.stop)
+	})
+
+	if e.started.Load() {
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "waiting for send loop")
+		}
+	}
+
+	if err := e.Flush(ctx); err != nil {
+		return err
+	}
+
+	return errors.Wrap(e.client.Close(), "closing client")
+}
+
+// Flush writes the pending points immediately. When a spool is
+// configured points which could not be written are spooled.
+func (e *Exporter) Flush(ctx context.Context) error {
+	errs := make(chan error, 1)
+	go func() { errs <- e.write() }()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "flushing points")
+	}
+}
+
+// Health reports whether the last write succeeded
+func (e *Exporter) Health() []exporters.Health {
+	return []exporters.Health{e.status.Health()}
+}
+
+// Start starts writing the collected points in the background
+func (e *Exporter) Start(context.Context) error {
+	if e.started.CompareAndSwap(false, true) {
+		go e.sendLoop()
+	}
+	return nil
+}
+
 func (e *Exporter) sendLoop() {
-	for range time.Tick(influxWriteInterval) {
+	defer close(e.done)
+
+	if e.spool != nil {
+		// Replay what was left over from the last run
+		e.writeLock.Lock()
+		e.replaySpool()
+		e.writeLock.Unlock()
+	}
 
+	ticker := time.NewTicker(influxWriteInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := e.write(); err != nil {
+				e.status.RecordError(err)
+			}
+
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// write sends the current batch, with a spool the spool is replayed
+// first and the batch is spooled if it cannot be written
+func (e *Exporter) write() error {
+	e.writeLock.Lock()
+	defer e.writeLock.Unlock()
+
+	if e.spool == nil {
 		e.batchLock.Lock()
-		if err := e.client.Write(e.batch); err != nil {
-			e.errs <- err
-			e.batchLock.Unlock()
-			continue
+		defer e.batchLock.Unlock()
+
+		if err := e.writeBatch(e.batch); err != nil {
+			return errors.Wrap(err, "writing batch")
 		}
-		e.resetBatch()
+		e.status.RecordSuccess()
+		e.batchOverflow.End()
+		return e.resetBatch()
+	}
+
+	e.batchLock.Lock()
+	batch := e.batch
+	if err := e.resetBatch(); err != nil {
 		e.batchLock.Unlock()
+		return errors.Wrap(err, "resetting batch")
+	}
+	// The batch is written or spooled below
+	e.batchOverflow.End()
+	e.batchLock.Unlock()
 
+	// Write the spool first: if InfluxDB is still not available the
+	// current batch is spooled without another attempt
+	spoolEmpty := e.replaySpool()
+	if spoolEmpty {
+		e.spoolOverflow.End()
 	}
+	if len(batch.Points()) == 0 {
+		return nil
+	}
+
+	if spoolEmpty {
+		err := e.writeBatch(batch)
+		if err == nil {
+			e.status.RecordSuccess()
+			return nil
+		}
+		e.status.RecordError(errors.Wrap(err, "writing batch"))
+	}
+
+	e.spoolBatch(batch)
+	return nil
+}
+
+// writeBatch writes the batch and accounts it in the self-metrics
+func (e *Exporter) writeBatch(batch influx.BatchPoints) error {
+	n := len(batch.Points())
+	if n == 0 {
+		return nil
+	}
+
+	if err := e.client.Write(batch); err != nil {
+		writeFailures.Inc()
+		return err
+	}
+
+	writeBatchSize.Observe(float64(n))
+	writtenPoints.Add(float64(n))
+	return nil
 }
 
 func (e *Exporter) initialize(connURL string) error {
@@ -87,6 +299,17 @@ func (e *Exporter) initialize(connURL string) error {
 	}
 	e.database = strings.TrimLeft(connInfo.Path, "/")
 
+	e.bufferSize = defaultBufferSize
+	if v := connInfo.Query().Get("buffer-size"); v != "" {
+		if e.bufferSize, err = strconv.Atoi(v); err != nil || e.bufferSize < 1 {
+			return errors.Errorf("invalid buffer-size %q", v)
+		}
+	}
+
+	if err = e.initSpool(connInfo.Query()); err != nil {
+		return err
+	}
+
 	cfg := influx.HTTPConfig{
 		Addr:    (&url.URL{Scheme: connInfo.Scheme, Host: connInfo.Host}).String(),
 		Timeout: influxTimeout,
@@ -103,10 +326,5 @@ func (e *Exporter) initialize(connURL string) error {
 	}
 
 	e.client = influxClient
-	if err := e.resetBatch(); err != nil {
-		return err
-	}
-	go e.sendLoop()
-
-	return nil
+	return e.resetBatch()
 }
@@ -0,0 +1,48 @@
+package influxdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordPointLogsOverflowOnce(t *testing.T) {
+	e, err := New("http://localhost:8086/test?buffer-size=2", nil)
+	if err != nil {
+		t.Fatalf("creating exporter: %s", err)
+	}
+	client := &fakeClient{}
+	e.client = client
+
+	record := func(n int) {
+		t.Helper()
+
+		for i := 0; i < n; i++ {
+			if err := e.RecordPoint("range", map[string]string{"vehicle": "test"}, map[string]interface{}{"value": float64(i)}, time.Unix(int64(i+1), 0)); err != nil {
+				t.Fatalf("recording point: %s", err)
+			}
+		}
+	}
+
+	record(3) //nolint:gomnd // One point over the buffer size
+	firstErr := e.Health()[0].LastErrorAt
+	if firstErr.IsZero() {
+		t.Fatal("expected the overflow to be recorded as error")
+	}
+
+	record(2) //nolint:gomnd // Still overflowing
+	if h := e.Health()[0]; !h.LastErrorAt.Equal(firstErr) {
+		t.Errorf("expected overflow to be recorded once per episode, got another error at %s", h.LastErrorAt)
+	}
+
+	if err = e.write(); err != nil {
+		t.Fatalf("writing batch: %s", err)
+	}
+	if w := client.written(); len(w) != 2 { //nolint:gomnd // Buffer size
+		t.Errorf("expected the buffered points to be written, got %q", w)
+	}
+
+	record(3) //nolint:gomnd // Starts a new episode
+	if h := e.Health()[0]; !h.LastErrorAt.After(firstErr) {
+		t.Error("expected a new episode to be recorded as error")
+	}
+}
@@ -0,0 +1,175 @@
+package influxdb
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb1-client/models"
+	influx "github.com/influxdata/influxdb1-client/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/selfmetrics"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/spool"
+)
+
+const (
+	bytesPerMiB = 1024 * 1024
+
+	defaultSpoolMaxAge = 7 * 24 * time.Hour
+	defaultSpoolMaxMiB = 100
+)
+
+var (
+	spoolDroppedPoints = selfmetrics.Factory.NewCounter(prometheus.CounterOpts{
+		Namespace: "mercedes_byocar",
+		Subsystem: "influxdb_spool",
+		Name:      "dropped_points_total",
+		Help:      "Points dropped from the spool due to size / age limits or corrupt segments",
+	})
+
+	spoolQueuedPoints = selfmetrics.Factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: "mercedes_byocar",
+		Subsystem: "influxdb_spool",
+		Name:      "queued_points",
+		Help:      "Points waiting in the spool to be written to InfluxDB",
+	})
+
+	spoolReplayedPoints = selfmetrics.Factory.NewCounter(prometheus.CounterOpts{
+		Namespace: "mercedes_byocar",
+		Subsystem: "influxdb_spool",
+		Name:      "replayed_points_total",
+		Help:      "Points successfully written to InfluxDB from the spool",
+	})
+
+	spoolSize = selfmetrics.Factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: "mercedes_byocar",
+		Subsystem: "influxdb_spool",
+		Name:      "size_bytes",
+		Help:      "Size of the spool on disk",
+	})
+)
+
+// initSpool opens the spool configured through the query parameters
+// spool-dir, spool-max-mb and spool-max-age of the connection URL
+func (e *Exporter) initSpool(params url.Values) (err error) {
+	dir := params.Get("spool-dir")
+	if dir == "" {
+		return nil
+	}
+
+	maxMiB := int64(defaultSpoolMaxMiB)
+	if v := params.Get("spool-max-mb"); v != "" {
+		if maxMiB, err = strconv.ParseInt(v, 10, 64); err != nil || maxMiB < 0 {
+			return errors.Errorf("invalid spool-max-mb %q", v)
+		}
+	}
+
+	maxAge := defaultSpoolMaxAge
+	if v := params.Get("spool-max-age"); v != "" {
+		if maxAge, err = time.ParseDuration(v); err != nil || maxAge < 0 {
+			return errors.Errorf("invalid spool-max-age %q", v)
+		}
+	}
+
+	if e.spool, err = spool.Open(dir, maxMiB*bytesPerMiB, maxAge); err != nil {
+		return errors.Wrap(err, "opening spool")
+	}
+
+	e.updateSpoolMetrics()
+	return nil
+}
+
+// replaySpool writes spooled segments from oldest to newest and reports
+// whether the spool was emptied
+func (e *Exporter) replaySpool() bool {
+	dropped, err := e.spool.Prune()
+	spoolDroppedPoints.Add(float64(dropped))
+	if err != nil {
+		e.status.RecordError(errors.Wrap(err, "pruning spool"))
+	}
+
+	defer e.updateSpoolMetrics()
+
+	for {
+		seg, data, err := e.spool.Oldest()
+		if err != nil {
+			e.status.RecordError(errors.Wrap(err, "reading spool"))
+			return false
+		}
+
+		if seg == nil {
+			return true
+		}
+
+		batch, err := e.parseSegment(data)
+		if err != nil {
+			// Segment is unusable, retrying would block the spool forever
+			e.status.RecordError(errors.Wrapf(err, "parsing spool segment %s, dropping it", seg.Name))
+			spoolDroppedPoints.Add(float64(seg.Records))
+		} else if err = e.writeBatch(batch); err != nil {
+			e.status.RecordError(errors.Wrap(err, "replaying spool"))
+			return false
+		} else {
+			e.status.RecordSuccess()
+			spoolReplayedPoints.Add(float64(seg.Records))
+		}
+
+		if err = e.spool.Remove(seg); err != nil {
+			e.status.RecordError(errors.Wrap(err, "removing replayed spool segment"))
+			return false
+		}
+	}
+}
+
+func (e *Exporter) parseSegment(data []byte) (influx.BatchPoints, error) {
+	points, err := models.ParsePointsWithPrecision(data, time.Now(), "n")
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing points")
+	}
+
+	batch, err := influx.NewBatchPoints(influx.BatchPointsConfig{Database: e.database})
+	if err != nil {
+		return nil, errors.Wrap(err, "creating batch")
+	}
+
+	for _, p := range points {
+		batch.AddPoint(influx.NewPointFrom(p))
+	}
+
+	return batch, nil
+}
+
+// spoolBatch persists the batch which could not be written
+func (e *Exporter) spoolBatch(batch influx.BatchPoints) {
+	points := batch.Points()
+
+	lines := make([]string, 0, len(points))
+	for _, p := range points {
+		lines = append(lines, p.String())
+	}
+
+	dropped, err := e.spool.Add([]byte(strings.Join(lines, "\n")+"\n"), len(points))
+	spoolDroppedPoints.Add(float64(dropped))
+	e.spoolOverflow.Drop(dropped)
+	if err != nil {
+		// Without spool the points are lost, there is nothing more to do
+		spoolDroppedPoints.Add(float64(len(points)))
+		e.status.RecordError(errors.Wrapf(err, "spooling %d points", len(points)))
+	}
+
+	e.updateSpoolMetrics()
+}
+
+func (e *Exporter) updateSpoolMetrics() {
+	st, err := e.spool.Stats()
+	if err != nil {
+		e.status.RecordError(errors.Wrap(err, "getting spool stats"))
+		return
+	}
+
+	spoolQueuedPoints.Set(float64(st.Records))
+	spoolSize.Set(float64(st.Size))
+}
@@ -0,0 +1,173 @@
+package influxdb
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+	"github.com/pkg/errors"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/spool"
+)
+
+// fakeClient records the written points and fails writes while err
+// is set
+type fakeClient struct {
+	lock   sync.Mutex
+	err    error
+	points []string
+}
+
+var _ influx.Client = (*fakeClient)(nil)
+
+func (*fakeClient) Close() error { return nil }
+
+func (*fakeClient) Ping(time.Duration) (time.Duration, string, error) { return 0, "", nil }
+
+func (*fakeClient) Query(influx.Query) (*influx.Response, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (*fakeClient) QueryAsChunk(influx.Query) (*influx.ChunkedResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakeClient) Write(bp influx.BatchPoints) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.err != nil {
+		return f.err
+	}
+
+	for _, p := range bp.Points() {
+		f.points = append(f.points, p.String())
+	}
+	return nil
+}
+
+func (f *fakeClient) setErr(err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.err = err
+}
+
+func (f *fakeClient) written() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]string(nil), f.points...)
+}
+
+func newSpoolTestExporter(t *testing.T, dir string) (*Exporter, *fakeClient) {
+	t.Helper()
+
+	e, err := New("http://localhost:8086/test?spool-dir="+url.QueryEscape(dir), nil)
+	if err != nil {
+		t.Fatalf("creating exporter: %s", err)
+	}
+
+	client := &fakeClient{}
+	e.client = client
+
+	return e, client
+}
+
+func spoolStats(t *testing.T, e *Exporter) spool.Stats {
+	t.Helper()
+
+	st, err := e.spool.Stats()
+	if err != nil {
+		t.Fatalf("getting spool stats: %s", err)
+	}
+
+	return st
+}
+
+func TestSpoolKeepsFailedWritesUntilDelivered(t *testing.T) {
+	e, client := newSpoolTestExporter(t, t.TempDir())
+	client.setErr(errors.New("influxdb unavailable"))
+
+	if err := e.RecordPoint("range", map[string]string{"vehicle": "test"}, map[string]interface{}{"value": 1.0}, time.Unix(1, 0)); err != nil {
+		t.Fatalf("recording point: %s", err)
+	}
+
+	if err := e.write(); err != nil {
+		t.Fatalf("writing batch: %s", err)
+	}
+
+	if st := spoolStats(t, e); st.Segments != 1 || st.Records != 1 {
+		t.Fatalf("expected failed point to be spooled, got %+v", st)
+	}
+
+	client.setErr(nil)
+	if err := e.write(); err != nil {
+		t.Fatalf("writing batch: %s", err)
+	}
+
+	if st := spoolStats(t, e); st.Segments != 0 {
+		t.Errorf("expected delivered segment to be removed, got %+v", st)
+	}
+
+	if w := client.written(); len(w) != 1 || w[0] != "range,vehicle=test value=1 1000000000" {
+		t.Errorf("unexpected written points: %q", w)
+	}
+}
+
+func TestSpoolReplaysOnStart(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := spool.Open(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("opening spool: %s", err)
+	}
+	if _, err = s.Add([]byte("range,vehicle=test value=1 1000000000\nrange,vehicle=test value=2 2000000000\n"), 2); err != nil {
+		t.Fatalf("adding segment: %s", err)
+	}
+
+	e, client := newSpoolTestExporter(t, dir)
+	if err = e.Start(context.Background()); err != nil {
+		t.Fatalf("starting exporter: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = e.Close(ctx); err != nil {
+		t.Fatalf("closing exporter: %s", err)
+	}
+
+	if w := client.written(); len(w) != 2 {
+		t.Errorf("expected 2 replayed points, got %q", w)
+	}
+
+	if st := spoolStats(t, e); st.Segments != 0 {
+		t.Errorf("expected spool to be empty after replay, got %+v", st)
+	}
+}
+
+func TestSpoolDropsCorruptSegment(t *testing.T) {
+	e, client := newSpoolTestExporter(t, t.TempDir())
+
+	if _, err := e.spool.Add([]byte("this is not line protocol\n"), 3); err != nil {
+		t.Fatalf("adding corrupt segment: %s", err)
+	}
+	if _, err := e.spool.Add([]byte("range,vehicle=test value=1 1000000000\n"), 1); err != nil {
+		t.Fatalf("adding segment: %s", err)
+	}
+
+	if !e.replaySpool() {
+		t.Fatal("expected spool to be emptied")
+	}
+
+	if w := client.written(); len(w) != 1 {
+		t.Errorf("expected the valid segment to be written, got %q", w)
+	}
+
+	if st := spoolStats(t, e); st.Segments != 0 {
+		t.Errorf("expected corrupt segment to be removed, got %+v", st)
+	}
+}
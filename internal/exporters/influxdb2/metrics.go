@@ -0,0 +1,437 @@
+package influxdb2
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/influxdata/influxdb1-client/models"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/exporters"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/exporters/influxpoint"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/selfmetrics"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/vehicle"
+)
+
+const (
+	defaultBatchSize  = 1000
+	defaultBufferSize = 10000
+	defaultPrecision  = "s"
+
+	influxTimeout       = 5 * time.Second
+	influxWriteInterval = 10 * time.Second
+
+	retryBaseDelay = influxWriteInterval
+	retryMaxDelay  = 10 * time.Minute
+)
+
+type (
+	// Exporter writes line protocol through the InfluxDB v2 write API.
+	// Points are kept in a bounded buffer until written: when the buffer
+	// is full the oldest points are dropped.
+	Exporter struct {
+		influxpoint.Setter
+
+		batchSize  int
+		bufferSize int
+		client     *http.Client
+		gzip       bool
+		precision  string
+		token      string
+		writeURL   string
+		status     *exporters.Status
+
+		bufferLock sync.Mutex
+		buffer     []string
+		overflow   *exporters.Overflow
+		// removed counts all points removed from the head of the buffer
+		removed uint64
+
+		// writeLock serializes the writes of the send loop and Flush
+		// and guards the retry state
+		writeLock  sync.Mutex
+		failures   int
+		retryAfter time.Time
+
+		closeOnce sync.Once
+		done      chan struct{}
+		started   atomic.Bool
+		stop      chan struct{}
+	}
+)
+
+var (
+	_ exporters.Closer         = (*Exporter)(nil)
+	_ exporters.Exporter       = (*Exporter)(nil)
+	_ exporters.Flusher        = (*Exporter)(nil)
+	_ exporters.HealthReporter = (*Exporter)(nil)
+	_ exporters.Starter        = (*Exporter)(nil)
+)
+
+var (
+	droppedPoints = selfmetrics.Factory.NewCounter(prometheus.CounterOpts{
+		Namespace: "mercedes_byocar",
+		Subsystem: "influxdb2",
+		Name:      "dropped_points_total",
+		Help:      "Number of points dropped because the buffer was full or InfluxDB rejected them",
+	})
+
+	writeBatchSize = selfmetrics.Factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "mercedes_byocar",
+		Subsystem: "influxdb2",
+		Name:      "write_batch_points",
+		Help:      "Number of points written per batch",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 8), //nolint:gomnd // 1 to ~16k points
+	})
+
+	writeFailures = selfmetrics.Factory.NewCounter(prometheus.CounterOpts{
+		Namespace: "mercedes_byocar",
+		Subsystem: "influxdb2",
+		Name:      "write_failures_total",
+		Help:      "Number of failed batch writes",
+	})
+
+	writtenPoints = selfmetrics.Factory.NewCounter(prometheus.CounterOpts{
+		Namespace: "mercedes_byocar",
+		Subsystem: "influxdb2",
+		Name:      "written_points_total",
+		Help:      "Number of points written to InfluxDB",
+	})
+)
+
+// New creates an Exporter from a connection URL:
+// http[s]://:token@host[:port]/org/bucket
+// Supported query parameters: precision (ns, us, ms, s), gzip,
+// buffer-size, batch-size
+// Points are tagged using the given registry (might be nil).
+func New(connURL string, vehicles *vehicle.Registry) (*Exporter, error) {
+	out := &Exporter{
+		client: &http.Client{Timeout: influxTimeout},
+		done:   make(chan struct{}),
+		status: exporters.NewStatus("influxdb2"),
+		stop:   make(chan struct{}),
+	}
+	out.overflow = exporters.NewOverflow(out.status, "buffer")
+	out.Setter = influxpoint.NewSetter(vehicles, out.RecordPoint)
+	return out, out.initialize(connURL)
+}
+
+func (e *Exporter) RecordPoint(name string, tags map[string]string, fields map[string]interface{}, updatedAt time.Time) error {
+	pt, err := models.NewPoint(name, models.NewTags(tags), fields, updatedAt)
+	if err != nil {
+		return err
+	}
+
+	e.bufferLock.Lock()
+	defer e.bufferLock.Unlock()
+
+	e.buffer = append(e.buffer, pt.PrecisionString(precisionMultiplierKey(e.precision)))
+	if overflow := len(e.buffer) - e.bufferSize; overflow > 0 {
+		e.buffer = e.buffer[overflow:]
+		e.removed += uint64(overflow)
+		// Logged once per episode, every drop is counted
+		droppedPoints.Add(float64(overflow))
+		e.overflow.Drop(overflow)
+	}
+
+	return nil
+}
+
+func (e *Exporter) initialize(connURL string) error {
+	connInfo, err := url.Parse(connURL)
+	if err != nil {
+		return errors.Wrap(err, "parsing connection URL")
+	}
+
+	pathParts := strings.Split(strings.Trim(connInfo.Path, "/"), "/")
+	if len(pathParts) != 2 || pathParts[0] == "" || pathParts[1] == "" { //nolint:gomnd // org and bucket
+		return errors.New("connection URL path must be /<org>/<bucket>")
+	}
+
+	if connInfo.User != nil {
+		e.token, _ = connInfo.User.Password()
+	}
+	if e.token == "" {
+		return errors.New("connection URL must contain token as password")
+	}
+
+	params := connInfo.Query()
+
+	e.precision = defaultPrecision
+	if p := params.Get("precision"); p != "" {
+		if precisionMultiplierKey(p) == "" {
+			return errors.Errorf("invalid precision %q", p)
+		}
+		e.precision = p
+	}
+
+	e.gzip = true
+	if v := params.Get("gzip"); v != "" {
+		if e.gzip, err = strconv.ParseBool(v); err != nil {
+			return errors.Wrap(err, "parsing gzip")
+		}
+	}
+
+	if e.bufferSize, err = intParam(params, "buffer-size", defaultBufferSize); err != nil {
+		return err
+	}
+
+	if e.batchSize, err = intParam(params, "batch-size", defaultBatchSize); err != nil {
+		return err
+	}
+
+	e.writeURL = (&url.URL{
+		Scheme: connInfo.Scheme,
+		Host:   connInfo.Host,
+		Path:   "/api/v2/write",
+		RawQuery: url.Values{
+			"org":       []string{pathParts[0]},
+			"bucket":    []string{pathParts[1]},
+			"precision": []string{e.precision},
+		}.Encode(),
+	}).String()
+
+	return nil
+}
+
+// Close stops the background writes and writes the pending points
+func (e *Exporter) Close(ctx context.Context) error {
+	e.closeOnce.Do(func() {
+		close(e.stop)
+	})
+
+	if e.started.Load() {
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "waiting for send loop")
+		}
+	}
+
+	return e.Flush(ctx)
+}
+
+// Flush writes all buffered points immediately, ignoring a pending
+// retry delay
+func (e *Exporter) Flush(ctx context.Context) error {
+	e.writeLock.Lock()
+	defer e.writeLock.Unlock()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return errors.Wrap(err, "flushing points")
+		}
+
+		more, err := e.writeBatch(ctx)
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+	}
+}
+
+// Health reports whether the last write succeeded
+func (e *Exporter) Health() []exporters.Health {
+	return []exporters.Health{e.status.Health()}
+}
+
+// Start starts writing the buffered points in the background
+func (e *Exporter) Start(context.Context) error {
+	if e.started.CompareAndSwap(false, true) {
+		go e.sendLoop()
+	}
+	return nil
+}
+
+func (e *Exporter) sendLoop() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(influxWriteInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.writeLock.Lock()
+			if time.Now().After(e.retryAfter) {
+				for {
+					// Continue writing batches until buffer is empty or an error occurred
+					if more, err := e.writeBatch(context.Background()); !more || err != nil {
+						break
+					}
+				}
+			}
+			e.writeLock.Unlock()
+
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// writeBatch writes the oldest points from the buffer and reports
+// whether there might be more points to write. An error is returned
+// when the points were kept in the buffer to retry later. The caller
+// must hold the writeLock.
+func (e *Exporter) writeBatch(ctx context.Context) (bool, error) {
+	e.bufferLock.Lock()
+	n := len(e.buffer)
+	if n > e.batchSize {
+		n = e.batchSize
+	}
+	lines := append([]string(nil), e.buffer[:n]...)
+	removedBefore := e.removed
+	e.bufferLock.Unlock()
+
+	if n == 0 {
+		return false, nil
+	}
+
+	retryAfter, err := e.write(ctx, lines)
+	if err != nil {
+		writeFailures.Inc()
+
+		var permanent permanentError
+		if !errors.As(err, &permanent) {
+			// Keep the points in the buffer and retry later
+			e.failures++
+			delay := retryBaseDelay << (e.failures - 1)
+			if delay > retryMaxDelay || delay <= 0 {
+				delay = retryMaxDelay
+			}
+			if retryAfter > delay {
+				delay = retryAfter
+			}
+			e.retryAfter = time.Now().Add(delay)
+
+			err = errors.Wrapf(err, "writing points (retry in %s)", delay)
+			e.status.RecordError(err)
+			return false, err
+		}
+
+		// Retrying won't help, drop the batch to not block the buffer
+		droppedPoints.Add(float64(n))
+		e.status.RecordError(errors.Wrapf(err, "writing points (dropped %d points)", n))
+	} else {
+		writeBatchSize.Observe(float64(n))
+		writtenPoints.Add(float64(n))
+		e.status.RecordSuccess()
+	}
+
+	e.failures = 0
+
+	e.bufferLock.Lock()
+	defer e.bufferLock.Unlock()
+
+	// Points of this batch might have been dropped from the buffer
+	// while writing, only remove what is left of the batch
+	if left := n - int(e.removed-removedBefore); left > 0 {
+		e.buffer = e.buffer[left:]
+		e.removed += uint64(left)
+	}
+	e.overflow.End()
+
+	return len(e.buffer) > 0, nil
+}
+
+func (e *Exporter) write(ctx context.Context, lines []string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, influxTimeout)
+	defer cancel()
+
+	var (
+		body    = new(bytes.Buffer)
+		payload = strings.Join(lines, "\n") + "\n"
+	)
+
+	if e.gzip {
+		gw := gzip.NewWriter(body)
+		if _, err := gw.Write([]byte(payload)); err != nil {
+			return 0, errors.Wrap(err, "compressing payload")
+		}
+		if err := gw.Close(); err != nil {
+			return 0, errors.Wrap(err, "closing compressor")
+		}
+	} else {
+		body.WriteString(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.writeURL, body)
+	if err != nil {
+		return 0, errors.Wrap(err, "creating request")
+	}
+	req.Header.Set("Authorization", "Token "+e.token)
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if e.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return 0, errors.Wrap(err, "executing request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusOK {
+		return 0, nil
+	}
+
+	respBody, _ := io.ReadAll(resp.Body) //nolint:errcheck // Only used for the error message
+	err = errors.Errorf("http status code %d, body %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusServiceUnavailable:
+		sec, _ := strconv.Atoi(resp.Header.Get("Retry-After")) //nolint:errcheck // Zero is fine on error
+		return time.Duration(sec) * time.Second, err
+
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return 0, err
+
+	default:
+		return 0, permanentError{err}
+	}
+}
+
+func intParam(params url.Values, key string, def int) (int, error) {
+	v := params.Get(key)
+	if v == "" {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 1 {
+		return 0, errors.Errorf("invalid %s %q", key, v)
+	}
+
+	return i, nil
+}
+
+// precisionMultiplierKey translates the v2 API precision into the
+// precision understood by the line protocol encoder
+func precisionMultiplierKey(precision string) string {
+	switch precision {
+	case "ns":
+		return "n"
+	case "us":
+		return "u"
+	case "ms", "s":
+		return precision
+	default:
+		return ""
+	}
+}
+
+type permanentError struct{ error }
+
+func (p permanentError) Unwrap() error { return p.error }
@@ -0,0 +1,357 @@
+package influxdb2
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type (
+	// fakeInflux records the write requests and answers them with the
+	// queued responses (204 when the queue is empty)
+	fakeInflux struct {
+		lock      sync.Mutex
+		requests  []writeRequest
+		responses []fakeResponse
+
+		// block is waited for before answering a request (optional)
+		block chan struct{}
+		// received is signalled when a request was recorded (optional)
+		received chan struct{}
+	}
+
+	fakeResponse struct {
+		status     int
+		retryAfter string
+	}
+
+	writeRequest struct {
+		header http.Header
+		path   string
+		query  map[string]string
+		body   string
+	}
+)
+
+func (f *fakeInflux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = gr
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req := writeRequest{
+		header: r.Header.Clone(),
+		path:   r.URL.Path,
+		query:  map[string]string{},
+		body:   string(data),
+	}
+	for k := range r.URL.Query() {
+		req.query[k] = r.URL.Query().Get(k)
+	}
+
+	f.lock.Lock()
+	f.requests = append(f.requests, req)
+	resp := fakeResponse{status: http.StatusNoContent}
+	if len(f.responses) > 0 {
+		resp, f.responses = f.responses[0], f.responses[1:]
+	}
+	f.lock.Unlock()
+
+	if f.received != nil {
+		f.received <- struct{}{}
+	}
+	if f.block != nil {
+		<-f.block
+	}
+
+	if resp.retryAfter != "" {
+		w.Header().Set("Retry-After", resp.retryAfter)
+	}
+	w.WriteHeader(resp.status)
+}
+
+func (f *fakeInflux) recorded() []writeRequest {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]writeRequest(nil), f.requests...)
+}
+
+func newTestExporter(t *testing.T, f *fakeInflux, params string) *Exporter {
+	t.Helper()
+
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	e, err := New(strings.Replace(srv.URL, "http://", "http://:secret@", 1)+"/myorg/mybucket?"+params, nil)
+	if err != nil {
+		t.Fatalf("creating exporter: %s", err)
+	}
+
+	return e
+}
+
+func recordTestPoint(t *testing.T, e *Exporter, value float64) {
+	t.Helper()
+
+	if err := e.RecordPoint("range", map[string]string{"vehicle": "test"}, map[string]interface{}{"value": value}, time.Unix(1, 500000000)); err != nil {
+		t.Fatalf("recording point: %s", err)
+	}
+}
+
+func bufferLen(e *Exporter) int {
+	e.bufferLock.Lock()
+	defer e.bufferLock.Unlock()
+
+	return len(e.buffer)
+}
+
+func TestWriteRequest(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		params    string
+		gzip      bool
+		precision string
+		expect    string
+	}{
+		{"defaults", "", true, "s", "range,vehicle=test value=1 1\n"},
+		{"plain ms", "gzip=false&precision=ms", false, "ms", "range,vehicle=test value=1 1500\n"},
+		{"ns", "precision=ns", true, "ns", "range,vehicle=test value=1 1500000000\n"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			f := &fakeInflux{}
+			e := newTestExporter(t, f, tc.params)
+			recordTestPoint(t, e, 1)
+
+			if err := e.Flush(context.Background()); err != nil {
+				t.Fatalf("flushing: %s", err)
+			}
+
+			reqs := f.recorded()
+			if len(reqs) != 1 {
+				t.Fatalf("expected one request, got %d", len(reqs))
+			}
+			req := reqs[0]
+
+			if req.path != "/api/v2/write" || req.query["org"] != "myorg" || req.query["bucket"] != "mybucket" {
+				t.Errorf("unexpected target %s %v", req.path, req.query)
+			}
+
+			if p := req.query["precision"]; p != tc.precision {
+				t.Errorf("expected precision %q, got %q", tc.precision, p)
+			}
+
+			if a := req.header.Get("Authorization"); a != "Token secret" {
+				t.Errorf("unexpected authorization header %q", a)
+			}
+
+			if gz := req.header.Get("Content-Encoding") == "gzip"; gz != tc.gzip {
+				t.Errorf("expected gzip = %v, got %v", tc.gzip, gz)
+			}
+
+			if req.body != tc.expect {
+				t.Errorf("expected body %q, got %q", tc.expect, req.body)
+			}
+		})
+	}
+}
+
+func TestBufferDropsOldestPoints(t *testing.T) {
+	f := &fakeInflux{}
+	e := newTestExporter(t, f, "gzip=false&buffer-size=2")
+
+	for i := 1; i <= 3; i++ {
+		recordTestPoint(t, e, float64(i))
+	}
+
+	h := e.Health()[0]
+	if h.Healthy {
+		t.Error("expected overflow to be reported as unhealthy")
+	}
+
+	// Further drops in the same episode are not recorded again
+	recordTestPoint(t, e, 4) //nolint:gomnd // Still overflowing
+	if again := e.Health()[0]; !again.LastErrorAt.Equal(h.LastErrorAt) {
+		t.Errorf("expected overflow to be recorded once per episode, got another error at %s", again.LastErrorAt)
+	}
+
+	if err := e.Flush(context.Background()); err != nil {
+		t.Fatalf("flushing: %s", err)
+	}
+
+	reqs := f.recorded()
+	if expect := "range,vehicle=test value=3 1\nrange,vehicle=test value=4 1\n"; len(reqs) != 1 || reqs[0].body != expect {
+		t.Errorf("expected only the newest points to be written, got %+v", reqs)
+	}
+
+	for i := 5; i <= 7; i++ {
+		recordTestPoint(t, e, float64(i))
+	}
+	if again := e.Health()[0]; !again.LastErrorAt.After(h.LastErrorAt) {
+		t.Error("expected a new episode after the write to be recorded as error")
+	}
+}
+
+func TestWriteSplitsBatches(t *testing.T) {
+	f := &fakeInflux{}
+	e := newTestExporter(t, f, "gzip=false&batch-size=2")
+
+	for i := 1; i <= 5; i++ {
+		recordTestPoint(t, e, float64(i))
+	}
+
+	if err := e.Flush(context.Background()); err != nil {
+		t.Fatalf("flushing: %s", err)
+	}
+
+	if reqs := f.recorded(); len(reqs) != 3 {
+		t.Errorf("expected 3 batches, got %d", len(reqs))
+	}
+
+	if n := bufferLen(e); n != 0 {
+		t.Errorf("expected empty buffer, %d points left", n)
+	}
+}
+
+func TestWriteRetriesTemporaryErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		response fakeResponse
+		minDelay time.Duration
+	}{
+		{"rate limited", fakeResponse{status: http.StatusTooManyRequests, retryAfter: "120"}, 2 * time.Minute},
+		{"unavailable", fakeResponse{status: http.StatusServiceUnavailable, retryAfter: "60"}, time.Minute},
+		{"unavailable without retry-after", fakeResponse{status: http.StatusServiceUnavailable}, retryBaseDelay},
+		{"server error", fakeResponse{status: http.StatusInternalServerError}, retryBaseDelay},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			f := &fakeInflux{responses: []fakeResponse{tc.response}}
+			e := newTestExporter(t, f, "")
+			recordTestPoint(t, e, 1)
+
+			start := time.Now()
+			if err := e.Flush(context.Background()); err == nil {
+				t.Fatal("expected error")
+			}
+
+			if n := bufferLen(e); n != 1 {
+				t.Errorf("expected point to be kept for retry, %d points in buffer", n)
+			}
+
+			if delay := e.retryAfter.Sub(start); delay < tc.minDelay || delay > tc.minDelay+time.Second {
+				t.Errorf("expected retry in %s, got %s", tc.minDelay, delay)
+			}
+
+			if h := e.Health()[0]; h.Healthy {
+				t.Error("expected failed write to be reported as unhealthy")
+			}
+
+			// Next write succeeds
+			if err := e.Flush(context.Background()); err != nil {
+				t.Fatalf("flushing: %s", err)
+			}
+
+			if n := bufferLen(e); n != 0 || len(f.recorded()) != 2 {
+				t.Errorf("expected retried point to be written, %d points in buffer", n)
+			}
+
+			if e.failures != 0 {
+				t.Errorf("expected failures to be reset, got %d", e.failures)
+			}
+		})
+	}
+}
+
+func TestWriteBacksOffExponentially(t *testing.T) {
+	f := &fakeInflux{responses: []fakeResponse{
+		{status: http.StatusBadGateway},
+		{status: http.StatusBadGateway},
+		{status: http.StatusBadGateway},
+	}}
+	e := newTestExporter(t, f, "")
+	recordTestPoint(t, e, 1)
+
+	for i, expect := range []time.Duration{retryBaseDelay, 2 * retryBaseDelay, 4 * retryBaseDelay} {
+		start := time.Now()
+		if err := e.Flush(context.Background()); err == nil {
+			t.Fatalf("write %d: expected error", i)
+		}
+
+		if delay := e.retryAfter.Sub(start); delay < expect || delay > expect+time.Second {
+			t.Errorf("write %d: expected retry in %s, got %s", i, expect, delay)
+		}
+	}
+}
+
+func TestWriteDropsRejectedPoints(t *testing.T) {
+	f := &fakeInflux{responses: []fakeResponse{{status: http.StatusBadRequest}}}
+	e := newTestExporter(t, f, "")
+	recordTestPoint(t, e, 1)
+
+	if err := e.Flush(context.Background()); err != nil {
+		t.Fatalf("expected rejected points not to fail the flush, got %s", err)
+	}
+
+	if n := bufferLen(e); n != 0 {
+		t.Errorf("expected rejected point to be dropped, %d points in buffer", n)
+	}
+
+	if !e.retryAfter.IsZero() {
+		t.Errorf("expected no retry to be scheduled, got %s", e.retryAfter)
+	}
+
+	if h := e.Health()[0]; h.Healthy {
+		t.Error("expected rejected write to be reported as unhealthy")
+	}
+}
+
+func TestWriteKeepsPointsAddedDuringWrite(t *testing.T) {
+	f := &fakeInflux{
+		block:    make(chan struct{}),
+		received: make(chan struct{}, 2),
+	}
+	e := newTestExporter(t, f, "gzip=false&buffer-size=2")
+	recordTestPoint(t, e, 1)
+	recordTestPoint(t, e, 2)
+
+	errs := make(chan error, 1)
+	go func() { errs <- e.Flush(context.Background()) }()
+
+	// While the first batch is written the buffer overflows and drops
+	// the first point of that batch
+	<-f.received
+	recordTestPoint(t, e, 3)
+	close(f.block)
+
+	if err := <-errs; err != nil {
+		t.Fatalf("flushing: %s", err)
+	}
+
+	reqs := f.recorded()
+	if len(reqs) != 2 {
+		t.Fatalf("expected 2 writes, got %d", len(reqs))
+	}
+
+	if expect := "range,vehicle=test value=3 1\n"; reqs[1].body != expect {
+		t.Errorf("expected second write to contain only the new point, got %q", reqs[1].body)
+	}
+}
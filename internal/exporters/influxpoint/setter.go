@@ -0,0 +1,125 @@
+// Package influxpoint maps the API containers to the points written by
+// the InfluxDB exporters
+package influxpoint
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/exporters"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/vehicle"
+)
+
+const (
+	labelDoor   = "door"
+	labelLight  = "light"
+	labelWindow = "window"
+
+	subsystemElectricStatus = `electric_status`
+	subsystemFuelStatus     = "fuel_status"
+	subsystemLockStatus     = "lock_status"
+	subsystemPayAsYouDrive  = "pay_as_you_drive"
+	subsystemVehicleStatus  = "vehicle_status"
+)
+
+type (
+	// RecordPointFunc adds a single point to the exporter
+	RecordPointFunc func(name string, tags map[string]string, fields map[string]any, updatedAt time.Time) error
+
+	// Setter implements the setters of exporters.Exporter by passing
+	// every valid value as point to the RecordPointFunc. It is meant to
+	// be embedded into the exporter.
+	Setter struct {
+		record   RecordPointFunc
+		vehicles *vehicle.Registry
+	}
+)
+
+var _ exporters.Exporter = Setter{}
+
+// NewSetter creates a Setter tagging the points using the given
+// registry (might be nil)
+func NewSetter(vehicles *vehicle.Registry, record RecordPointFunc) Setter {
+	return Setter{record: record, vehicles: vehicles}
+}
+
+func (s Setter) SetElectricStatus(vehicleID string, es mercedes.ElectricStatus) {
+	s.submitValue(es.ElectricRange, mn(subsystemElectricStatus, "electric_range"), vehicleID)
+	s.submitValue(es.StateOfCharge, mn(subsystemElectricStatus, "state_of_charge"), vehicleID)
+}
+
+func (s Setter) SetFuelStatus(vehicleID string, fs mercedes.FuelStatus) {
+	s.submitValue(fs.RangeLiquid, mn(subsystemFuelStatus, "range_liquid"), vehicleID)
+	s.submitValue(fs.TanklevelPercent, mn(subsystemFuelStatus, "tanklevel_percent"), vehicleID)
+}
+
+func (s Setter) SetLockStatus(vehicleID string, ls mercedes.LockStatus) {
+	s.submitValue(ls.DeckLidUnlocked, mn(subsystemLockStatus, "deck_lid_unlocked"), vehicleID)
+	s.submitValue(ls.VehicleStatus, mn(subsystemLockStatus, "vehicle_status"), vehicleID)
+	s.submitValue(ls.GasLidUnlocked, mn(subsystemLockStatus, "gas_lid_unlocked"), vehicleID)
+	s.submitValue(ls.Heading, mn(subsystemLockStatus, "heading"), vehicleID)
+}
+
+func (s Setter) SetPayAsYouGo(vehicleID string, p mercedes.PayAsYouDriveInsurance) {
+	s.submitValue(p.Odometer, mn(subsystemPayAsYouDrive, "odometer"), vehicleID)
+}
+
+func (s Setter) SetVehicleStatus(vehicleID string, vs mercedes.VehicleStatus) {
+	s.submitValue(vs.DeckLidOpen, mn(subsystemVehicleStatus, "deck_lid_open"), vehicleID)
+
+	s.submitValue(vs.DoorFrontLeftOpen, mn(subsystemVehicleStatus, "door_open"), vehicleID, labelDoor, "front_left")
+	s.submitValue(vs.DoorFrontRightOpen, mn(subsystemVehicleStatus, "door_open"), vehicleID, labelDoor, "front_right")
+	s.submitValue(vs.DoorRearLeftOpen, mn(subsystemVehicleStatus, "door_open"), vehicleID, labelDoor, "rear_left")
+	s.submitValue(vs.DoorRearRightOpen, mn(subsystemVehicleStatus, "door_open"), vehicleID, labelDoor, "rear_right")
+
+	s.submitValue(vs.InteriorLightsFrontOn, mn(subsystemVehicleStatus, "interior_light_on"), vehicleID, labelLight, "front")
+	s.submitValue(vs.InteriorLightsRearOn, mn(subsystemVehicleStatus, "interior_light_on"), vehicleID, labelLight, "rear")
+
+	s.submitValue(vs.LightSwitchPosition, mn(subsystemVehicleStatus, "light_switch_position"), vehicleID)
+
+	s.submitValue(vs.ReadingLampFrontLeftOn, mn(subsystemVehicleStatus, "reading_lamp_on"), vehicleID, labelLight, "front_left")
+	s.submitValue(vs.ReadingLampFrontRightOn, mn(subsystemVehicleStatus, "reading_lamp_on"), vehicleID, labelLight, "front_right")
+
+	s.submitValue(vs.RoofTopStatus, mn(subsystemVehicleStatus, "roof_top_status"), vehicleID)
+	s.submitValue(vs.SunRoofStatus, mn(subsystemVehicleStatus, "sun_roof_status"), vehicleID)
+
+	s.submitValue(vs.WindowStatusFrontLeft, mn(subsystemVehicleStatus, "window_status"), vehicleID, labelWindow, "front_left")
+	s.submitValue(vs.WindowStatusFrontRight, mn(subsystemVehicleStatus, "window_status"), vehicleID, labelWindow, "front_right")
+	s.submitValue(vs.WindowStatusRearLeft, mn(subsystemVehicleStatus, "window_status"), vehicleID, labelWindow, "rear_left")
+	s.submitValue(vs.WindowStatusRearRight, mn(subsystemVehicleStatus, "window_status"), vehicleID, labelWindow, "rear_right")
+}
+
+func (s Setter) submitValue(value mercedes.MetricValue, metricName, vehicleID string, tvs ...string) {
+	if !value.IsValid() {
+		return
+	}
+
+	t := tags(tvs...)
+	vl := s.vehicles.Labels(vehicleID)
+	for i := 0; i < len(vl); i += 2 {
+		if vl[i+1] != "" {
+			// Empty tags are not allowed in line protocol
+			t[vl[i]] = vl[i+1]
+		}
+	}
+
+	v := map[string]any{"value": value.ToFloat()}
+	s.record(metricName, t, v, value.Time()) //nolint:errcheck,gosec // Only fails on invalid points which are not built here
+}
+
+func mn(parts ...string) string {
+	return strings.Join(parts, "_")
+}
+
+// tags converts alternating keys and values into a map, a trailing key
+// without value is ignored
+func tags(kvs ...string) map[string]string {
+	out := make(map[string]string)
+
+	for i := 0; i+1 < len(kvs); i += 2 {
+		out[kvs[i]] = kvs[i+1]
+	}
+
+	return out
+}
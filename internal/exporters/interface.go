@@ -1,8 +1,21 @@
 package exporters
 
-import "github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+import (
+	"context"
+
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+)
 
 type (
+	// Closer is implemented by exporters holding resources (background
+	// routines, connections) which need to be released on shutdown.
+	// Close must write out pending data like Flush does.
+	Closer interface {
+		Close(ctx context.Context) error
+	}
+
 	Exporter interface {
 		SetElectricStatus(vehicleID string, es mercedes.ElectricStatus)
 		SetFuelStatus(vehicleID string, fs mercedes.FuelStatus)
@@ -11,10 +24,97 @@ type (
 		SetVehicleStatus(vehicleID string, vs mercedes.VehicleStatus)
 	}
 
+	// Flusher is implemented by exporters buffering data to write out
+	// all pending data immediately
+	Flusher interface {
+		Flush(ctx context.Context) error
+	}
+
+	// HealthReporter is implemented by exporters able to tell whether
+	// they are working correctly
+	HealthReporter interface {
+		Health() []Health
+	}
+
+	// Starter is implemented by exporters which need to connect or
+	// start background routines before receiving data. The context only
+	// limits the startup, background routines are stopped by Close.
+	Starter interface {
+		Start(ctx context.Context) error
+	}
+
+	// VehiclePruner is implemented by exporters keeping data per
+	// vehicle which should be removed once a vehicle is no longer
+	// fetched
+	VehiclePruner interface {
+		PruneVehicles(keep []string)
+	}
+
 	Set []Exporter
 )
 
-var _ Exporter = Set{}
+var (
+	_ Closer         = Set{}
+	_ Exporter       = Set{}
+	_ Flusher        = Set{}
+	_ HealthReporter = Set{}
+	_ Starter        = Set{}
+	_ VehiclePruner  = Set{}
+)
+
+// Close closes all exporters implementing Closer
+func (s Set) Close(ctx context.Context) error {
+	var errs *multierror.Error
+	for _, e := range s {
+		if c, ok := e.(Closer); ok {
+			errs = multierror.Append(errs, c.Close(ctx))
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// Flush flushes all exporters implementing Flusher
+func (s Set) Flush(ctx context.Context) error {
+	var errs *multierror.Error
+	for _, e := range s {
+		if f, ok := e.(Flusher); ok {
+			errs = multierror.Append(errs, f.Flush(ctx))
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// Health collects the health of all exporters implementing
+// HealthReporter
+func (s Set) Health() []Health {
+	var out []Health
+	for _, e := range s {
+		if h, ok := e.(HealthReporter); ok {
+			out = append(out, h.Health()...)
+		}
+	}
+	return out
+}
+
+// Start starts all exporters implementing Starter
+func (s Set) Start(ctx context.Context) error {
+	var errs *multierror.Error
+	for _, e := range s {
+		if st, ok := e.(Starter); ok {
+			errs = multierror.Append(errs, st.Start(ctx))
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// PruneVehicles prunes all exporters implementing VehiclePruner
+func (s Set) PruneVehicles(keep []string) {
+	for _, e := range s {
+		if p, ok := e.(VehiclePruner); ok {
+			p.PruneVehicles(keep)
+		}
+	}
+}
 
 func (s Set) SetElectricStatus(vehicleID string, es mercedes.ElectricStatus) {
 	for _, e := range s {
@@ -0,0 +1,163 @@
+package mqtt
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	broker "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+const brokerTestTimeout = 5 * time.Second
+
+// startBroker runs an in-process broker on a free local port and
+// returns its address
+func startBroker(t *testing.T) (*broker.Server, string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("finding free port: %s", err)
+	}
+	addr := l.Addr().String()
+	l.Close() //nolint:errcheck,gosec // Only used to find a free port
+
+	logger := zerolog.Nop()
+	srv := broker.New(&broker.Options{Logger: &logger})
+	if err = srv.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatalf("adding auth hook: %s", err)
+	}
+	if err = srv.AddListener(listeners.NewTCP("test", addr, nil)); err != nil {
+		t.Fatalf("adding listener: %s", err)
+	}
+	if err = srv.Serve(); err != nil {
+		t.Fatalf("starting broker: %s", err)
+	}
+	t.Cleanup(func() { srv.Close() }) //nolint:errcheck,gosec // Test cleanup
+
+	return srv, addr
+}
+
+// subscribe connects a second client to the broker and passes all
+// messages to the returned channel
+func subscribe(t *testing.T, addr string) <-chan mqtt.Message {
+	t.Helper()
+
+	msgs := make(chan mqtt.Message, 100) //nolint:gomnd // Enough for the test
+	c := mqtt.NewClient(mqtt.NewClientOptions().AddBroker("tcp://" + addr).SetClientID("subscriber"))
+	if tok := c.Connect(); !tok.WaitTimeout(brokerTestTimeout) || tok.Error() != nil {
+		t.Fatalf("connecting subscriber: %v", tok.Error())
+	}
+	t.Cleanup(func() { c.Disconnect(0) })
+
+	tok := c.Subscribe("#", 2, func(_ mqtt.Client, msg mqtt.Message) { msgs <- msg }) //nolint:gomnd // Highest QoS
+	if !tok.WaitTimeout(brokerTestTimeout) || tok.Error() != nil {
+		t.Fatalf("subscribing: %v", tok.Error())
+	}
+
+	return msgs
+}
+
+// waitFor returns the next message on each of the given topics,
+// messages on other topics are dropped
+func waitFor(t *testing.T, msgs <-chan mqtt.Message, topics ...string) map[string]mqtt.Message {
+	t.Helper()
+
+	var (
+		out     = make(map[string]mqtt.Message)
+		timeout = time.After(brokerTestTimeout)
+	)
+
+	for len(out) < len(topics) {
+		select {
+		case msg := <-msgs:
+			for _, topic := range topics {
+				if _, ok := out[topic]; !ok && msg.Topic() == topic {
+					out[topic] = msg
+				}
+			}
+		case <-timeout:
+			t.Fatalf("expected messages on %v, got %d", topics, len(out))
+		}
+	}
+
+	return out
+}
+
+// waitConnected waits for the exporter to be connected as publishing
+// is skipped before
+func waitConnected(t *testing.T, e *Exporter) {
+	t.Helper()
+
+	for deadline := time.Now().Add(brokerTestTimeout); !e.client.IsConnectionOpen(); {
+		if time.Now().After(deadline) {
+			t.Fatal("exporter did not connect")
+		}
+		time.Sleep(10 * time.Millisecond) //nolint:gomnd // Poll interval
+	}
+}
+
+func TestBrokerRoundTrip(t *testing.T) {
+	srv, addr := startBroker(t)
+
+	e, err := New("tcp://"+addr+"?qos=1&client-id=exporter", nil)
+	if err != nil {
+		t.Fatalf("creating exporter: %s", err)
+	}
+	if err = e.Start(context.Background()); err != nil {
+		t.Fatalf("starting exporter: %s", err)
+	}
+	t.Cleanup(func() { e.Close(context.Background()) }) //nolint:errcheck,gosec // Test cleanup
+
+	waitConnected(t, e)
+	e.SetFuelStatus(testVehicleID, testFuelStatus(500))
+
+	// Subscribing afterwards only yields the retained messages
+	var (
+		msgs   = subscribe(t, addr)
+		expect = map[string]string{
+			"mercedes-byocar/status": payloadOnline,
+			"mercedes-byocar/WDB111111ZZZ22222/fuel_status/range_liquid": "500",
+		}
+		got = waitFor(t, msgs, "mercedes-byocar/status", "mercedes-byocar/WDB111111ZZZ22222/fuel_status/range_liquid")
+	)
+
+	for topic, payload := range expect {
+		msg := got[topic]
+		if string(msg.Payload()) != payload || !msg.Retained() {
+			t.Errorf("expected retained %q on %s, got %q (retained = %v)", payload, topic, msg.Payload(), msg.Retained())
+		}
+		if msg.Qos() != 1 {
+			t.Errorf("expected QoS 1 on %s, got %d", topic, msg.Qos())
+		}
+	}
+
+	// Dropping the connection triggers the last will, the exporter
+	// reconnects and is online again
+	cl, ok := srv.Clients.Get("exporter")
+	if !ok {
+		t.Fatal("exporter not connected to broker")
+	}
+	cl.Stop(errors.New("connection dropped by test"))
+
+	if msg := waitFor(t, msgs, "mercedes-byocar/status")["mercedes-byocar/status"]; string(msg.Payload()) != payloadOffline {
+		t.Errorf("expected last will %q, got %q", payloadOffline, msg.Payload())
+	}
+	if msg := waitFor(t, msgs, "mercedes-byocar/status")["mercedes-byocar/status"]; string(msg.Payload()) != payloadOnline {
+		t.Errorf("expected %q after reconnect, got %q", payloadOnline, msg.Payload())
+	}
+
+	// Discovery is published again after the reconnect
+	const discoveryTopic = "homeassistant/sensor/mercedes_byocar_wdb111111zzz22222/fuel_status_range_liquid/config"
+	waitConnected(t, e)
+	e.SetFuelStatus(testVehicleID, testFuelStatus(490))
+	if msg := waitFor(t, msgs, discoveryTopic)[discoveryTopic]; len(msg.Payload()) == 0 {
+		t.Error("expected discovery config after reconnect")
+	}
+}
@@ -0,0 +1,324 @@
+package mqtt
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/vehicle"
+)
+
+type (
+	// fakeClient records the published messages and keeps the retained
+	// ones like a broker would
+	fakeClient struct {
+		mqtt.Client // Not used by the exporter, panics when called
+
+		lock      sync.Mutex
+		connected bool
+		messages  []fakeMessage
+		retained  map[string]string
+		onConnect mqtt.OnConnectHandler
+	}
+
+	fakeMessage struct {
+		topic    string
+		retained bool
+		payload  string
+	}
+
+	doneToken struct{ err error }
+)
+
+func (f *fakeClient) Connect() mqtt.Token {
+	f.lock.Lock()
+	f.connected = true
+	f.lock.Unlock()
+
+	if f.onConnect != nil {
+		f.onConnect(f)
+	}
+
+	return doneToken{}
+}
+
+// connectionLost simulates a broken connection the client recovers from
+// on the next Connect
+func (f *fakeClient) connectionLost() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.connected = false
+}
+
+func (f *fakeClient) Disconnect(uint) { f.connectionLost() }
+
+func (f *fakeClient) IsConnected() bool { return f.IsConnectionOpen() }
+
+func (f *fakeClient) IsConnectionOpen() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.connected
+}
+
+func (f *fakeClient) Publish(topic string, _ byte, retained bool, payload interface{}) mqtt.Token {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	msg := fakeMessage{topic: topic, retained: retained, payload: payload.(string)}
+	f.messages = append(f.messages, msg)
+
+	switch {
+	case !retained:
+	case msg.payload == "":
+		delete(f.retained, topic)
+	default:
+		f.retained[topic] = msg.payload
+	}
+
+	return doneToken{}
+}
+
+// published returns the messages published since the last call
+func (f *fakeClient) published() []fakeMessage {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	out := f.messages
+	f.messages = nil
+	return out
+}
+
+// retainedTopics returns the sorted topics having a retained message
+func (f *fakeClient) retainedTopics() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var out []string
+	for topic := range f.retained {
+		out = append(out, topic)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (d doneToken) Done() <-chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+func (d doneToken) Error() error                   { return d.err }
+func (d doneToken) Wait() bool                     { return true }
+func (d doneToken) WaitTimeout(time.Duration) bool { return true }
+
+// newConnectedExporter creates an exporter using a fakeClient and
+// connects it
+func newConnectedExporter(t *testing.T, connURL string, vehicles *vehicle.Registry) (*Exporter, *fakeClient) {
+	t.Helper()
+
+	e := newTestExporter(t, connURL, vehicles)
+	c := &fakeClient{retained: make(map[string]string), onConnect: e.onConnect}
+	e.client = c
+
+	if err := e.Start(context.Background()); err != nil {
+		t.Fatalf("starting exporter: %s", err)
+	}
+
+	return e, c
+}
+
+func testFuelStatus(rangeLiquid int64) mercedes.FuelStatus {
+	return mercedes.FuelStatus{RangeLiquid: mercedes.NewTimedInt(rangeLiquid, time.Unix(1000, 0))}
+}
+
+func TestPruneVehiclesClearsRetainedTopics(t *testing.T) {
+	const otherVehicleID = "WDB333333ZZZ44444"
+
+	e, c := newConnectedExporter(t, "tcp://localhost:1883", nil)
+
+	e.SetFuelStatus(testVehicleID, testFuelStatus(500))
+	e.SetFuelStatus(otherVehicleID, testFuelStatus(300))
+
+	e.PruneVehicles([]string{testVehicleID})
+
+	expect := []string{
+		"homeassistant/sensor/mercedes_byocar_wdb111111zzz22222/fuel_status_range_liquid/config",
+		"mercedes-byocar/WDB111111ZZZ22222/fuel_status/range_liquid",
+		"mercedes-byocar/status",
+	}
+	if topics := c.retainedTopics(); !reflect.DeepEqual(topics, expect) {
+		t.Errorf("expected retained topics %v, got %v", expect, topics)
+	}
+
+	// A re-added vehicle is announced again
+	c.published()
+	e.SetFuelStatus(otherVehicleID, testFuelStatus(300))
+
+	if msgs := c.published(); len(msgs) != 2 { //nolint:gomnd // Discovery and state
+		t.Errorf("expected discovery and state to be published, got %+v", msgs)
+	}
+}
+
+func TestPublishStateAndDiscovery(t *testing.T) {
+	for name, tc := range map[string]struct {
+		connURL        string
+		expectRetained bool
+	}{
+		"retained":     {"tcp://localhost:1883", true},
+		"not retained": {"tcp://localhost:1883?retain=false", false},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			e, c := newConnectedExporter(t, tc.connURL, nil)
+			c.published()
+
+			e.SetFuelStatus(testVehicleID, testFuelStatus(500))
+			// Discovery is only published once per connection
+			e.SetFuelStatus(testVehicleID, testFuelStatus(490))
+
+			msgs := c.published()
+			if len(msgs) != 3 { //nolint:gomnd // Discovery and two states
+				t.Fatalf("expected 3 messages, got %+v", msgs)
+			}
+
+			if msgs[0].topic != e.discoveryTopic(testVehicleID, fieldRangeLiquid) || !msgs[0].retained {
+				t.Errorf("expected retained discovery config first, got %+v", msgs[0])
+			}
+
+			for i, expect := range []string{"500", "490"} {
+				msg := msgs[i+1]
+				if msg.topic != "mercedes-byocar/WDB111111ZZZ22222/fuel_status/range_liquid" || msg.payload != expect {
+					t.Errorf("unexpected state message %+v", msg)
+				}
+				if msg.retained != tc.expectRetained {
+					t.Errorf("expected retained = %v, got %v", tc.expectRetained, msg.retained)
+				}
+			}
+
+			if h := e.Health()[0]; !h.Healthy {
+				t.Errorf("expected exporter to be healthy, got %v", h.LastError)
+			}
+		})
+	}
+}
+
+func TestPublishEnumStates(t *testing.T) {
+	e, c := newConnectedExporter(t, "tcp://localhost:1883?discovery-prefix=", nil)
+	c.published()
+
+	def := []string{"unlocked", "open and locked", "closed and locked"}
+	for _, idx := range []int64{2, 7} {
+		e.SetVehicleStatus(testVehicleID, mercedes.VehicleStatus{RoofTopStatus: mercedes.NewTimedEnum(idx, def, time.Unix(1000, 0))})
+	}
+
+	msgs := c.published()
+	if len(msgs) != 2 { //nolint:gomnd // One state per value
+		t.Fatalf("expected 2 messages, got %+v", msgs)
+	}
+
+	// Values unknown to the definition must match the discovery options
+	for i, expect := range []string{"closed and locked", "unknown"} {
+		if msgs[i].payload != expect {
+			t.Errorf("expected state %q, got %q", expect, msgs[i].payload)
+		}
+	}
+}
+
+func TestPublishLockState(t *testing.T) {
+	e, c := newConnectedExporter(t, "tcp://localhost:1883?discovery-prefix=", nil)
+	c.published()
+
+	def := []string{"unlocked", "internal locked", "external locked", "selective unlocked"}
+	for _, idx := range []int64{2, 0} {
+		e.SetLockStatus(testVehicleID, mercedes.LockStatus{VehicleStatus: mercedes.NewTimedEnum(idx, def, time.Unix(1000, 0))})
+	}
+
+	var locks []string
+	for _, msg := range c.published() {
+		if msg.topic == "mercedes-byocar/WDB111111ZZZ22222/lock_status/vehicle_status/lock" {
+			locks = append(locks, msg.payload)
+		}
+	}
+
+	if expect := []string{"LOCKED", "UNLOCKED"}; !reflect.DeepEqual(locks, expect) {
+		t.Errorf("expected lock states %v, got %v", expect, locks)
+	}
+}
+
+func TestAvailability(t *testing.T) {
+	e := newTestExporter(t, "tcp://localhost:1883/cars", nil)
+
+	// The last will is registered with the broker on connect
+	opts := e.client.OptionsReader()
+	if !opts.WillEnabled() || opts.WillTopic() != "cars/status" || string(opts.WillPayload()) != "offline" || !opts.WillRetained() {
+		t.Errorf("unexpected last will %q / %q / retained %v", opts.WillTopic(), opts.WillPayload(), opts.WillRetained())
+	}
+
+	c := &fakeClient{retained: make(map[string]string), onConnect: e.onConnect}
+	e.client = c
+
+	if err := e.Start(context.Background()); err != nil {
+		t.Fatalf("starting exporter: %s", err)
+	}
+
+	if p := c.retained["cars/status"]; p != "online" {
+		t.Errorf("expected online after connect, got %q", p)
+	}
+
+	if err := e.Close(context.Background()); err != nil {
+		t.Fatalf("closing exporter: %s", err)
+	}
+
+	if p := c.retained["cars/status"]; p != "offline" {
+		t.Errorf("expected offline after close, got %q", p)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	e, c := newConnectedExporter(t, "tcp://localhost:1883", nil)
+
+	e.SetFuelStatus(testVehicleID, testFuelStatus(500))
+	c.connectionLost()
+	c.published()
+
+	// Values are dropped while disconnected instead of stalling
+	e.SetFuelStatus(testVehicleID, testFuelStatus(490))
+
+	if msgs := c.published(); len(msgs) != 0 {
+		t.Errorf("expected nothing to be published while disconnected, got %+v", msgs)
+	}
+
+	if h := e.Health()[0]; h.Healthy {
+		t.Error("expected disconnected exporter to be unhealthy")
+	}
+
+	// The client reconnects automatically which marks the exporter
+	// online again and re-announces the discovery configs
+	c.Connect()
+	e.SetFuelStatus(testVehicleID, testFuelStatus(480))
+
+	msgs := c.published()
+	if len(msgs) != 3 { //nolint:gomnd // Availability, discovery and state
+		t.Fatalf("expected 3 messages, got %+v", msgs)
+	}
+
+	if msgs[0].topic != "mercedes-byocar/status" || msgs[0].payload != "online" {
+		t.Errorf("expected availability first, got %+v", msgs[0])
+	}
+
+	if msgs[1].topic != e.discoveryTopic(testVehicleID, fieldRangeLiquid) {
+		t.Errorf("expected discovery to be published again, got %+v", msgs[1])
+	}
+
+	if h := e.Health()[0]; !h.Healthy {
+		t.Errorf("expected exporter to recover, got %v", h.LastError)
+	}
+}
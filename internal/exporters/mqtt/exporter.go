@@ -0,0 +1,371 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pkg/errors"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/exporters"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/vehicle"
+)
+
+const (
+	defaultClientID        = "mercedes-byocar-exporter"
+	defaultDiscoveryPrefix = "homeassistant"
+	defaultTopicPrefix     = "mercedes-byocar"
+
+	mqttDisconnectQuiesce = 250 * time.Millisecond
+	mqttTimeout           = 5 * time.Second
+
+	// payloadEnumUnknown is published for enum values not known to the
+	// definition as Home Assistant rejects states not in the options
+	payloadEnumUnknown = "unknown"
+	payloadOffline     = "offline"
+	payloadOnline      = "online"
+)
+
+// invalidTopicChars matches everything not to be used in topics and
+// Home Assistant identifiers
+var invalidTopicChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+type (
+	// Exporter publishes every field to a per-vehicle topic and
+	// announces the fields through Home Assistant MQTT discovery
+	Exporter struct {
+		client          mqtt.Client
+		discoveryPrefix string
+		topicPrefix     string
+		qos             byte
+		retain          bool
+		status          *exporters.Status
+		vehicles        *vehicle.Registry
+
+		announcedLock sync.Mutex
+		announced     map[string]bool
+
+		// retained contains the retained topics published per vehicle
+		// to clear them when the vehicle is removed
+		retainedLock sync.Mutex
+		retained     map[string]map[string]bool
+	}
+)
+
+var (
+	_ exporters.Closer         = (*Exporter)(nil)
+	_ exporters.Exporter       = (*Exporter)(nil)
+	_ exporters.HealthReporter = (*Exporter)(nil)
+	_ exporters.Starter        = (*Exporter)(nil)
+	_ exporters.VehiclePruner  = (*Exporter)(nil)
+)
+
+// New creates an exporter for the broker given as URL:
+// (tcp|ssl|ws|wss|mqtt|mqtts)://[user:pass@]host[:port][/topic-prefix]
+// Supported query parameters: client-id, discovery-prefix (empty to
+// disable discovery), qos, retain
+// Topics and devices are named using the given registry (might be nil).
+func New(connURL string, vehicles *vehicle.Registry) (*Exporter, error) {
+	out := &Exporter{
+		announced: make(map[string]bool),
+		retained:  make(map[string]map[string]bool),
+		status:    exporters.NewStatus("mqtt"),
+		vehicles:  vehicles,
+	}
+	return out, out.initialize(connURL)
+}
+
+// Close marks the exporter offline and disconnects from the broker
+func (e *Exporter) Close(ctx context.Context) error {
+	if e.client.IsConnectionOpen() {
+		// The last will is only sent on unexpected disconnects
+		tok := e.client.Publish(e.availabilityTopic(), e.qos, true, payloadOffline)
+		select {
+		case <-tok.Done():
+		case <-ctx.Done():
+		}
+	}
+
+	e.client.Disconnect(uint(mqttDisconnectQuiesce / time.Millisecond))
+	return nil
+}
+
+// Health reports unhealthy while the broker is not connected or the
+// last publish failed
+func (e *Exporter) Health() []exporters.Health {
+	h := e.status.Health()
+	if !e.client.IsConnectionOpen() {
+		h.Healthy = false
+		h.LastError = errors.New("not connected to broker")
+	}
+	return []exporters.Health{h}
+}
+
+// PruneVehicles clears the retained state and discovery topics of all
+// vehicles not in keep so they vanish from the broker and Home
+// Assistant
+func (e *Exporter) PruneVehicles(keep []string) {
+	keepSet := make(map[string]bool, len(keep))
+	for _, id := range keep {
+		keepSet[id] = true
+	}
+
+	var topics []string
+
+	e.retainedLock.Lock()
+	for id, published := range e.retained {
+		if keepSet[id] {
+			continue
+		}
+		for topic := range published {
+			topics = append(topics, topic)
+		}
+		delete(e.retained, id)
+	}
+	e.retainedLock.Unlock()
+
+	e.announcedLock.Lock()
+	for key := range e.announced {
+		if id, _, _ := strings.Cut(key, "/"); !keepSet[id] {
+			delete(e.announced, key)
+		}
+	}
+	e.announcedLock.Unlock()
+
+	sort.Strings(topics)
+	for _, topic := range topics {
+		// An empty retained message deletes the retained one and removes
+		// the entity when sent to a discovery topic
+		e.publish(topic, "", true)
+	}
+}
+
+// Start connects to the broker. With ConnectRetry enabled only the
+// first attempt is awaited, further attempts are done in background.
+func (e *Exporter) Start(ctx context.Context) error {
+	tok := e.client.Connect()
+
+	select {
+	case <-tok.Done():
+		return errors.Wrap(tok.Error(), "connecting to broker")
+	case <-ctx.Done():
+	case <-time.After(mqttTimeout):
+	}
+
+	e.status.RecordError(errors.New("broker not reachable, retrying in background"))
+	return nil
+}
+
+func (e *Exporter) initialize(connURL string) error {
+	connInfo, err := url.Parse(connURL)
+	if err != nil {
+		return errors.Wrap(err, "parsing connection URL")
+	}
+
+	params := connInfo.Query()
+
+	e.topicPrefix = strings.Trim(connInfo.Path, "/")
+	if e.topicPrefix == "" {
+		e.topicPrefix = defaultTopicPrefix
+	}
+
+	e.discoveryPrefix = defaultDiscoveryPrefix
+	if params.Has("discovery-prefix") {
+		e.discoveryPrefix = strings.Trim(params.Get("discovery-prefix"), "/")
+	}
+
+	if v := params.Get("qos"); v != "" {
+		qos, err := strconv.ParseUint(v, 10, 8)
+		if err != nil || qos > 2 { //nolint:gomnd // Highest QoS level
+			return errors.Errorf("invalid qos %q", v)
+		}
+		e.qos = byte(qos)
+	}
+
+	e.retain = true
+	if v := params.Get("retain"); v != "" {
+		if e.retain, err = strconv.ParseBool(v); err != nil {
+			return errors.Wrap(err, "parsing retain")
+		}
+	}
+
+	scheme := connInfo.Scheme
+	switch scheme {
+	case "mqtt":
+		scheme = "tcp"
+	case "mqtts":
+		scheme = "ssl"
+	}
+
+	clientID := params.Get("client-id")
+	if clientID == "" {
+		clientID = defaultClientID
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker((&url.URL{Scheme: scheme, Host: connInfo.Host}).String()).
+		SetAutoReconnect(true).
+		SetClientID(clientID).
+		SetConnectRetry(true).
+		SetConnectTimeout(mqttTimeout).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			e.status.RecordError(errors.Wrap(err, "connection to broker lost"))
+		}).
+		SetOnConnectHandler(e.onConnect).
+		SetWill(e.availabilityTopic(), payloadOffline, e.qos, true)
+
+	if connInfo.User != nil {
+		opts.SetUsername(connInfo.User.Username())
+		if pass, ok := connInfo.User.Password(); ok {
+			opts.SetPassword(pass)
+		}
+	}
+
+	e.client = mqtt.NewClient(opts)
+
+	return nil
+}
+
+func (e *Exporter) availabilityTopic() string {
+	return strings.Join([]string{e.topicPrefix, "status"}, "/")
+}
+
+// announce publishes the Home Assistant discovery config for the field
+// once per connection
+func (e *Exporter) announce(vehicleID string, f field, value mercedes.MetricValue) {
+	if e.discoveryPrefix == "" {
+		return
+	}
+
+	key := strings.Join([]string{vehicleID, f.subsystem, f.object}, "/")
+
+	e.announcedLock.Lock()
+	if e.announced[key] {
+		e.announcedLock.Unlock()
+		return
+	}
+	e.announced[key] = true
+	e.announcedLock.Unlock()
+
+	payload, err := json.Marshal(f.discoveryConfig(e, vehicleID, value))
+	if err != nil {
+		e.status.RecordError(errors.Wrap(err, "encoding discovery config"))
+		return
+	}
+
+	e.publishRetained(vehicleID, e.discoveryTopic(vehicleID, f), string(payload))
+}
+
+// deviceName returns the name of the vehicle device in Home Assistant
+func (e *Exporter) deviceName(vehicleID string) string {
+	if name := e.vehicles.Metadata(vehicleID).Name; name != "" {
+		return name
+	}
+
+	return "Mercedes " + e.topicID(vehicleID)
+}
+
+func (e *Exporter) discoveryTopic(vehicleID string, f field) string {
+	return strings.Join([]string{e.discoveryPrefix, f.component, e.nodeID(vehicleID), f.subsystem + "_" + f.object, "config"}, "/")
+}
+
+// nodeID returns the identifier of the vehicle device in Home Assistant
+func (e *Exporter) nodeID(vehicleID string) string {
+	return strings.ToLower("mercedes_byocar_" + e.topicID(vehicleID))
+}
+
+func (e *Exporter) onConnect(c mqtt.Client) {
+	// Re-announce everything as the broker might have lost retained messages
+	e.announcedLock.Lock()
+	e.announced = make(map[string]bool)
+	e.announcedLock.Unlock()
+
+	e.publish(e.availabilityTopic(), payloadOnline, true)
+}
+
+func (e *Exporter) publish(topic, payload string, retain bool) {
+	if !e.client.IsConnectionOpen() {
+		// Do not stall the fetcher while the broker is unavailable, the
+		// value will be published again with the next submission
+		e.status.RecordError(errors.Errorf("publishing to %s: not connected", topic))
+		return
+	}
+
+	tok := e.client.Publish(topic, e.qos, retain, payload)
+	if !tok.WaitTimeout(mqttTimeout) {
+		e.status.RecordError(errors.Errorf("publishing to %s: timeout", topic))
+		return
+	}
+
+	if err := tok.Error(); err != nil {
+		e.status.RecordError(errors.Wrapf(err, "publishing to %s", topic))
+		return
+	}
+
+	e.status.RecordSuccess()
+}
+
+// publishRetained publishes a retained message and remembers the topic
+// to clear it when the vehicle is removed
+func (e *Exporter) publishRetained(vehicleID, topic, payload string) {
+	e.retainedLock.Lock()
+	if e.retained[vehicleID] == nil {
+		e.retained[vehicleID] = make(map[string]bool)
+	}
+	e.retained[vehicleID][topic] = true
+	e.retainedLock.Unlock()
+
+	e.publish(topic, payload, true)
+}
+
+func (e *Exporter) stateTopic(vehicleID string, f field) string {
+	return strings.Join([]string{e.topicPrefix, e.topicID(vehicleID), f.subsystem, f.object}, "/")
+}
+
+// topicID returns the vehicle-id as exposed by the registry. With the
+// VIN hidden the (unique) vehicle name is used instead.
+func (e *Exporter) topicID(vehicleID string) string {
+	return strings.Trim(invalidTopicChars.ReplaceAllString(e.vehicles.PublicID(vehicleID), "_"), "_")
+}
+
+func (e *Exporter) submitValue(vehicleID string, value mercedes.MetricValue, f field) {
+	if !value.IsValid() {
+		return
+	}
+
+	e.announce(vehicleID, f, value)
+
+	publish := func(topic, payload string) { e.publish(topic, payload, false) }
+	if e.retain {
+		publish = func(topic, payload string) { e.publishRetained(vehicleID, topic, payload) }
+	}
+
+	publish(e.stateTopic(vehicleID, f), formatValue(value))
+	if f.lockState {
+		publish(e.stateTopic(vehicleID, f)+"/lock", lockState(value))
+	}
+}
+
+func formatValue(value mercedes.MetricValue) string {
+	switch v := value.(type) {
+	case mercedes.TimedBool:
+		return strconv.FormatBool(v.Bool())
+
+	case mercedes.TimedEnum:
+		if v.Idx() < 0 || v.Idx() >= int64(len(v.Values())) {
+			return payloadEnumUnknown
+		}
+		return v.Value()
+
+	default:
+		return strconv.FormatFloat(value.ToFloat(), 'f', -1, 64)
+	}
+}
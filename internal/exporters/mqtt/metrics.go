@@ -0,0 +1,131 @@
+package mqtt
+
+import (
+	"strings"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+)
+
+const (
+	componentBinarySensor = "binary_sensor"
+	componentSensor       = "sensor"
+
+	lockStateLocked   = "LOCKED"
+	lockStateUnlocked = "UNLOCKED"
+
+	subsystemElectricStatus = `electric_status`
+	subsystemFuelStatus     = "fuel_status"
+	subsystemLockStatus     = "lock_status"
+	subsystemPayAsYouDrive  = "pay_as_you_drive"
+	subsystemVehicleStatus  = "vehicle_status"
+
+	unitDegrees    = "°"
+	unitKilometers = "km"
+	unitPercent    = "%"
+)
+
+type (
+	field struct {
+		subsystem, object, name string
+		component               string
+
+		deviceClass, icon, stateClass, unit string
+
+		// lockState publishes the lock status mapped to LOCKED / UNLOCKED
+		// in a sub-topic which the entity reads its state from
+		lockState bool
+	}
+)
+
+var (
+	fieldElectricRange = field{subsystem: subsystemElectricStatus, object: "electric_range", name: "Electric range", component: componentSensor, deviceClass: "distance", stateClass: "measurement", unit: unitKilometers}
+	fieldStateOfCharge = field{subsystem: subsystemElectricStatus, object: "state_of_charge", name: "State of charge", component: componentSensor, deviceClass: "battery", stateClass: "measurement", unit: unitPercent}
+
+	fieldRangeLiquid      = field{subsystem: subsystemFuelStatus, object: "range_liquid", name: "Fuel range", component: componentSensor, deviceClass: "distance", stateClass: "measurement", unit: unitKilometers}
+	fieldTanklevelPercent = field{subsystem: subsystemFuelStatus, object: "tanklevel_percent", name: "Fuel level", component: componentSensor, icon: "mdi:gas-station", stateClass: "measurement", unit: unitPercent}
+
+	fieldDeckLidUnlocked = field{subsystem: subsystemLockStatus, object: "deck_lid_unlocked", name: "Deck lid lock", component: componentBinarySensor, deviceClass: "lock"}
+	fieldVehicleLock     = field{subsystem: subsystemLockStatus, object: "vehicle_status", name: "Lock", component: componentBinarySensor, deviceClass: "lock", lockState: true}
+	fieldGasLidUnlocked  = field{subsystem: subsystemLockStatus, object: "gas_lid_unlocked", name: "Gas lid lock", component: componentBinarySensor, deviceClass: "lock"}
+	fieldHeading         = field{subsystem: subsystemLockStatus, object: "heading", name: "Heading", component: componentSensor, icon: "mdi:compass", unit: unitDegrees}
+
+	fieldOdometer = field{subsystem: subsystemPayAsYouDrive, object: "odometer", name: "Odometer", component: componentSensor, deviceClass: "distance", stateClass: "total_increasing", unit: unitKilometers}
+
+	fieldDeckLidOpen             = field{subsystem: subsystemVehicleStatus, object: "deck_lid_open", name: "Deck lid", component: componentBinarySensor, deviceClass: "opening"}
+	fieldDoorFrontLeftOpen       = field{subsystem: subsystemVehicleStatus, object: "door_open_front_left", name: "Door front left", component: componentBinarySensor, deviceClass: "door"}
+	fieldDoorFrontRightOpen      = field{subsystem: subsystemVehicleStatus, object: "door_open_front_right", name: "Door front right", component: componentBinarySensor, deviceClass: "door"}
+	fieldDoorRearLeftOpen        = field{subsystem: subsystemVehicleStatus, object: "door_open_rear_left", name: "Door rear left", component: componentBinarySensor, deviceClass: "door"}
+	fieldDoorRearRightOpen       = field{subsystem: subsystemVehicleStatus, object: "door_open_rear_right", name: "Door rear right", component: componentBinarySensor, deviceClass: "door"}
+	fieldInteriorLightFrontOn    = field{subsystem: subsystemVehicleStatus, object: "interior_light_on_front", name: "Interior light front", component: componentBinarySensor, deviceClass: "light"}
+	fieldInteriorLightRearOn     = field{subsystem: subsystemVehicleStatus, object: "interior_light_on_rear", name: "Interior light rear", component: componentBinarySensor, deviceClass: "light"}
+	fieldLightSwitchPosition     = field{subsystem: subsystemVehicleStatus, object: "light_switch_position", name: "Light switch", component: componentSensor, icon: "mdi:car-light-dimmed"}
+	fieldReadingLampFrontLeftOn  = field{subsystem: subsystemVehicleStatus, object: "reading_lamp_on_front_left", name: "Reading lamp front left", component: componentBinarySensor, deviceClass: "light"}
+	fieldReadingLampFrontRightOn = field{subsystem: subsystemVehicleStatus, object: "reading_lamp_on_front_right", name: "Reading lamp front right", component: componentBinarySensor, deviceClass: "light"}
+	fieldRoofTopStatus           = field{subsystem: subsystemVehicleStatus, object: "roof_top_status", name: "Convertible top", component: componentSensor, icon: "mdi:car-convertible"}
+	fieldSunRoofStatus           = field{subsystem: subsystemVehicleStatus, object: "sun_roof_status", name: "Sunroof", component: componentSensor, icon: "mdi:car-select"}
+	fieldWindowFrontLeft         = field{subsystem: subsystemVehicleStatus, object: "window_status_front_left", name: "Window front left", component: componentSensor, icon: "mdi:car-door"}
+	fieldWindowFrontRight        = field{subsystem: subsystemVehicleStatus, object: "window_status_front_right", name: "Window front right", component: componentSensor, icon: "mdi:car-door"}
+	fieldWindowRearLeft          = field{subsystem: subsystemVehicleStatus, object: "window_status_rear_left", name: "Window rear left", component: componentSensor, icon: "mdi:car-door"}
+	fieldWindowRearRight         = field{subsystem: subsystemVehicleStatus, object: "window_status_rear_right", name: "Window rear right", component: componentSensor, icon: "mdi:car-door"}
+)
+
+// discoveryConfig builds the Home Assistant MQTT discovery payload
+// describing the field as entity of the vehicle device
+func (f field) discoveryConfig(e *Exporter, vehicleID string, value mercedes.MetricValue) map[string]any {
+	cfg := map[string]any{
+		"availability_topic": e.availabilityTopic(),
+		"device": map[string]any{
+			"identifiers":  []string{e.nodeID(vehicleID)},
+			"manufacturer": "Mercedes-Benz",
+			"name":         e.deviceName(vehicleID),
+		},
+		"name":        f.name,
+		"state_topic": e.stateTopic(vehicleID, f),
+		"unique_id":   strings.Join([]string{e.nodeID(vehicleID), f.subsystem, f.object}, "_"),
+	}
+
+	for k, v := range map[string]string{
+		"device_class":        f.deviceClass,
+		"icon":                f.icon,
+		"state_class":         f.stateClass,
+		"unit_of_measurement": f.unit,
+	} {
+		if v != "" {
+			cfg[k] = v
+		}
+	}
+
+	switch f.component {
+	case componentBinarySensor:
+		cfg["payload_on"] = "true"
+		cfg["payload_off"] = "false"
+
+		if f.lockState {
+			// The car cannot be controlled through the API so the lock is
+			// a read-only sensor, "on" means unlocked for the lock class
+			cfg["state_topic"] = e.stateTopic(vehicleID, f) + "/lock"
+			cfg["payload_on"] = lockStateUnlocked
+			cfg["payload_off"] = lockStateLocked
+		}
+
+	case componentSensor:
+		if enum, ok := value.(mercedes.TimedEnum); ok && len(enum.Values()) > 0 {
+			cfg["device_class"] = "enum"
+			cfg["options"] = append(append([]string{}, enum.Values()...), payloadEnumUnknown)
+		}
+	}
+
+	return cfg
+}
+
+// lockState maps the vehicle lock status (unlocked, internal locked,
+// external locked, selective unlocked) to the payloads of the lock
+// sensor
+func lockState(value mercedes.MetricValue) string {
+	switch value.ToFloat() {
+	case 1, 2: //nolint:gomnd // internal / external locked
+		return lockStateLocked
+	default:
+		return lockStateUnlocked
+	}
+}
@@ -0,0 +1,49 @@
+package mqtt
+
+import "github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+
+func (e *Exporter) SetElectricStatus(vehicleID string, es mercedes.ElectricStatus) {
+	e.submitValue(vehicleID, es.ElectricRange, fieldElectricRange)
+	e.submitValue(vehicleID, es.StateOfCharge, fieldStateOfCharge)
+}
+
+func (e *Exporter) SetFuelStatus(vehicleID string, fs mercedes.FuelStatus) {
+	e.submitValue(vehicleID, fs.RangeLiquid, fieldRangeLiquid)
+	e.submitValue(vehicleID, fs.TanklevelPercent, fieldTanklevelPercent)
+}
+
+func (e *Exporter) SetLockStatus(vehicleID string, ls mercedes.LockStatus) {
+	e.submitValue(vehicleID, ls.DeckLidUnlocked, fieldDeckLidUnlocked)
+	e.submitValue(vehicleID, ls.VehicleStatus, fieldVehicleLock)
+	e.submitValue(vehicleID, ls.GasLidUnlocked, fieldGasLidUnlocked)
+	e.submitValue(vehicleID, ls.Heading, fieldHeading)
+}
+
+func (e *Exporter) SetPayAsYouGo(vehicleID string, p mercedes.PayAsYouDriveInsurance) {
+	e.submitValue(vehicleID, p.Odometer, fieldOdometer)
+}
+
+func (e *Exporter) SetVehicleStatus(vehicleID string, vs mercedes.VehicleStatus) {
+	e.submitValue(vehicleID, vs.DeckLidOpen, fieldDeckLidOpen)
+
+	e.submitValue(vehicleID, vs.DoorFrontLeftOpen, fieldDoorFrontLeftOpen)
+	e.submitValue(vehicleID, vs.DoorFrontRightOpen, fieldDoorFrontRightOpen)
+	e.submitValue(vehicleID, vs.DoorRearLeftOpen, fieldDoorRearLeftOpen)
+	e.submitValue(vehicleID, vs.DoorRearRightOpen, fieldDoorRearRightOpen)
+
+	e.submitValue(vehicleID, vs.InteriorLightsFrontOn, fieldInteriorLightFrontOn)
+	e.submitValue(vehicleID, vs.InteriorLightsRearOn, fieldInteriorLightRearOn)
+
+	e.submitValue(vehicleID, vs.LightSwitchPosition, fieldLightSwitchPosition)
+
+	e.submitValue(vehicleID, vs.ReadingLampFrontLeftOn, fieldReadingLampFrontLeftOn)
+	e.submitValue(vehicleID, vs.ReadingLampFrontRightOn, fieldReadingLampFrontRightOn)
+
+	e.submitValue(vehicleID, vs.RoofTopStatus, fieldRoofTopStatus)
+	e.submitValue(vehicleID, vs.SunRoofStatus, fieldSunRoofStatus)
+
+	e.submitValue(vehicleID, vs.WindowStatusFrontLeft, fieldWindowFrontLeft)
+	e.submitValue(vehicleID, vs.WindowStatusFrontRight, fieldWindowFrontRight)
+	e.submitValue(vehicleID, vs.WindowStatusRearLeft, fieldWindowRearLeft)
+	e.submitValue(vehicleID, vs.WindowStatusRearRight, fieldWindowRearRight)
+}
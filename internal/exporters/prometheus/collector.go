@@ -0,0 +1,121 @@
+package prometheus
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/state"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/vehicle"
+)
+
+type (
+	// Collector renders the vehicle metrics from the state store on
+	// every scrape. It holds no state on its own so multiple instances
+	// can be registered in different registries.
+	Collector struct {
+		metrics  *metrics
+		opts     Options
+		store    *state.Store
+		vehicles *vehicle.Registry
+	}
+
+	// Options configure the behavior of the Collector
+	Options struct {
+		// ExplicitTimestamps attaches the time the vehicle reported the
+		// value to the samples instead of using the scrape time
+		ExplicitTimestamps bool
+		// SeriesTTL hides values the vehicle did not report again
+		// within the TTL (disabled if zero)
+		SeriesTTL time.Duration
+	}
+)
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+// NewCollector creates a Collector for the vehicles in the given store,
+// series are labeled using the given registry (might be nil)
+func NewCollector(store *state.Store, vehicles *vehicle.Registry, opts Options) *Collector {
+	return &Collector{
+		metrics:  newMetrics(),
+		opts:     opts,
+		store:    store,
+		vehicles: vehicles,
+	}
+}
+
+// NewRegistry creates a registry containing only a new Collector
+func NewRegistry(store *state.Store, vehicles *vehicle.Registry, opts Options) *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollector(store, vehicles, opts))
+	return reg
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+
+	for _, v := range c.store.Vehicles() {
+		vl := c.vehicles.LabelValues(v.VehicleID)
+		collect := func(value mercedes.MetricValue, f *fieldDesc, lvs ...string) {
+			c.collect(ch, now, value, f, append(append([]string{}, vl...), lvs...))
+		}
+
+		m := c.metrics
+
+		if es, ok := v.Containers[mercedes.ContainerElectricStatus].Data.(mercedes.ElectricStatus); ok {
+			collect(es.ElectricRange, m.electricRange)
+			collect(es.StateOfCharge, m.electricSOC)
+		}
+
+		if fs, ok := v.Containers[mercedes.ContainerFuelStatus].Data.(mercedes.FuelStatus); ok {
+			collect(fs.RangeLiquid, m.fuelRangeLiquidVec)
+			collect(fs.TanklevelPercent, m.fuelTanklevelPercent)
+		}
+
+		if ls, ok := v.Containers[mercedes.ContainerLockStatus].Data.(mercedes.LockStatus); ok {
+			collect(ls.DeckLidUnlocked, m.lockDeckLidUnlocked)
+			collect(ls.VehicleStatus, m.lockVehicleStatus)
+			collect(ls.GasLidUnlocked, m.lockGasLidUnlocked)
+			collect(ls.Heading, m.lockHeading)
+		}
+
+		if p, ok := v.Containers[mercedes.ContainerPayAsYouDrive].Data.(mercedes.PayAsYouDriveInsurance); ok {
+			collect(p.Odometer, m.paydOdometer)
+		}
+
+		if vs, ok := v.Containers[mercedes.ContainerVehicleStatus].Data.(mercedes.VehicleStatus); ok {
+			collect(vs.DeckLidOpen, m.vehicleDeckLidOpen)
+
+			collect(vs.DoorFrontLeftOpen, m.vehicleDoorOpen, "front_left")
+			collect(vs.DoorFrontRightOpen, m.vehicleDoorOpen, "front_right")
+			collect(vs.DoorRearLeftOpen, m.vehicleDoorOpen, "rear_left")
+			collect(vs.DoorRearRightOpen, m.vehicleDoorOpen, "rear_right")
+
+			collect(vs.InteriorLightsFrontOn, m.vehicleInteriorLight, "front")
+			collect(vs.InteriorLightsRearOn, m.vehicleInteriorLight, "rear")
+
+			collect(vs.LightSwitchPosition, m.vehicleLightSwitch)
+
+			collect(vs.ReadingLampFrontLeftOn, m.vehicleReadingLampOn, "front_left")
+			collect(vs.ReadingLampFrontRightOn, m.vehicleReadingLampOn, "front_right")
+
+			collect(vs.RoofTopStatus, m.vehicleRoofTopStatus)
+			collect(vs.SunRoofStatus, m.vehicleSunRoofStatus)
+
+			collect(vs.WindowStatusFrontLeft, m.vehicleWindowStatus, "front_left")
+			collect(vs.WindowStatusFrontRight, m.vehicleWindowStatus, "front_right")
+			collect(vs.WindowStatusRearLeft, m.vehicleWindowStatus, "rear_left")
+			collect(vs.WindowStatusRearRight, m.vehicleWindowStatus, "rear_right")
+		}
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, f := range c.metrics.all() {
+		ch <- f.value
+		ch <- f.reported
+	}
+}
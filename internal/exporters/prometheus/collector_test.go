@@ -0,0 +1,150 @@
+package prometheus_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/credential"
+	promexp "github.com/Luzifer/mercedes-byocar-exporter/internal/exporters/prometheus"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mockapi"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/state"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/vehicle"
+)
+
+const (
+	testVehicleID = "WDB111111ZZZ22222"
+
+	metricRangeLiquid = "mercedes_byocar_fuel_status_range_liquid"
+	metricDoorOpen    = "mercedes_byocar_vehicle_status_door_open"
+)
+
+// newTestStore fills a state store with the demo data of the mock API
+// fetched through the API client
+func newTestStore(t *testing.T) *state.Store {
+	t.Helper()
+
+	api := mockapi.New("client", "secret")
+	api.AddDemoVehicle(testVehicleID)
+
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+
+	creds, err := credential.NewJSONStore(filepath.Join(t.TempDir(), "creds.json"), "client", "secret")
+	if err != nil {
+		t.Fatalf("creating credential store: %s", err)
+	}
+
+	at, rt, exp := api.IssueToken()
+	if err = creds.UpdateToken(at, rt, exp); err != nil {
+		t.Fatalf("storing token: %s", err)
+	}
+
+	client := mercedes.New("client", "secret", creds,
+		mercedes.WithBaseURL(srv.URL+mockapi.PathAPI),
+		mercedes.WithHTTPClient(srv.Client()),
+	)
+
+	store := state.New()
+	store.SetVehicles(map[string]string{testVehicleID: "Family Car"})
+
+	fs, err := client.GetFuelStatus(context.Background(), testVehicleID)
+	if err != nil {
+		t.Fatalf("getting fuel status: %s", err)
+	}
+	store.SetFuelStatus(testVehicleID, fs)
+
+	vs, err := client.GetVehicleStatus(context.Background(), testVehicleID)
+	if err != nil {
+		t.Fatalf("getting vehicle status: %s", err)
+	}
+	store.SetVehicleStatus(testVehicleID, vs)
+
+	return store
+}
+
+type sample struct {
+	labels    map[string]string
+	value     float64
+	timestamp int64
+}
+
+// gather collects the samples of the registry by metric name
+func gather(t *testing.T, reg *prometheus.Registry) map[string][]sample {
+	t.Helper()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gathering metrics: %s", err)
+	}
+
+	out := make(map[string][]sample)
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			s := sample{
+				labels:    make(map[string]string),
+				value:     m.GetGauge().GetValue(),
+				timestamp: m.GetTimestampMs(),
+			}
+			for _, l := range m.GetLabel() {
+				s.labels[l.GetName()] = l.GetValue()
+			}
+			out[mf.GetName()] = append(out[mf.GetName()], s)
+		}
+	}
+
+	return out
+}
+
+func TestRegistriesShareStore(t *testing.T) {
+	store := newTestStore(t)
+
+	plain := vehicle.NewRegistry(vehicle.VINModePlain, "")
+	plain.Set(map[string]vehicle.Metadata{testVehicleID: {Name: "Family Car"}})
+
+	hashed := vehicle.NewRegistry(vehicle.VINModeHash, "salt")
+
+	regPlain := promexp.NewRegistry(store, plain, promexp.Options{})
+	regHashed := promexp.NewRegistry(store, hashed, promexp.Options{ExplicitTimestamps: true})
+
+	// Gather both repeatedly to ensure the collectors do not interfere
+	for i := 0; i < 2; i++ {
+		samplesPlain := gather(t, regPlain)
+		samplesHashed := gather(t, regHashed)
+
+		for name, samples := range map[string]map[string][]sample{"plain": samplesPlain, "hashed": samplesHashed} {
+			if n := len(samples[metricRangeLiquid]); n != 1 {
+				t.Fatalf("%s: expected one %s sample, got %d", name, metricRangeLiquid, n)
+			}
+
+			if v := samples[metricRangeLiquid][0].value; v != 540 {
+				t.Errorf("%s: expected range of 540, got %v", name, v)
+			}
+
+			if n := len(samples[metricDoorOpen]); n != 4 {
+				t.Errorf("%s: expected 4 door samples, got %d", name, n)
+			}
+		}
+
+		rl := samplesPlain[metricRangeLiquid][0]
+		if rl.labels[vehicle.LabelVehicleID] != testVehicleID || rl.labels[vehicle.LabelName] != "Family Car" {
+			t.Errorf("plain: unexpected labels %v", rl.labels)
+		}
+		if rl.timestamp != 0 {
+			t.Errorf("plain: unexpected explicit timestamp %d", rl.timestamp)
+		}
+
+		rl = samplesHashed[metricRangeLiquid][0]
+		if rl.labels[vehicle.LabelVehicleID] != hashed.ID(testVehicleID) || rl.labels[vehicle.LabelName] != "" {
+			t.Errorf("hashed: unexpected labels %v", rl.labels)
+		}
+		if rl.timestamp == 0 || time.Since(time.UnixMilli(rl.timestamp)) > time.Minute {
+			t.Errorf("hashed: expected explicit timestamp of the demo data, got %d", rl.timestamp)
+		}
+	}
+}
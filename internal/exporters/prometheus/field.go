@@ -0,0 +1,56 @@
+package prometheus
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+)
+
+type (
+	// fieldDesc describes a vehicle metric and its companion containing
+	// the time the vehicle reported the value
+	fieldDesc struct {
+		value    *prometheus.Desc
+		reported *prometheus.Desc
+	}
+)
+
+func newFieldDesc(opts prometheus.GaugeOpts, labelNames []string) *fieldDesc {
+	return &fieldDesc{
+		value: prometheus.NewDesc(
+			prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
+			opts.Help, labelNames, nil,
+		),
+		reported: prometheus.NewDesc(
+			prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name+"_reported_timestamp_seconds"),
+			"Time the vehicle reported the value of "+opts.Name, labelNames, nil,
+		),
+	}
+}
+
+// collect sends the value and its reported time unless the value is
+// invalid or expired
+func (c *Collector) collect(ch chan<- prometheus.Metric, now time.Time, value mercedes.MetricValue, f *fieldDesc, labelValues []string) {
+	if !value.IsValid() {
+		return
+	}
+
+	if c.opts.SeriesTTL > 0 && now.Sub(value.Time()) > c.opts.SeriesTTL {
+		// Vehicle did not report the value for too long
+		return
+	}
+
+	m := prometheus.MustNewConstMetric(f.value, prometheus.GaugeValue, value.ToFloat(), labelValues...)
+	if c.opts.ExplicitTimestamps {
+		m = prometheus.NewMetricWithTimestamp(value.Time(), m)
+	}
+	ch <- m
+
+	ch <- prometheus.MustNewConstMetric(
+		f.reported, prometheus.GaugeValue,
+		float64(value.Time().UnixNano())/float64(time.Second),
+		labelValues...,
+	)
+}
@@ -2,14 +2,14 @@ package prometheus
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/vehicle"
 )
 
 const (
-	labelVehicleID = "vehicle_id"
-	labelDoor      = "door"
-	labelLight     = "light"
-	labelWindow    = "window"
+	labelDoor   = "door"
+	labelLight  = "light"
+	labelWindow = "window"
 
 	metricsNamespace = "mercedes_byocar"
 
@@ -20,163 +20,199 @@ const (
 	subsystemVehicleStatus  = "vehicle_status"
 )
 
-var (
-	electricSOC   *prometheus.GaugeVec
-	electricRange *prometheus.GaugeVec
-
-	fuelRangeLiquidVec   *prometheus.GaugeVec
-	fuelTanklevelPercent *prometheus.GaugeVec
+type (
+	// metrics contains the descriptions of all vehicle metrics
+	metrics struct {
+		electricSOC   *fieldDesc
+		electricRange *fieldDesc
+
+		fuelRangeLiquidVec   *fieldDesc
+		fuelTanklevelPercent *fieldDesc
+
+		lockDeckLidUnlocked *fieldDesc
+		lockVehicleStatus   *fieldDesc
+		lockGasLidUnlocked  *fieldDesc
+		lockHeading         *fieldDesc
+
+		paydOdometer *fieldDesc
+
+		vehicleDeckLidOpen   *fieldDesc
+		vehicleDoorOpen      *fieldDesc
+		vehicleInteriorLight *fieldDesc
+		vehicleLightSwitch   *fieldDesc
+		vehicleReadingLampOn *fieldDesc
+		vehicleRoofTopStatus *fieldDesc
+		vehicleSunRoofStatus *fieldDesc
+		vehicleWindowStatus  *fieldDesc
+	}
+)
 
-	lockDeckLidUnlocked *prometheus.GaugeVec
-	lockVehicleStatus   *prometheus.GaugeVec
-	lockGasLidUnlocked  *prometheus.GaugeVec
-	lockHeading         *prometheus.GaugeVec
+func newMetrics() *metrics {
+	m := &metrics{}
 
-	paydOdometer *prometheus.GaugeVec
+	m.initElectricStatus()
+	m.initFuelStatus()
+	m.initLockStatus()
+	m.initPAYD()
+	m.initVehicleStatus()
 
-	vehicleDeckLidOpen   *prometheus.GaugeVec
-	vehicleDoorOpen      *prometheus.GaugeVec
-	vehicleInteriorLight *prometheus.GaugeVec
-	vehicleLightSwitch   *prometheus.GaugeVec
-	vehicleReadingLampOn *prometheus.GaugeVec
-	vehicleRoofTopStatus *prometheus.GaugeVec
-	vehicleSunRoofStatus *prometheus.GaugeVec
-	vehicleWindowStatus  *prometheus.GaugeVec
-)
+	return m
+}
 
-func init() {
-	initElectricStatus()
-	initFuelStatus()
-	initLockStatus()
-	initPAYD()
-	initVehicleStatus()
+// all returns all descriptions
+func (m *metrics) all() []*fieldDesc {
+	return []*fieldDesc{
+		m.electricSOC,
+		m.electricRange,
+		m.fuelRangeLiquidVec,
+		m.fuelTanklevelPercent,
+		m.lockDeckLidUnlocked,
+		m.lockVehicleStatus,
+		m.lockGasLidUnlocked,
+		m.lockHeading,
+		m.paydOdometer,
+		m.vehicleDeckLidOpen,
+		m.vehicleDoorOpen,
+		m.vehicleInteriorLight,
+		m.vehicleLightSwitch,
+		m.vehicleReadingLampOn,
+		m.vehicleRoofTopStatus,
+		m.vehicleSunRoofStatus,
+		m.vehicleWindowStatus,
+	}
 }
 
-func initElectricStatus() {
-	electricRange = promauto.NewGaugeVec(prometheus.GaugeOpts{
+func (m *metrics) initElectricStatus() {
+	m.electricRange = newFieldDesc(prometheus.GaugeOpts{
 		Namespace: metricsNamespace,
 		Subsystem: subsystemElectricStatus,
 		Name:      "electric_range",
 		Help:      "Electric range - 0..2046 km",
-	}, []string{labelVehicleID})
+	}, vehicleLabels())
 
-	electricSOC = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	m.electricSOC = newFieldDesc(prometheus.GaugeOpts{
 		Namespace: metricsNamespace,
 		Subsystem: subsystemElectricStatus,
 		Name:      "state_of_charge",
 		Help:      "Displayed state of charge for the HV battery - 0..100 %",
-	}, []string{labelVehicleID})
+	}, vehicleLabels())
 }
 
-func initFuelStatus() {
-	fuelRangeLiquidVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
+func (m *metrics) initFuelStatus() {
+	m.fuelRangeLiquidVec = newFieldDesc(prometheus.GaugeOpts{
 		Namespace: metricsNamespace,
 		Subsystem: subsystemFuelStatus,
 		Name:      "range_liquid",
 		Help:      "Liquid fuel tank range - 0..2046 km",
-	}, []string{labelVehicleID})
+	}, vehicleLabels())
 
-	fuelTanklevelPercent = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	m.fuelTanklevelPercent = newFieldDesc(prometheus.GaugeOpts{
 		Namespace: metricsNamespace,
 		Subsystem: subsystemFuelStatus,
 		Name:      "tanklevel_percent",
 		Help:      "Liquid fuel tank level - 0..100 %",
-	}, []string{labelVehicleID})
+	}, vehicleLabels())
 }
 
-func initLockStatus() {
-	lockDeckLidUnlocked = promauto.NewGaugeVec(prometheus.GaugeOpts{
+func (m *metrics) initLockStatus() {
+	m.lockDeckLidUnlocked = newFieldDesc(prometheus.GaugeOpts{
 		Namespace: metricsNamespace,
 		Subsystem: subsystemLockStatus,
 		Name:      "deck_lid_unlocked",
 		Help:      "Lock status of the deck lid - 1 = unlocked",
-	}, []string{labelVehicleID})
+	}, vehicleLabels())
 
-	lockVehicleStatus = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	m.lockVehicleStatus = newFieldDesc(prometheus.GaugeOpts{
 		Namespace: metricsNamespace,
 		Subsystem: subsystemLockStatus,
 		Name:      "vehicle_status",
 		Help:      "Vehicle lock status - 0 = unlocked, 1 = internal locked, 2 = external locked, 3 = selective unlocked",
-	}, []string{labelVehicleID})
+	}, vehicleLabels())
 
-	lockGasLidUnlocked = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	m.lockGasLidUnlocked = newFieldDesc(prometheus.GaugeOpts{
 		Namespace: metricsNamespace,
 		Subsystem: subsystemLockStatus,
 		Name:      "gas_lid_unlocked",
 		Help:      "Status of gas tank door lock - 1 = unlocked",
-	}, []string{labelVehicleID})
+	}, vehicleLabels())
 
-	lockHeading = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	m.lockHeading = newFieldDesc(prometheus.GaugeOpts{
 		Namespace: metricsNamespace,
 		Subsystem: subsystemLockStatus,
 		Name:      "heading",
 		Help:      "Vehicle heading position - 0..359.9 degrees",
-	}, []string{labelVehicleID})
+	}, vehicleLabels())
 }
 
-func initPAYD() {
-	paydOdometer = promauto.NewGaugeVec(prometheus.GaugeOpts{
+func (m *metrics) initPAYD() {
+	m.paydOdometer = newFieldDesc(prometheus.GaugeOpts{
 		Namespace: metricsNamespace,
 		Subsystem: subsystemPayAsYouDrive,
 		Name:      "odometer",
 		Help:      "Odometer - 0..999999 km",
-	}, []string{labelVehicleID})
+	}, vehicleLabels())
 }
 
-func initVehicleStatus() {
-	vehicleDeckLidOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+func (m *metrics) initVehicleStatus() {
+	m.vehicleDeckLidOpen = newFieldDesc(prometheus.GaugeOpts{
 		Namespace: metricsNamespace,
 		Subsystem: subsystemVehicleStatus,
 		Name:      "deck_lid_open",
 		Help:      "Deck lid latch status opened/closed state - 1 = open",
-	}, []string{labelVehicleID})
+	}, vehicleLabels())
 
-	vehicleDoorOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	m.vehicleDoorOpen = newFieldDesc(prometheus.GaugeOpts{
 		Namespace: metricsNamespace,
 		Subsystem: subsystemVehicleStatus,
 		Name:      "door_open",
 		Help:      "Status of respective door - 1 = open",
-	}, []string{labelVehicleID, labelDoor})
+	}, vehicleLabels(labelDoor))
 
-	vehicleInteriorLight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	m.vehicleInteriorLight = newFieldDesc(prometheus.GaugeOpts{
 		Namespace: metricsNamespace,
 		Subsystem: subsystemVehicleStatus,
 		Name:      "interior_light_on",
 		Help:      "Status of respective interior light - 1 = on",
-	}, []string{labelVehicleID, labelLight})
+	}, vehicleLabels(labelLight))
 
-	vehicleLightSwitch = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	m.vehicleLightSwitch = newFieldDesc(prometheus.GaugeOpts{
 		Namespace: metricsNamespace,
 		Subsystem: subsystemVehicleStatus,
 		Name:      "light_switch_position",
 		Help:      "Rotary light switch position - 0 = auto, 1 = headlights, 2 = sidelight left, 3 = sidelight right, 4 = parking light",
-	}, []string{labelVehicleID})
+	}, vehicleLabels())
 
-	vehicleReadingLampOn = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	m.vehicleReadingLampOn = newFieldDesc(prometheus.GaugeOpts{
 		Namespace: metricsNamespace,
 		Subsystem: subsystemVehicleStatus,
 		Name:      "reading_lamp_on",
 		Help:      "Status of respective reading lamp - 1 = on",
-	}, []string{labelVehicleID, labelLight})
+	}, vehicleLabels(labelLight))
 
-	vehicleRoofTopStatus = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	m.vehicleRoofTopStatus = newFieldDesc(prometheus.GaugeOpts{
 		Namespace: metricsNamespace,
 		Subsystem: subsystemVehicleStatus,
 		Name:      "roof_top_status",
 		Help:      "Status of the convertible top - 0 = unlocked, 1 = open and locked, 2 = closed and locked",
-	}, []string{labelVehicleID})
+	}, vehicleLabels())
 
-	vehicleSunRoofStatus = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	m.vehicleSunRoofStatus = newFieldDesc(prometheus.GaugeOpts{
 		Namespace: metricsNamespace,
 		Subsystem: subsystemVehicleStatus,
 		Name:      "sun_roof_status",
 		Help:      "Status of the sunroof - 0 = Tilt/slide sunroof is closed, 1 = Tilt/slide sunroof is complete open, 2 = Lifting roof is open, 3 = Tilt/slide sunroof is running, 4 = Tilt/slide sunroof in anti-booming position, 5 = Sliding roof in intermediate position, 6 = Lifting roof in intermediate position",
-	}, []string{labelVehicleID})
+	}, vehicleLabels())
 
-	vehicleWindowStatus = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	m.vehicleWindowStatus = newFieldDesc(prometheus.GaugeOpts{
 		Namespace: metricsNamespace,
 		Subsystem: subsystemVehicleStatus,
 		Name:      "window_status",
 		Help:      "Status of respective window - 0 = window in intermediate position, 1 = window completely opened, 2 = window completely closed, 3 = window airing position, 4 = window intermediate airing position, 5 = window currently running",
-	}, []string{labelVehicleID, labelWindow})
+	}, vehicleLabels(labelWindow))
+}
+
+// vehicleLabels returns the vehicle identifying labels followed by
+// the given extra labels
+func vehicleLabels(extra ...string) []string {
+	return append(append([]string{}, vehicle.LabelNames...), extra...)
 }
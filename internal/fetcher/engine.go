@@ -0,0 +1,397 @@
+package fetcher
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/exporters"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/vehicle"
+)
+
+type (
+	// Engine fans out the fetches for all vehicles and containers over
+	// a bounded pool of workers and submits the results to an exporter
+	Engine struct {
+		client   mercedes.Client
+		exporter atomic.Value // exporterHolder
+		opts     Options
+
+		running atomic.Bool
+
+		lastStartLock sync.Mutex
+		lastStart     map[string]time.Time
+	}
+
+	// Options configure the behavior of the Engine
+	Options struct {
+		// Containers to fetch for vehicles not specifying their own
+		// containers (defaults to all)
+		Containers []mercedes.Container
+		// CycleTimeout limits the duration of one cycle (no limit if zero)
+		CycleTimeout time.Duration
+		// OnFetch is called after each container fetch (optional)
+		OnFetch func(vehicleID string, container mercedes.Container, err error, duration time.Duration)
+		// Vehicles provides the vehicle labels of the self-metrics
+		// (optional)
+		Vehicles *vehicle.Registry
+		// Workers is the number of concurrently executed fetches
+		Workers int
+	}
+
+	// Vehicle configures what to fetch for a vehicle
+	Vehicle struct {
+		ID string
+		// Containers to fetch (defaults to Options.Containers)
+		Containers []mercedes.Container
+		// Interval is the minimum time between two fetches of this
+		// vehicle (zero fetches on every cycle)
+		Interval time.Duration
+	}
+
+	// VehicleResult describes the outcome of one cycle for one vehicle
+	VehicleResult struct {
+		VehicleID string
+		Duration  time.Duration
+		Errors    map[mercedes.Container]error
+		// LatestData is the most recent timestamp reported by the API
+		// within all fetched containers
+		LatestData time.Time
+		// Requests is the number of requests sent to the API including
+		// retries
+		Requests int
+	}
+
+	// exporterHolder wraps the exporter as atomic.Value requires a
+	// consistent concrete type
+	exporterHolder struct{ exporters.Exporter }
+
+	job struct {
+		vehicleID string
+		container mercedes.Container
+	}
+
+	jobResult struct {
+		job
+		start, end time.Time
+		latestData time.Time
+		requests   int
+		err        error
+	}
+)
+
+// ErrCycleRunning is returned when a new cycle is requested while the
+// previous one has not yet finished
+var ErrCycleRunning = errors.New("previous cycle is still running")
+
+// dueSlack allows a vehicle to be fetched slightly before its interval
+// passed as cycles are not exactly aligned to the interval
+const dueSlack = 10 * time.Second
+
+// New creates a new Engine fetching through the given client and
+// submitting the data to the given exporter
+func New(client mercedes.Client, exporter exporters.Exporter, opts Options) *Engine {
+	if len(opts.Containers) == 0 {
+		opts.Containers = mercedes.Containers
+	}
+
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+
+	e := &Engine{
+		client: client,
+		opts:   opts,
+
+		lastStart: make(map[string]time.Time),
+	}
+	e.SetExporter(exporter)
+
+	return e
+}
+
+// RequestsPerCycle estimates the average number of requests one cycle
+// issues when cycles are run every cycleInterval
+func RequestsPerCycle(vehicles []Vehicle, defaultContainers []mercedes.Container, cycleInterval time.Duration) int {
+	if len(defaultContainers) == 0 {
+		defaultContainers = mercedes.Containers
+	}
+
+	var requests float64
+	for _, v := range vehicles {
+		containers := v.Containers
+		if len(containers) == 0 {
+			containers = defaultContainers
+		}
+
+		share := 1.0
+		if v.Interval > cycleInterval && cycleInterval > 0 {
+			share = float64(cycleInterval) / float64(v.Interval)
+		}
+
+		requests += share * float64(len(containers))
+	}
+
+	return int(math.Ceil(requests))
+}
+
+// SetExporter replaces the exporter the fetched data is submitted to,
+// fetches already running might still submit to the old one
+func (e *Engine) SetExporter(exporter exporters.Exporter) {
+	e.exporter.Store(exporterHolder{exporter})
+}
+
+// Run executes one fetch cycle for all given vehicles which are due
+// and blocks until all fetches are done or the cycle deadline is
+// reached. Only one cycle may run at a time, overlapping calls return
+// ErrCycleRunning.
+func (e *Engine) Run(ctx context.Context, vehicles []Vehicle) ([]VehicleResult, error) {
+	if !e.running.CompareAndSwap(false, true) {
+		return nil, ErrCycleRunning
+	}
+	defer e.running.Store(false)
+
+	var (
+		jobList    []job
+		vehicleIDs []string
+	)
+	for _, v := range e.dueVehicles(vehicles) {
+		containers := v.Containers
+		if len(containers) == 0 {
+			containers = e.opts.Containers
+		}
+
+		vehicleIDs = append(vehicleIDs, v.ID)
+		for _, c := range containers {
+			jobList = append(jobList, job{vehicleID: v.ID, container: c})
+		}
+	}
+
+	if e.opts.CycleTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.opts.CycleTimeout)
+		defer cancel()
+	}
+
+	var (
+		jobs       = make(chan job)
+		jobResults = make(chan jobResult)
+		wg         sync.WaitGroup
+	)
+
+	workers := e.opts.Workers
+	if n := len(jobList); n < workers {
+		workers = n
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				jobResults <- e.runJob(ctx, j)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for _, j := range jobList {
+			select {
+			case jobs <- j:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(jobResults)
+	}()
+
+	var (
+		done    = make(map[job]bool)
+		timings = make(map[string][2]time.Time)
+		results = make(map[string]*VehicleResult)
+	)
+
+	for _, vehicleID := range vehicleIDs {
+		results[vehicleID] = &VehicleResult{
+			VehicleID: vehicleID,
+			Errors:    make(map[mercedes.Container]error),
+		}
+	}
+
+	for res := range jobResults {
+		done[res.job] = true
+
+		vr := results[res.vehicleID]
+		vr.Requests += res.requests
+		if res.err != nil {
+			vr.Errors[res.container] = res.err
+		}
+		if res.latestData.After(vr.LatestData) {
+			vr.LatestData = res.latestData
+		}
+
+		t, ok := timings[res.vehicleID]
+		if !ok || res.start.Before(t[0]) {
+			t[0] = res.start
+		}
+		if res.end.After(t[1]) {
+			t[1] = res.end
+		}
+		timings[res.vehicleID] = t
+	}
+
+	for _, j := range jobList {
+		if !done[j] {
+			// Deadline was hit before the job could be started
+			results[j.vehicleID].Errors[j.container] = errors.Wrap(ctx.Err(), "fetch not started")
+		}
+	}
+
+	out := make([]VehicleResult, 0, len(vehicleIDs))
+	for _, vehicleID := range vehicleIDs {
+		vr := results[vehicleID]
+
+		if t, ok := timings[vehicleID]; ok {
+			vr.Duration = t[1].Sub(t[0])
+			fetchVehicleDuration.WithLabelValues(e.opts.Vehicles.LabelValues(vehicleID)...).Observe(vr.Duration.Seconds())
+		}
+
+		logger := logrus.WithFields(logrus.Fields{
+			"duration":   vr.Duration,
+			"errors":     len(vr.Errors),
+			"vehicle_id": vehicleID,
+		})
+		logger.Info("data updated")
+
+		out = append(out, *vr)
+	}
+
+	return out, nil
+}
+
+// dueVehicles filters the vehicles whose interval has passed and marks
+// them as started
+func (e *Engine) dueVehicles(vehicles []Vehicle) []Vehicle {
+	e.lastStartLock.Lock()
+	defer e.lastStartLock.Unlock()
+
+	var (
+		now   = time.Now()
+		out   []Vehicle
+		known = make(map[string]bool, len(vehicles))
+	)
+
+	for _, v := range vehicles {
+		known[v.ID] = true
+	}
+
+	for id := range e.lastStart {
+		if !known[id] {
+			// Vehicle was removed from the configuration
+			delete(e.lastStart, id)
+		}
+	}
+
+	for _, v := range vehicles {
+		if last, ok := e.lastStart[v.ID]; ok && v.Interval > 0 && now.Sub(last) < v.Interval-dueSlack {
+			continue
+		}
+
+		e.lastStart[v.ID] = now
+		out = append(out, v)
+	}
+
+	return out
+}
+
+func (e *Engine) runJob(ctx context.Context, j job) jobResult {
+	var requests atomic.Int64
+
+	res := jobResult{job: j, start: time.Now()}
+	res.latestData, res.err = e.fetchContainer(mercedes.WithRequestCounter(ctx, &requests), j.vehicleID, j.container)
+	res.end = time.Now()
+	res.requests = int(requests.Load())
+
+	e.recordMetrics(res)
+	if e.opts.OnFetch != nil {
+		e.opts.OnFetch(j.vehicleID, j.container, res.err, res.end.Sub(res.start))
+	}
+
+	logger := logrus.WithFields(logrus.Fields{
+		"container":  j.container,
+		"vehicle_id": j.vehicleID,
+	})
+
+	switch {
+	case res.err == nil:
+		logger.WithField("duration", res.end.Sub(res.start)).Debug("container fetched")
+
+	case errors.Is(res.err, mercedes.ErrNoDataAvailable):
+		logger.Warnf("%s data is not available", j.container)
+
+	default:
+		logger.WithError(res.err).Errorf("fetching %s data", j.container)
+	}
+
+	return res
+}
+
+func (e *Engine) fetchContainer(ctx context.Context, vehicleID string, container mercedes.Container) (time.Time, error) {
+	exporter := e.exporter.Load().(exporterHolder)
+
+	switch container {
+	case mercedes.ContainerElectricStatus:
+		s, err := e.client.GetElectricStatus(ctx, vehicleID)
+		if err != nil {
+			return time.Time{}, err
+		}
+		exporter.SetElectricStatus(vehicleID, s)
+		return mercedes.LatestTime(s), nil
+
+	case mercedes.ContainerFuelStatus:
+		s, err := e.client.GetFuelStatus(ctx, vehicleID)
+		if err != nil {
+			return time.Time{}, err
+		}
+		exporter.SetFuelStatus(vehicleID, s)
+		return mercedes.LatestTime(s), nil
+
+	case mercedes.ContainerLockStatus:
+		s, err := e.client.GetLockStatus(ctx, vehicleID)
+		if err != nil {
+			return time.Time{}, err
+		}
+		exporter.SetLockStatus(vehicleID, s)
+		return mercedes.LatestTime(s), nil
+
+	case mercedes.ContainerPayAsYouDrive:
+		s, err := e.client.GetPayAsYouDriveInsurance(ctx, vehicleID)
+		if err != nil {
+			return time.Time{}, err
+		}
+		exporter.SetPayAsYouGo(vehicleID, s)
+		return mercedes.LatestTime(s), nil
+
+	case mercedes.ContainerVehicleStatus:
+		s, err := e.client.GetVehicleStatus(ctx, vehicleID)
+		if err != nil {
+			return time.Time{}, err
+		}
+		exporter.SetVehicleStatus(vehicleID, s)
+		return mercedes.LatestTime(s), nil
+
+	default:
+		return time.Time{}, errors.Errorf("unknown container %q", container)
+	}
+}
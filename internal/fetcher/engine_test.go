@@ -0,0 +1,268 @@
+package fetcher
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/exporters"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/selfmetrics"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/vehicle"
+)
+
+const testVehicleID = "WDB111111ZZZ22222"
+
+// fakeClient blocks every fetch until release is closed or the context
+// is done and tracks the number of concurrent fetches
+type fakeClient struct {
+	// attempts is the number of API requests counted per fetch
+	// (simulating retries), defaults to one
+	attempts int
+	release  chan struct{}
+
+	lock        sync.Mutex
+	inFlight    int
+	maxInFlight int
+	requests    int
+	started     chan struct{}
+}
+
+var _ mercedes.Client = (*fakeClient)(nil)
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		release: make(chan struct{}),
+		started: make(chan struct{}, len(mercedes.Containers)),
+	}
+}
+
+func (f *fakeClient) fetch(ctx context.Context) error {
+	attempts := f.attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	for i := 0; i < attempts; i++ {
+		mercedes.CountRequest(ctx)
+	}
+
+	f.lock.Lock()
+	f.inFlight++
+	f.requests++
+	if f.inFlight > f.maxInFlight {
+		f.maxInFlight = f.inFlight
+	}
+	f.lock.Unlock()
+
+	defer func() {
+		f.lock.Lock()
+		f.inFlight--
+		f.lock.Unlock()
+	}()
+
+	select {
+	case f.started <- struct{}{}:
+	default:
+		// Nobody is waiting for this fetch
+	}
+
+	select {
+	case <-f.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (f *fakeClient) stats() (maxInFlight, requests int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.maxInFlight, f.requests
+}
+
+func (*fakeClient) GetAuthStartURL(string) string { return "" }
+
+func (f *fakeClient) GetElectricStatus(ctx context.Context, _ string) (mercedes.ElectricStatus, error) {
+	return mercedes.ElectricStatus{}, f.fetch(ctx)
+}
+
+func (f *fakeClient) GetFuelStatus(ctx context.Context, _ string) (mercedes.FuelStatus, error) {
+	return mercedes.FuelStatus{}, f.fetch(ctx)
+}
+
+func (f *fakeClient) GetLockStatus(ctx context.Context, _ string) (mercedes.LockStatus, error) {
+	return mercedes.LockStatus{}, f.fetch(ctx)
+}
+
+func (f *fakeClient) GetPayAsYouDriveInsurance(ctx context.Context, _ string) (mercedes.PayAsYouDriveInsurance, error) {
+	return mercedes.PayAsYouDriveInsurance{}, f.fetch(ctx)
+}
+
+func (f *fakeClient) GetVehicleStatus(ctx context.Context, _ string) (mercedes.VehicleStatus, error) {
+	return mercedes.VehicleStatus{}, f.fetch(ctx)
+}
+
+func (*fakeClient) StoreTokenFromRequest(string, *http.Request) error { return nil }
+
+func TestEngineBoundsWorkers(t *testing.T) {
+	client := newFakeClient()
+	engine := New(client, exporters.Set{}, Options{Workers: 2})
+
+	done := make(chan []VehicleResult)
+	go func() {
+		results, err := engine.Run(context.Background(), []Vehicle{{ID: testVehicleID}})
+		if err != nil {
+			t.Errorf("running cycle: %s", err)
+		}
+		done <- results
+	}()
+
+	// Wait for the pool to be busy and give a third fetch the chance to
+	// start before releasing the fetches
+	<-client.started
+	<-client.started
+	time.Sleep(50 * time.Millisecond)
+	close(client.release)
+
+	results := <-done
+
+	maxInFlight, requests := client.stats()
+	if maxInFlight != 2 {
+		t.Errorf("expected 2 concurrent fetches, got %d", maxInFlight)
+	}
+
+	if requests != len(mercedes.Containers) {
+		t.Errorf("expected %d requests, got %d", len(mercedes.Containers), requests)
+	}
+
+	if len(results) != 1 || results[0].Requests != len(mercedes.Containers) || len(results[0].Errors) != 0 {
+		t.Errorf("unexpected results: %+v", results)
+	}
+}
+
+func TestEngineCountsRetriedRequests(t *testing.T) {
+	client := newFakeClient()
+	client.attempts = 3
+	close(client.release)
+
+	engine := New(client, exporters.Set{}, Options{Workers: 2})
+
+	results, err := engine.Run(context.Background(), []Vehicle{{ID: testVehicleID}})
+	if err != nil {
+		t.Fatalf("running cycle: %s", err)
+	}
+
+	if expect := 3 * len(mercedes.Containers); len(results) != 1 || results[0].Requests != expect {
+		t.Errorf("expected %d requests, got %+v", expect, results)
+	}
+}
+
+func TestEngineObservesVehicleDuration(t *testing.T) {
+	const vehicleID = "WDB555555ZZZ66666"
+
+	client := newFakeClient()
+	close(client.release)
+
+	engine := New(client, exporters.Set{}, Options{Workers: 2})
+	if _, err := engine.Run(context.Background(), []Vehicle{{ID: vehicleID}}); err != nil {
+		t.Fatalf("running cycle: %s", err)
+	}
+
+	families, err := selfmetrics.Registry.Gather()
+	if err != nil {
+		t.Fatalf("gathering metrics: %s", err)
+	}
+
+	var observed uint64
+	for _, mf := range families {
+		if mf.GetName() != "mercedes_byocar_fetch_vehicle_duration_seconds" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == vehicle.LabelVehicleID && l.GetValue() == vehicleID {
+					observed += m.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+
+	if observed != 1 {
+		t.Errorf("expected one observed cycle duration for the vehicle, got %d", observed)
+	}
+}
+
+func TestEngineRejectsOverlappingCycles(t *testing.T) {
+	client := newFakeClient()
+	engine := New(client, exporters.Set{}, Options{Workers: 1})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := engine.Run(context.Background(), []Vehicle{{ID: testVehicleID}}); err != nil {
+			t.Errorf("running first cycle: %s", err)
+		}
+	}()
+
+	<-client.started
+
+	if _, err := engine.Run(context.Background(), []Vehicle{{ID: testVehicleID}}); !errors.Is(err, ErrCycleRunning) {
+		t.Errorf("expected ErrCycleRunning, got %v", err)
+	}
+
+	close(client.release)
+	<-done
+
+	// Once the first cycle finished the next one is accepted
+	if _, err := engine.Run(context.Background(), []Vehicle{{ID: testVehicleID}}); err != nil {
+		t.Errorf("running cycle after the first one finished: %s", err)
+	}
+}
+
+func TestEngineReportsUnstartedFetchesOnTimeout(t *testing.T) {
+	client := newFakeClient()
+	engine := New(client, exporters.Set{}, Options{
+		CycleTimeout: 50 * time.Millisecond,
+		Workers:      1,
+	})
+
+	// Fetches never get released, the first one runs into the deadline
+	// while the others are still waiting for the worker
+	results, err := engine.Run(context.Background(), []Vehicle{{ID: testVehicleID}})
+	if err != nil {
+		t.Fatalf("running cycle: %s", err)
+	}
+
+	if len(results) != 1 {
+		t.Fatalf("expected one result, got %d", len(results))
+	}
+	res := results[0]
+
+	if res.Requests != 1 {
+		t.Errorf("expected one started fetch, got %d", res.Requests)
+	}
+
+	if len(res.Errors) != len(mercedes.Containers) {
+		t.Fatalf("expected errors for all %d containers, got %d", len(mercedes.Containers), len(res.Errors))
+	}
+
+	var notStarted int
+	for container, err := range res.Errors {
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected deadline error for %s, got %v", container, err)
+		}
+		if strings.Contains(err.Error(), "fetch not started") {
+			notStarted++
+		}
+	}
+
+	if notStarted != len(mercedes.Containers)-1 {
+		t.Errorf("expected %d unstarted fetches, got %d", len(mercedes.Containers)-1, notStarted)
+	}
+}
@@ -0,0 +1,70 @@
+package fetcher
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/selfmetrics"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/vehicle"
+)
+
+const (
+	metricsNamespace = "mercedes_byocar"
+	metricsSubsystem = "fetch"
+
+	labelClass     = "class"
+	labelContainer = "container"
+)
+
+var (
+	durationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60} //nolint:gomnd // Bucket boundaries
+
+	fetchDuration = selfmetrics.Factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "duration_seconds",
+		Help:      "Duration of container fetches including retries",
+		Buckets:   durationBuckets,
+	}, metricLabels(labelContainer))
+
+	fetchErrors = selfmetrics.Factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "errors_total",
+		Help:      "Failed container fetches by error class (auth, http, network, no_data, other, parse, rate_limited, server, timeout)",
+	}, metricLabels(labelContainer, labelClass))
+
+	fetchLastSuccess = selfmetrics.Factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Time of the last successful container fetch",
+	}, metricLabels(labelContainer))
+
+	fetchVehicleDuration = selfmetrics.Factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "vehicle_duration_seconds",
+		Help:      "Duration from the first to the last container fetch of a vehicle within a cycle",
+		Buckets:   durationBuckets,
+	}, metricLabels())
+)
+
+func metricLabels(extra ...string) []string {
+	return append(append([]string{}, vehicle.LabelNames...), extra...)
+}
+
+// recordMetrics updates the self-metrics with the result of a job
+func (e *Engine) recordMetrics(res jobResult) {
+	lvs := e.opts.Vehicles.LabelValues(res.vehicleID)
+	lvs = append(lvs, string(res.container))
+
+	fetchDuration.WithLabelValues(lvs...).Observe(res.end.Sub(res.start).Seconds())
+
+	if res.err != nil {
+		fetchErrors.WithLabelValues(append(lvs, mercedes.ErrorClass(res.err))...).Inc()
+		return
+	}
+
+	fetchLastSuccess.WithLabelValues(lvs...).Set(float64(res.end.Unix()))
+}
@@ -1,22 +1,38 @@
 package mercedes
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"reflect"
+	"strconv"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 type (
 	Client interface {
 		GetAuthStartURL(redirectURL string) string
-		GetElectricStatus(vehicleID string) (ElectricStatus, error)
-		GetFuelStatus(vehicleID string) (FuelStatus, error)
-		GetLockStatus(vehicleID string) (LockStatus, error)
-		GetPayAsYouDriveInsurance(vehicleID string) (PayAsYouDriveInsurance, error)
-		GetVehicleStatus(vehicleID string) (VehicleStatus, error)
+		GetElectricStatus(ctx context.Context, vehicleID string) (ElectricStatus, error)
+		GetFuelStatus(ctx context.Context, vehicleID string) (FuelStatus, error)
+		GetLockStatus(ctx context.Context, vehicleID string) (LockStatus, error)
+		GetPayAsYouDriveInsurance(ctx context.Context, vehicleID string) (PayAsYouDriveInsurance, error)
+		GetVehicleStatus(ctx context.Context, vehicleID string) (VehicleStatus, error)
 		StoreTokenFromRequest(redirectURL string, r *http.Request) error
 	}
 
+	// Field describes one value of a container struct
+	Field struct {
+		// Name of the field as reported by the API
+		Name  string
+		Value MetricValue
+	}
+
+	// Container identifies one of the data containers exposed by the
+	// BYOCAR API. The value matches the path segment used in the API.
+	Container string
+
 	MetricValue interface {
 		IsValid() bool
 		Time() time.Time
@@ -59,6 +75,24 @@ var (
 	_ MetricValue = TimedInt{}
 )
 
+const (
+	ContainerElectricStatus Container = "electricvehicle"
+	ContainerFuelStatus     Container = "fuelstatus"
+	ContainerLockStatus     Container = "vehiclelockstatus"
+	ContainerPayAsYouDrive  Container = "payasyoudrive"
+	ContainerVehicleStatus  Container = "vehiclestatus"
+)
+
+// Containers contains all containers known to the client in the order
+// they are fetched
+var Containers = []Container{
+	ContainerPayAsYouDrive,
+	ContainerFuelStatus,
+	ContainerVehicleStatus,
+	ContainerLockStatus,
+	ContainerElectricStatus,
+}
+
 const (
 	apiPrefix = "https://api.mercedes-benz.com/vehicledata/v2"
 
@@ -74,6 +108,55 @@ const (
 	oAuthScopeVehicleStatus         = "mb:vehicle:mbdata:vehiclestatus"
 )
 
+// ParseContainer validates the given name against the known containers
+func ParseContainer(name string) (Container, error) {
+	for _, c := range Containers {
+		if string(c) == name {
+			return c, nil
+		}
+	}
+
+	return "", errors.Errorf("unknown container %q", name)
+}
+
+// Fields lists all values contained in the given container struct
+// (for example FuelStatus) in their declaration order
+func Fields(container any) []Field {
+	var (
+		out []Field
+		st  = reflect.Indirect(reflect.ValueOf(container))
+	)
+
+	if st.Kind() != reflect.Struct {
+		return nil
+	}
+
+	for i := 0; i < st.NumField(); i++ {
+		name := st.Type().Field(i).Tag.Get("apiField")
+		if name == "" {
+			continue
+		}
+
+		if v, ok := st.Field(i).Interface().(MetricValue); ok {
+			out = append(out, Field{Name: name, Value: v})
+		}
+	}
+
+	return out
+}
+
+// LatestTime returns the most recent timestamp of all valid values in
+// the given container struct
+func LatestTime(container any) (latest time.Time) {
+	for _, f := range Fields(container) {
+		if f.Value.IsValid() && f.Value.Time().After(latest) {
+			latest = f.Value.Time()
+		}
+	}
+
+	return latest
+}
+
 func (g genericAPIResponse) Get(key string) *metricValue {
 	for i := range g {
 		if g[i][key] != nil {
@@ -86,6 +169,9 @@ func (g genericAPIResponse) Get(key string) *metricValue {
 
 // Bool
 
+// NewTimedBool creates a value reported at the given time
+func NewTimedBool(v bool, t time.Time) TimedBool { return TimedBool{v: v, t: t} }
+
 func (t TimedBool) Bool() bool { return t.v }
 
 func (t TimedBool) IsValid() bool { return !t.t.IsZero() }
@@ -103,32 +189,47 @@ func (t TimedBool) ToFloat() float64 {
 
 // Enum
 
+// NewTimedEnum creates a value reported at the given time, def
+// contains the names of the values
+func NewTimedEnum(v int64, def []string, t time.Time) TimedEnum {
+	return TimedEnum{v: v, def: def, t: t}
+}
+
 func (t TimedEnum) Idx() int64 { return t.v }
 
 func (t TimedEnum) IsValid() bool { return !t.t.IsZero() }
 
 func (t TimedEnum) String() string {
-	s := "n/a"
-	if len(t.def) > 0 {
-		s = t.def[t.v]
-	}
-	return fmt.Sprintf("%s (%s)", s, t.t.Format(time.RFC3339))
+	return fmt.Sprintf("%s (%s)", t.Value(), t.t.Format(time.RFC3339))
 }
 
 func (t TimedEnum) Time() time.Time { return t.t }
 
 func (t TimedEnum) ToFloat() float64 { return float64(t.v) }
 
+// Value returns the name of the enum value, values not known to the
+// definition (i.e. states added to the API later) are returned as
+// number
 func (t TimedEnum) Value() string {
-	if len(t.def) > 0 {
+	switch {
+	case len(t.def) == 0:
+		return "n/a"
+
+	case t.v < 0 || t.v >= int64(len(t.def)):
+		return strconv.FormatInt(t.v, 10)
+
+	default:
 		return t.def[t.v]
 	}
-
-	return "n/a"
 }
 
+func (t TimedEnum) Values() []string { return t.def }
+
 // Float
 
+// NewTimedFloat creates a value reported at the given time
+func NewTimedFloat(v float64, t time.Time) TimedFloat { return TimedFloat{v: v, t: t} }
+
 func (t TimedFloat) Float() float64 { return t.v }
 
 func (t TimedFloat) IsValid() bool { return !t.t.IsZero() }
@@ -141,6 +242,9 @@ func (t TimedFloat) ToFloat() float64 { return t.v }
 
 // Int
 
+// NewTimedInt creates a value reported at the given time
+func NewTimedInt(v int64, t time.Time) TimedInt { return TimedInt{v: v, t: t} }
+
 func (t TimedInt) Int() int64 { return t.v }
 
 func (t TimedInt) IsValid() bool { return !t.t.IsZero() }
@@ -0,0 +1,106 @@
+package mercedes_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/credential"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mockapi"
+)
+
+const (
+	testClientID     = "test-client"
+	testClientSecret = "test-secret"
+	testVehicleID    = "WDB111111ZZZ22222"
+)
+
+type testEnv struct {
+	api    *mockapi.Server
+	client *mercedes.APIClient
+	creds  credential.Store
+
+	containerRequests atomic.Int32
+	tokenRequests     atomic.Int32
+	// afterContainerRequest is called after the given number of
+	// container requests were handled
+	afterContainerRequest func(n int32)
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	env := &testEnv{api: mockapi.New(testClientID, testClientSecret)}
+	env.api.AddDemoVehicle(testVehicleID)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == mockapi.PathToken:
+			env.tokenRequests.Add(1)
+			env.api.ServeHTTP(w, r)
+
+		case strings.HasPrefix(r.URL.Path, mockapi.PathAPI):
+			n := env.containerRequests.Add(1)
+			env.api.ServeHTTP(w, r)
+			if env.afterContainerRequest != nil {
+				env.afterContainerRequest(n)
+			}
+
+		default:
+			env.api.ServeHTTP(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	creds, err := credential.NewJSONStore(filepath.Join(t.TempDir(), "creds.json"), testClientID, testClientSecret)
+	if err != nil {
+		t.Fatalf("creating credential store: %s", err)
+	}
+	env.creds = creds
+
+	env.client = mercedes.New(testClientID, testClientSecret, creds,
+		mercedes.WithBaseURL(srv.URL+mockapi.PathAPI),
+		mercedes.WithTokenURL(srv.URL+mockapi.PathToken),
+		mercedes.WithHTTPClient(srv.Client()),
+		mercedes.WithRetryPolicy(mercedes.RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}),
+	)
+
+	return env
+}
+
+// storeToken puts a token issued by the mock API into the credential
+// store, expiring at the given time
+func (e *testEnv) storeToken(t *testing.T, expiry time.Time) (accessToken, refreshToken string) {
+	t.Helper()
+
+	at, rt, _ := e.api.IssueToken()
+	if err := e.creds.UpdateToken(at, rt, expiry); err != nil {
+		t.Fatalf("storing token: %s", err)
+	}
+
+	return at, rt
+}
+
+func TestClientFetchesContainer(t *testing.T) {
+	env := newTestEnv(t)
+	env.storeToken(t, time.Now().Add(time.Hour))
+
+	fs, err := env.client.GetFuelStatus(context.Background(), testVehicleID)
+	if err != nil {
+		t.Fatalf("getting fuel status: %s", err)
+	}
+
+	if !fs.RangeLiquid.IsValid() || fs.RangeLiquid.Int() != 540 {
+		t.Errorf("unexpected range: %s", fs.RangeLiquid)
+	}
+
+	if n := env.tokenRequests.Load(); n != 0 {
+		t.Errorf("valid token was refreshed %d times", n)
+	}
+}
@@ -0,0 +1,23 @@
+package mercedes
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type requestCounterKey struct{}
+
+// WithRequestCounter attaches a counter to the context which is
+// incremented for every request sent to the vehicle data API while
+// using the context, retries included
+func WithRequestCounter(ctx context.Context, counter *atomic.Int64) context.Context {
+	return context.WithValue(ctx, requestCounterKey{}, counter)
+}
+
+// CountRequest increments the counter attached to the context (if any),
+// Client implementations call it for every request sent to the API
+func CountRequest(ctx context.Context) {
+	if counter, ok := ctx.Value(requestCounterKey{}).(*atomic.Int64); ok {
+		counter.Add(1)
+	}
+}
@@ -0,0 +1,26 @@
+package mercedes_test
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+)
+
+func TestClientCountsRetriedRequests(t *testing.T) {
+	env := newTestEnv(t)
+	env.storeToken(t, time.Now().Add(time.Hour))
+	env.api.SetError(testVehicleID, mercedes.ContainerFuelStatus, http.StatusBadGateway)
+
+	var requests atomic.Int64
+	if _, err := env.client.GetFuelStatus(mercedes.WithRequestCounter(context.Background(), &requests), testVehicleID); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if n := requests.Load(); n != int64(env.containerRequests.Load()) || n != 3 {
+		t.Errorf("expected 3 counted requests, got %d (server saw %d)", n, env.containerRequests.Load())
+	}
+}
@@ -1,6 +1,7 @@
 package mercedes
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/pkg/errors"
@@ -15,13 +16,13 @@ type (
 	}
 )
 
-func (a APIClient) GetElectricStatus(vehicleID string) (ElectricStatus, error) {
+func (a APIClient) GetElectricStatus(ctx context.Context, vehicleID string) (ElectricStatus, error) {
 	var (
-		path = fmt.Sprintf("/vehicles/%s/containers/electricvehicle", vehicleID)
+		path = fmt.Sprintf("/vehicles/%s/containers/%s", vehicleID, ContainerElectricStatus)
 		out  ElectricStatus
 	)
 
-	if err := a.request(path, &out); err != nil {
+	if err := a.request(ctx, path, &out); err != nil {
 		return out, errors.Wrap(err, "getting electric status")
 	}
 
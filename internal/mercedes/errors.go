@@ -0,0 +1,157 @@
+package mercedes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// HTTPError is returned when the API responds with an unexpected
+	// status code. It unwraps to one of the Err* sentinels below
+	// depending on the status code.
+	HTTPError struct {
+		StatusCode int
+		Body       string
+		RetryAfter time.Duration
+	}
+
+	// authError is returned when no valid token could be obtained
+	authError struct {
+		err error
+	}
+
+	// parseError is returned when the response could not be decoded
+	parseError struct {
+		err error
+	}
+
+	transportError struct {
+		err error
+	}
+)
+
+// Error classes returned by ErrorClass
+const (
+	ErrorClassAuth        = "auth"
+	ErrorClassHTTP        = "http"
+	ErrorClassNetwork     = "network"
+	ErrorClassNoData      = "no_data"
+	ErrorClassOther       = "other"
+	ErrorClassParse       = "parse"
+	ErrorClassRateLimited = "rate_limited"
+	ErrorClassServer      = "server"
+	ErrorClassTimeout     = "timeout"
+)
+
+var (
+	ErrNoDataAvailable = errors.New("no data available for this endpoint")
+	ErrRateLimited     = errors.New("rate limited by API")
+	ErrServer          = errors.New("API server error")
+	ErrUnauthorized    = errors.New("unauthorized to access API")
+)
+
+func newHTTPError(resp *http.Response, body []byte) HTTPError {
+	return HTTPError{
+		StatusCode: resp.StatusCode,
+		Body:       strings.TrimSpace(string(body)),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+func (h HTTPError) Error() string {
+	return fmt.Sprintf("http status code %d, body %s", h.StatusCode, h.Body)
+}
+
+func (h HTTPError) Unwrap() error {
+	switch {
+	case h.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+
+	case h.StatusCode == http.StatusUnauthorized, h.StatusCode == http.StatusForbidden:
+		return ErrUnauthorized
+
+	case h.StatusCode >= http.StatusInternalServerError:
+		return ErrServer
+
+	default:
+		return nil
+	}
+}
+
+func (a authError) Error() string { return a.err.Error() }
+
+func (a authError) Unwrap() error { return a.err }
+
+func (p parseError) Error() string { return p.err.Error() }
+
+func (p parseError) Unwrap() error { return p.err }
+
+func (t transportError) Error() string { return t.err.Error() }
+
+func (t transportError) Unwrap() error { return t.err }
+
+// ErrorClass groups errors returned by the Client into a small set of
+// classes (see ErrorClass* constants) to be used i.e. as metric label
+func ErrorClass(err error) string {
+	switch {
+	case errors.Is(err, ErrNoDataAvailable):
+		return ErrorClassNoData
+
+	case errors.Is(err, ErrUnauthorized), errors.As(err, &authError{}):
+		return ErrorClassAuth
+
+	case errors.Is(err, ErrRateLimited):
+		return ErrorClassRateLimited
+
+	case errors.Is(err, ErrServer):
+		return ErrorClassServer
+
+	case errors.As(err, &HTTPError{}):
+		return ErrorClassHTTP
+
+	case errors.As(err, &parseError{}):
+		return ErrorClassParse
+
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return ErrorClassTimeout
+
+	case errors.As(err, &transportError{}):
+		return ErrorClassNetwork
+
+	default:
+		return ErrorClassOther
+	}
+}
+
+// isRetryable tells whether the request might succeed when repeated
+func isRetryable(err error) bool {
+	return errors.Is(err, ErrRateLimited) ||
+		errors.Is(err, ErrServer) ||
+		errors.As(err, &transportError{})
+}
+
+// parseRetryAfter supports both formats of the Retry-After header:
+// delay in seconds and HTTP date
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+
+	if sec, err := strconv.Atoi(v); err == nil && sec > 0 {
+		return time.Duration(sec) * time.Second
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
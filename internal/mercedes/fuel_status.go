@@ -1,6 +1,7 @@
 package mercedes
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/pkg/errors"
@@ -13,13 +14,13 @@ type (
 	}
 )
 
-func (a APIClient) GetFuelStatus(vehicleID string) (FuelStatus, error) {
+func (a APIClient) GetFuelStatus(ctx context.Context, vehicleID string) (FuelStatus, error) {
 	var (
-		path = fmt.Sprintf("/vehicles/%s/containers/fuelstatus", vehicleID)
+		path = fmt.Sprintf("/vehicles/%s/containers/%s", vehicleID, ContainerFuelStatus)
 		out  FuelStatus
 	)
 
-	if err := a.request(path, &out); err != nil {
+	if err := a.request(ctx, path, &out); err != nil {
 		return out, errors.Wrap(err, "getting fuel status")
 	}
 
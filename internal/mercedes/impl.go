@@ -27,23 +27,38 @@ const (
 type (
 	APIClient struct {
 		clientID, clientSecret string
-		creds                  credential.Store
+		tokens                 *TokenManager
+
+		apiBaseURL, authURL, tokenURL string
+		httpClient                    *http.Client
+		retry                         RetryPolicy
 
 		validStateToken       string
 		validStateTokenExpiry time.Time
 	}
 )
 
-var ErrNoDataAvailable = errors.New("no data available for this endpoint")
-
 var _ Client = (*APIClient)(nil)
 
-func New(clientID, clientSecret string, creds credential.Store) *APIClient {
-	return &APIClient{
+func New(clientID, clientSecret string, creds credential.Store, opts ...Option) *APIClient {
+	a := &APIClient{
 		clientID:     clientID,
 		clientSecret: clientSecret,
-		creds:        creds,
+
+		apiBaseURL: apiPrefix,
+		authURL:    oAuthEndpointAuth,
+		tokenURL:   oAuthEndpointToken,
+		httpClient: http.DefaultClient,
+		retry:      defaultRetryPolicy,
+	}
+
+	for _, opt := range opts {
+		opt(a)
 	}
+
+	a.tokens = newTokenManager(a.getOauth2Config(""), creds, a.httpClient)
+
+	return a
 }
 
 func (a *APIClient) GetAuthStartURL(redirectURL string) string {
@@ -63,21 +78,24 @@ func (a *APIClient) StoreTokenFromRequest(redirectURL string, r *http.Request) e
 	}
 
 	code := r.FormValue("code")
-	tok, err := a.getOauth2Config(redirectURL).Exchange(ctx, code, oauth2.AccessTypeOffline)
+	tok, err := a.getOauth2Config(redirectURL).Exchange(a.oauth2Context(ctx), code, oauth2.AccessTypeOffline)
 	if err != nil {
 		return errors.Wrap(err, "exchanging code for token")
 	}
 
-	return errors.Wrap(a.creds.UpdateToken(tok.AccessToken, tok.RefreshToken, tok.Expiry), "updating stored token")
+	return errors.Wrap(a.tokens.Set(tok), "storing token")
 }
 
+// Tokens returns the manager of the OAuth2 token used by the client
+func (a *APIClient) Tokens() *TokenManager { return a.tokens }
+
 func (a APIClient) getOauth2Config(redirectURL string) *oauth2.Config {
 	return &oauth2.Config{
 		ClientID:     a.clientID,
 		ClientSecret: a.clientSecret,
 		Endpoint: oauth2.Endpoint{
-			AuthURL:   oAuthEndpointAuth,
-			TokenURL:  oAuthEndpointToken,
+			AuthURL:   a.authURL,
+			TokenURL:  a.tokenURL,
 			AuthStyle: oauth2.AuthStyleInHeader,
 		},
 		RedirectURL: redirectURL,
@@ -93,6 +111,12 @@ func (a APIClient) getOauth2Config(redirectURL string) *oauth2.Config {
 	}
 }
 
+// oauth2Context attaches the configured HTTP client to the context so
+// the oauth2 library uses it for token requests and API calls
+func (a APIClient) oauth2Context(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, a.httpClient)
+}
+
 func (a APIClient) parseGenericAPIResponse(data io.Reader, output any) (err error) {
 	var tmp genericAPIResponse
 	if err = json.NewDecoder(data).Decode(&tmp); err != nil {
@@ -161,12 +185,46 @@ func (a APIClient) parseGenericAPIResponse(data io.Reader, output any) (err erro
 	return nil
 }
 
-func (a APIClient) request(path string, output any) error {
-	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+func (a APIClient) request(ctx context.Context, path string, output any) (err error) {
+	for attempt := 0; ; attempt++ {
+		if err = a.doRequest(ctx, path, output); err == nil || !isRetryable(err) || attempt >= a.retry.MaxRetries {
+			return err
+		}
+
+		delay := a.retry.Backoff(attempt)
+
+		var herr HTTPError
+		if errors.As(err, &herr) && herr.RetryAfter > delay {
+			delay = herr.RetryAfter
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			// We would not be able to retry before the deadline, no need to wait
+			return errors.Wrap(err, "retry not possible before deadline")
+		}
+
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"attempt": attempt + 1,
+			"delay":   delay,
+			"path":    path,
+		}).Debug("retrying request")
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return errors.Wrap(err, "context done while waiting for retry")
+		}
+	}
+}
+
+func (a APIClient) doRequest(ctx context.Context, path string, output any) error {
+	parentCtx := ctx
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
 	defer cancel()
 
 	url := strings.Join([]string{
-		strings.TrimRight(apiPrefix, "/"),
+		strings.TrimRight(a.apiBaseURL, "/"),
 		strings.TrimLeft(path, "/"),
 	}, "/")
 
@@ -178,29 +236,20 @@ func (a APIClient) request(path string, output any) error {
 	}
 	req.Header.Set("accept", "application/json;charset=utf-8")
 
-	at, rt, exp, err := a.creds.GetToken()
+	tok, err := a.tokens.Token(ctx)
 	if err != nil {
-		return errors.Wrap(err, "getting credentials")
+		return errors.Wrap(err, "getting token")
 	}
-	tok := &oauth2.Token{AccessToken: at, RefreshToken: rt, Expiry: exp}
-
-	// Renew token if required
-	if tok.Expiry.Add(tokenGraceRenew).Before(time.Now()) {
-		src := a.getOauth2Config("").TokenSource(ctx, tok)
-		if tok, err = src.Token(); err != nil {
-			return errors.Wrap(err, "renewing token")
-		}
+	tok.SetAuthHeader(req)
 
-		if tok.AccessToken != at || tok.RefreshToken != rt || !tok.Expiry.Equal(exp) {
-			if err := a.creds.UpdateToken(tok.AccessToken, tok.RefreshToken, tok.Expiry); err != nil {
-				return errors.Wrap(err, "updating stored token")
-			}
-		}
-	}
-
-	resp, err := a.getOauth2Config("").Client(ctx, tok).Do(req)
+	CountRequest(ctx)
+	resp, err := a.httpClient.Do(req)
 	if err != nil {
-		return errors.Wrap(err, "executing request")
+		if parentCtx.Err() != nil {
+			// The caller gave up, retrying makes no sense
+			return errors.Wrap(err, "executing request")
+		}
+		return errors.Wrap(transportError{err}, "executing request")
 	}
 	defer resp.Body.Close()
 
@@ -211,14 +260,18 @@ func (a APIClient) request(path string, output any) error {
 	if resp.StatusCode != http.StatusOK {
 		body, err := io.ReadAll(resp.Body)
 		if err != nil {
-			return errors.Wrapf(err, "http status code %d, error reading body", resp.StatusCode)
+			return errors.Wrapf(transportError{err}, "http status code %d, error reading body", resp.StatusCode)
 		}
-		return errors.Errorf("http status code %d, body %s", resp.StatusCode, body)
+		return newHTTPError(resp, body)
 	}
 
 	if output == nil {
 		return nil
 	}
 
-	return errors.Wrap(a.parseGenericAPIResponse(resp.Body, output), "decoding output")
+	if err = a.parseGenericAPIResponse(resp.Body, output); err != nil {
+		return errors.Wrap(parseError{err}, "decoding output")
+	}
+
+	return nil
 }
@@ -1,6 +1,7 @@
 package mercedes
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/pkg/errors"
@@ -19,13 +20,13 @@ type (
 	}
 )
 
-func (a APIClient) GetLockStatus(vehicleID string) (LockStatus, error) {
+func (a APIClient) GetLockStatus(ctx context.Context, vehicleID string) (LockStatus, error) {
 	var (
-		path = fmt.Sprintf("/vehicles/%s/containers/vehiclelockstatus", vehicleID)
+		path = fmt.Sprintf("/vehicles/%s/containers/%s", vehicleID, ContainerLockStatus)
 		out  LockStatus
 	)
 
-	if err := a.request(path, &out); err != nil {
+	if err := a.request(ctx, path, &out); err != nil {
 		return out, errors.Wrap(err, "getting lock status")
 	}
 
@@ -0,0 +1,44 @@
+package mercedes
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/selfmetrics"
+)
+
+const (
+	metricsNamespace = "mercedes_byocar"
+	metricsSubsystem = "token"
+)
+
+var (
+	tokenExpiry = selfmetrics.Factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "expiry_timestamp_seconds",
+		Help:      "Expiry of the currently used access token",
+	})
+
+	tokenRefreshes = selfmetrics.Factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "refreshes_total",
+		Help:      "Number of access token refreshes by result (success, failure)",
+	}, []string{"result"})
+)
+
+func init() {
+	for _, result := range []string{"success", "failure"} {
+		tokenRefreshes.WithLabelValues(result).Add(0)
+	}
+}
+
+func setTokenExpiry(expiry time.Time) {
+	if expiry.IsZero() {
+		return
+	}
+
+	tokenExpiry.Set(float64(expiry.UnixNano()) / float64(time.Second))
+}
@@ -0,0 +1,34 @@
+package mercedes
+
+import "net/http"
+
+type (
+	// Option configures the APIClient when passed to New
+	Option func(*APIClient)
+)
+
+// WithAuthURL replaces the OAuth2 authorization endpoint
+func WithAuthURL(u string) Option {
+	return func(a *APIClient) { a.authURL = u }
+}
+
+// WithBaseURL replaces the base URL of the vehicle data API
+func WithBaseURL(u string) Option {
+	return func(a *APIClient) { a.apiBaseURL = u }
+}
+
+// WithHTTPClient sets the HTTP client used for API and token requests
+func WithHTTPClient(c *http.Client) Option {
+	return func(a *APIClient) { a.httpClient = c }
+}
+
+// WithRetryPolicy replaces the default policy for retrying requests
+// failing with network errors, server errors or rate limiting
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(a *APIClient) { a.retry = p }
+}
+
+// WithTokenURL replaces the OAuth2 token endpoint
+func WithTokenURL(u string) Option {
+	return func(a *APIClient) { a.tokenURL = u }
+}
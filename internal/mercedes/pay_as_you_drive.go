@@ -1,6 +1,7 @@
 package mercedes
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/pkg/errors"
@@ -12,13 +13,13 @@ type (
 	}
 )
 
-func (a APIClient) GetPayAsYouDriveInsurance(vehicleID string) (PayAsYouDriveInsurance, error) {
+func (a APIClient) GetPayAsYouDriveInsurance(ctx context.Context, vehicleID string) (PayAsYouDriveInsurance, error) {
 	var (
-		path = fmt.Sprintf("/vehicles/%s/containers/payasyoudrive", vehicleID)
+		path = fmt.Sprintf("/vehicles/%s/containers/%s", vehicleID, ContainerPayAsYouDrive)
 		out  PayAsYouDriveInsurance
 	)
 
-	if err := a.request(path, &out); err != nil {
+	if err := a.request(ctx, path, &out); err != nil {
 		return out, errors.Wrap(err, "getting pay-as-you-drive response")
 	}
 
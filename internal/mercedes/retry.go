@@ -0,0 +1,51 @@
+package mercedes
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultRetryBaseDelay = time.Second
+	defaultRetryMaxDelay  = 30 * time.Second
+	defaultRetryMax       = 3
+)
+
+type (
+	// RetryPolicy configures how failed requests are repeated
+	RetryPolicy struct {
+		// MaxRetries is the number of retries after the first attempt
+		MaxRetries int
+		// BaseDelay is the delay before the first retry, doubled on
+		// every further retry
+		BaseDelay time.Duration
+		// MaxDelay caps the exponential backoff
+		MaxDelay time.Duration
+	}
+)
+
+var defaultRetryPolicy = RetryPolicy{
+	MaxRetries: defaultRetryMax,
+	BaseDelay:  defaultRetryBaseDelay,
+	MaxDelay:   defaultRetryMaxDelay,
+}
+
+// Backoff returns the jittered delay before the given retry (0-based)
+// using the "equal jitter" strategy: half of the exponential delay is
+// fixed, the other half is random.
+func (r RetryPolicy) Backoff(retry int) time.Duration {
+	d := r.BaseDelay
+	for i := 0; i < retry && d < r.MaxDelay; i++ {
+		d *= 2
+	}
+
+	if r.MaxDelay > 0 && d > r.MaxDelay {
+		d = r.MaxDelay
+	}
+
+	if d <= 0 {
+		return 0
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1)) //nolint:gosec // Jitter does not need to be cryptographically secure
+}
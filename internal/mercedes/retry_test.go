@@ -0,0 +1,70 @@
+package mercedes_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+)
+
+func TestClientRetriesTemporaryErrors(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusBadGateway} {
+		status := status
+
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			t.Parallel()
+
+			env := newTestEnv(t)
+			env.storeToken(t, time.Now().Add(time.Hour))
+
+			env.api.SetError(testVehicleID, mercedes.ContainerFuelStatus, status)
+			env.afterContainerRequest = func(n int32) {
+				if n == 1 {
+					env.api.SetError(testVehicleID, mercedes.ContainerFuelStatus, 0)
+				}
+			}
+
+			if _, err := env.client.GetFuelStatus(context.Background(), testVehicleID); err != nil {
+				t.Fatalf("getting fuel status: %s", err)
+			}
+
+			if n := env.containerRequests.Load(); n != 2 {
+				t.Errorf("expected 2 requests, got %d", n)
+			}
+		})
+	}
+}
+
+func TestClientGivesUpAfterMaxRetries(t *testing.T) {
+	env := newTestEnv(t)
+	env.storeToken(t, time.Now().Add(time.Hour))
+	env.api.SetError(testVehicleID, mercedes.ContainerFuelStatus, http.StatusBadGateway)
+
+	_, err := env.client.GetFuelStatus(context.Background(), testVehicleID)
+	if !errors.Is(err, mercedes.ErrServer) {
+		t.Fatalf("expected server error, got %v", err)
+	}
+
+	if n := env.containerRequests.Load(); n != 3 {
+		t.Errorf("expected 3 requests, got %d", n)
+	}
+}
+
+func TestClientDoesNotRetryClientErrors(t *testing.T) {
+	env := newTestEnv(t)
+	env.storeToken(t, time.Now().Add(time.Hour))
+	env.api.SetError(testVehicleID, mercedes.ContainerFuelStatus, http.StatusNotFound)
+
+	_, err := env.client.GetFuelStatus(context.Background(), testVehicleID)
+	if mercedes.ErrorClass(err) != mercedes.ErrorClassHTTP {
+		t.Fatalf("expected http error, got %v", err)
+	}
+
+	if n := env.containerRequests.Load(); n != 1 {
+		t.Errorf("expected 1 request, got %d", n)
+	}
+}
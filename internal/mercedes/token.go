@@ -0,0 +1,267 @@
+package mercedes
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/credential"
+)
+
+const (
+	// tokenRenewBefore is how long before expiry the background refresh
+	// renews the token, requests only renew it inline (tokenGraceRenew)
+	// when the background refresh did not succeed
+	tokenRenewBefore = 10 * time.Minute
+	// tokenRetryDelay is the delay between background attempts while
+	// refreshing fails or no token is stored
+	tokenRetryDelay = time.Minute
+)
+
+type (
+	// TokenManager owns the OAuth2 token: it keeps the token in memory,
+	// refreshes it in the background before it expires and persists
+	// refreshed tokens in the credential store
+	TokenManager struct {
+		conf       *oauth2.Config
+		creds      credential.Store
+		httpClient *http.Client
+
+		// refreshLock serializes refreshes so concurrent requests do not
+		// use the (rotating) refresh token twice
+		refreshLock sync.Mutex
+
+		lock  sync.RWMutex
+		state TokenState
+		token *oauth2.Token
+
+		wake chan struct{}
+	}
+
+	// TokenState describes the token held by the TokenManager
+	TokenState struct {
+		// CredentialsChecked is the time the credential store was last
+		// checked for a stored token, zero before the first check
+		CredentialsChecked time.Time
+		// CredentialsError is the error of the last check of the store
+		CredentialsError error
+		// HasCredentials tells whether the store held a token on the last
+		// check (or a token was stored since)
+		HasCredentials bool
+
+		Expiry          time.Time
+		HasToken        bool
+		HasRefreshToken bool
+		// LastError is the error of the last refresh, reset on success
+		LastError   error
+		LastRefresh time.Time
+		NextRefresh time.Time
+	}
+)
+
+func newTokenManager(conf *oauth2.Config, creds credential.Store, httpClient *http.Client) *TokenManager {
+	return &TokenManager{
+		conf:       conf,
+		creds:      creds,
+		httpClient: httpClient,
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// Run refreshes the token in the background before it expires until
+// the context is cancelled
+func (t *TokenManager) Run(ctx context.Context) {
+	for {
+		wait := tokenRetryDelay
+
+		authorized, err := t.creds.HasCredentials()
+		t.setCredentials(authorized, err)
+
+		switch {
+		case err != nil:
+			logrus.WithError(err).Warn("checking for stored token")
+
+		case !authorized:
+			// Nothing to refresh, woken up when a token is stored
+
+		default:
+			tok, err := t.refresh(ctx, tokenRenewBefore)
+			if err != nil {
+				logrus.WithError(err).Warn("refreshing token in background")
+				break
+			}
+
+			if !tok.Expiry.IsZero() {
+				wait = time.Until(tok.Expiry.Add(-tokenRenewBefore))
+			}
+		}
+
+		if wait < tokenRetryDelay {
+			// Do not hammer the token endpoint for short-lived tokens
+			wait = tokenRetryDelay
+		}
+
+		t.lock.Lock()
+		t.state.NextRefresh = time.Now().Add(wait)
+		t.lock.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+
+		case <-t.wake:
+			timer.Stop()
+
+		case <-timer.C:
+		}
+	}
+}
+
+// Set stores a new token (i.e. after authorization) and persists it
+func (t *TokenManager) Set(tok *oauth2.Token) error {
+	t.refreshLock.Lock()
+	defer t.refreshLock.Unlock()
+
+	if err := t.creds.UpdateToken(tok.AccessToken, tok.RefreshToken, tok.Expiry); err != nil {
+		return errors.Wrap(err, "updating stored token")
+	}
+
+	t.setToken(tok, true)
+	t.setCredentials(true, nil)
+
+	select {
+	case t.wake <- struct{}{}:
+	default:
+		// Already scheduled to wake up
+	}
+
+	return nil
+}
+
+// State returns the current state of the token
+func (t *TokenManager) State() TokenState {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.state
+}
+
+// Token returns a token valid for at least the grace period, refreshing
+// it if required
+func (t *TokenManager) Token(ctx context.Context) (*oauth2.Token, error) {
+	t.lock.RLock()
+	tok := t.token
+	t.lock.RUnlock()
+
+	if tok != nil && !needsRenew(tok, -tokenGraceRenew) {
+		return tok, nil
+	}
+
+	return t.refresh(ctx, -tokenGraceRenew)
+}
+
+// refresh renews the token unless it is valid for longer than
+// minValidity. The stored token is read first as it might have been
+// refreshed by another caller or another instance sharing the store.
+func (t *TokenManager) refresh(ctx context.Context, minValidity time.Duration) (*oauth2.Token, error) {
+	t.refreshLock.Lock()
+	defer t.refreshLock.Unlock()
+
+	at, rt, exp, err := t.creds.GetToken()
+	if err != nil {
+		t.setError(err)
+		return nil, errors.Wrap(authError{err}, "getting credentials")
+	}
+	tok := &oauth2.Token{AccessToken: at, RefreshToken: rt, Expiry: exp}
+
+	t.lock.RLock()
+	if t.token != nil && t.token.Expiry.After(tok.Expiry) {
+		// Persisting the last refresh failed, the token in memory is the
+		// only one still valid
+		tok = t.token
+	}
+	t.lock.RUnlock()
+
+	if !needsRenew(tok, minValidity) {
+		t.setToken(tok, false)
+		return tok, nil
+	}
+
+	if tok.RefreshToken == "" {
+		err = errors.New("no refresh token available, authorization required")
+		t.setError(err)
+		return nil, authError{err}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	// Without access token the token source refreshes immediately
+	newTok, err := t.conf.TokenSource(
+		context.WithValue(ctx, oauth2.HTTPClient, t.httpClient),
+		&oauth2.Token{RefreshToken: tok.RefreshToken},
+	).Token()
+	if err != nil {
+		tokenRefreshes.WithLabelValues("failure").Inc()
+		t.setError(err)
+		return nil, errors.Wrap(authError{err}, "renewing token")
+	}
+	tokenRefreshes.WithLabelValues("success").Inc()
+
+	t.setToken(newTok, true)
+	logrus.WithField("expiry", newTok.Expiry).Debug("access token refreshed")
+
+	if err = t.creds.UpdateToken(newTok.AccessToken, newTok.RefreshToken, newTok.Expiry); err != nil {
+		// The token is kept in memory and persisting is retried on the
+		// next refresh
+		t.setError(errors.Wrap(err, "updating stored token"))
+		logrus.WithError(err).Error("updating stored token")
+	}
+
+	return newTok, nil
+}
+
+func (t *TokenManager) setCredentials(authorized bool, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.state.CredentialsChecked = time.Now()
+	t.state.CredentialsError = err
+	t.state.HasCredentials = authorized
+}
+
+func (t *TokenManager) setError(err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.state.LastError = err
+}
+
+func (t *TokenManager) setToken(tok *oauth2.Token, refreshed bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.token = tok
+	t.state.Expiry = tok.Expiry
+	t.state.HasToken = tok.AccessToken != ""
+	t.state.HasRefreshToken = tok.RefreshToken != ""
+	t.state.LastError = nil
+	if refreshed {
+		t.state.LastRefresh = time.Now()
+	}
+
+	setTokenExpiry(tok.Expiry)
+}
+
+// needsRenew tells whether the token expires within minValidity,
+// tokens without expiry never need to be renewed
+func needsRenew(tok *oauth2.Token, minValidity time.Duration) bool {
+	return tok.AccessToken == "" || (!tok.Expiry.IsZero() && tok.Expiry.Add(-minValidity).Before(time.Now()))
+}
@@ -0,0 +1,66 @@
+package mercedes_test
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestClientRefreshesExpiredToken(t *testing.T) {
+	env := newTestEnv(t)
+	oldAT, oldRT := env.storeToken(t, time.Now().Add(-time.Minute))
+
+	if _, err := env.client.GetFuelStatus(context.Background(), testVehicleID); err != nil {
+		t.Fatalf("getting fuel status: %s", err)
+	}
+
+	if n := env.tokenRequests.Load(); n != 1 {
+		t.Errorf("expected one token refresh, got %d", n)
+	}
+
+	at, rt, expiry, err := env.creds.GetToken()
+	if err != nil {
+		t.Fatalf("reading stored token: %s", err)
+	}
+
+	if at == oldAT || rt == oldRT {
+		t.Error("refreshed token was not stored")
+	}
+
+	if !expiry.After(time.Now()) {
+		t.Errorf("stored token expired at %s", expiry)
+	}
+
+	// The refreshed token is used without further refreshes
+	if _, err = env.client.GetFuelStatus(context.Background(), testVehicleID); err != nil {
+		t.Fatalf("getting fuel status with refreshed token: %s", err)
+	}
+
+	if n := env.tokenRequests.Load(); n != 1 {
+		t.Errorf("expected no further token refresh, got %d refreshes", n)
+	}
+}
+
+func TestTokenManagerCachesCredentialState(t *testing.T) {
+	env := newTestEnv(t)
+	tokens := env.client.Tokens()
+
+	if st := tokens.State(); !st.CredentialsChecked.IsZero() {
+		t.Fatalf("expected store not to be checked before running, got %+v", st)
+	}
+
+	// A canceled context stops the background refresh after one check
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tokens.Run(ctx)
+	if st := tokens.State(); st.CredentialsChecked.IsZero() || st.HasCredentials || st.CredentialsError != nil {
+		t.Errorf("expected checked store without credentials, got %+v", st)
+	}
+
+	env.storeToken(t, time.Now().Add(time.Hour))
+	tokens.Run(ctx)
+	if st := tokens.State(); !st.HasCredentials || !st.HasToken {
+		t.Errorf("expected stored credentials and loaded token, got %+v", st)
+	}
+}
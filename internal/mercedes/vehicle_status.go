@@ -1,6 +1,7 @@
 package mercedes
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/pkg/errors"
@@ -43,13 +44,13 @@ type (
 	}
 )
 
-func (a APIClient) GetVehicleStatus(vehicleID string) (VehicleStatus, error) {
+func (a APIClient) GetVehicleStatus(ctx context.Context, vehicleID string) (VehicleStatus, error) {
 	var (
-		path = fmt.Sprintf("/vehicles/%s/containers/vehiclestatus", vehicleID)
+		path = fmt.Sprintf("/vehicles/%s/containers/%s", vehicleID, ContainerVehicleStatus)
 		out  VehicleStatus
 	)
 
-	if err := a.request(path, &out); err != nil {
+	if err := a.request(ctx, path, &out); err != nil {
 		return out, errors.Wrap(err, "getting vehicle status")
 	}
 
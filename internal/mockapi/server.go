@@ -0,0 +1,286 @@
+package mockapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+)
+
+const (
+	// PathAPI is the prefix of the vehicle data API
+	PathAPI = "/vehicledata/v2"
+	// PathAuth is the OAuth2 authorization endpoint
+	PathAuth = "/v1/auth"
+	// PathToken is the OAuth2 token endpoint
+	PathToken = "/v1/token"
+
+	defaultTokenLifetime = time.Hour
+)
+
+type (
+	// Server implements the BYOCAR API containers and the OAuth2 flow
+	// required to authorize against them
+	Server struct {
+		ClientID, ClientSecret string
+		TokenLifetime          time.Duration
+
+		lock          sync.RWMutex
+		accessTokens  map[string]time.Time
+		codes         map[string]bool
+		data          map[string]map[mercedes.Container]map[string]value
+		errors        map[string]map[mercedes.Container]int
+		refreshTokens map[string]bool
+	}
+
+	value struct {
+		Value     string `json:"value"`
+		Timestamp int64  `json:"timestamp"`
+	}
+)
+
+var _ http.Handler = (*Server)(nil)
+
+// New creates an empty Server accepting the given client credentials
+func New(clientID, clientSecret string) *Server {
+	return &Server{
+		ClientID:      clientID,
+		ClientSecret:  clientSecret,
+		TokenLifetime: defaultTokenLifetime,
+
+		accessTokens:  make(map[string]time.Time),
+		codes:         make(map[string]bool),
+		data:          make(map[string]map[mercedes.Container]map[string]value),
+		errors:        make(map[string]map[mercedes.Container]int),
+		refreshTokens: make(map[string]bool),
+	}
+}
+
+// AddDemoVehicle fills all containers of the vehicle with plausible values
+func (s *Server) AddDemoVehicle(vehicleID string) {
+	now := time.Now()
+
+	for container, fields := range map[mercedes.Container]map[string]string{
+		mercedes.ContainerElectricStatus: {"soc": "78", "rangeelectric": "312"},
+		mercedes.ContainerFuelStatus:     {"rangeliquid": "540", "tanklevelpercent": "64"},
+		mercedes.ContainerLockStatus: {
+			"doorlockstatusdecklid": "false",
+			"doorlockstatusvehicle": "2",
+			"doorlockstatusgas":     "false",
+			"positionHeading":       "128.4",
+		},
+		mercedes.ContainerPayAsYouDrive: {"odo": "48213"},
+		mercedes.ContainerVehicleStatus: {
+			"decklidstatus":          "false",
+			"doorstatusfrontleft":    "false",
+			"doorstatusfrontright":   "false",
+			"doorstatusrearleft":     "false",
+			"doorstatusrearright":    "false",
+			"interiorLightsFront":    "false",
+			"interiorLightsRear":     "false",
+			"lightswitchposition":    "0",
+			"readingLampFrontLeft":   "false",
+			"readingLampFrontRight":  "false",
+			"rooftopstatus":          "2",
+			"sunroofstatus":          "0",
+			"windowstatusfrontleft":  "2",
+			"windowstatusfrontright": "2",
+			"windowstatusrearleft":   "2",
+			"windowstatusrearright":  "2",
+		},
+	} {
+		for field, v := range fields {
+			s.SetValue(vehicleID, container, field, v, now)
+		}
+	}
+}
+
+// IssueToken creates a valid token pair without going through the
+// authorization flow
+func (s *Server) IssueToken() (accessToken, refreshToken string, expiry time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.issueToken()
+}
+
+// SetError makes the container respond with the given HTTP status
+// code until it is reset by passing 0
+func (s *Server) SetError(vehicleID string, container mercedes.Container, status int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.errors[vehicleID] == nil {
+		s.errors[vehicleID] = make(map[mercedes.Container]int)
+	}
+
+	if status == 0 {
+		delete(s.errors[vehicleID], container)
+		return
+	}
+
+	s.errors[vehicleID][container] = status
+}
+
+// SetValue stores a value for the field reported at the given time
+func (s *Server) SetValue(vehicleID string, container mercedes.Container, field, v string, t time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.data[vehicleID] == nil {
+		s.data[vehicleID] = make(map[mercedes.Container]map[string]value)
+	}
+	if s.data[vehicleID][container] == nil {
+		s.data[vehicleID][container] = make(map[string]value)
+	}
+
+	s.data[vehicleID][container][field] = value{Value: v, Timestamp: t.UnixMilli()}
+}
+
+// ServeHTTP dispatches the request to the API or OAuth2 handlers
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == PathAuth:
+		s.handleAuth(w, r)
+
+	case r.URL.Path == PathToken:
+		s.handleToken(w, r)
+
+	case strings.HasPrefix(r.URL.Path, PathAPI+"/"):
+		s.handleContainer(w, r)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) handleAuth(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("client_id") != s.ClientID {
+		http.Error(w, "invalid client_id", http.StatusBadRequest)
+		return
+	}
+
+	redirect, err := url.Parse(r.FormValue("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code := uuid.Must(uuid.NewV4()).String()
+
+	s.lock.Lock()
+	s.codes[code] = true
+	s.lock.Unlock()
+
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", r.FormValue("state"))
+	redirect.RawQuery = params.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) handleContainer(w http.ResponseWriter, r *http.Request) {
+	// vehicles/{vehicleID}/containers/{container}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, PathAPI+"/"), "/")
+	if len(parts) != 4 || parts[0] != "vehicles" || parts[2] != "containers" {
+		http.NotFound(w, r)
+		return
+	}
+	vehicleID, container := parts[1], mercedes.Container(parts[3])
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	expiry, ok := s.accessTokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	if !ok || expiry.Before(time.Now()) {
+		http.Error(w, `{"errorCode":"401","errorMessage":"invalid or expired token"}`, http.StatusUnauthorized)
+		return
+	}
+
+	if status := s.errors[vehicleID][container]; status != 0 {
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "1")
+		}
+		http.Error(w, fmt.Sprintf(`{"errorCode":"%d"}`, status), status)
+		return
+	}
+
+	fields := s.data[vehicleID][container]
+	if len(fields) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	resp := make([]map[string]value, 0, len(fields))
+	for name, v := range fields {
+		resp = append(resp, map[string]value{name: v})
+	}
+
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	json.NewEncoder(w).Encode(resp) //nolint:errchkjson,errcheck // Nothing to do when the client went away
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if id, secret, ok := r.BasicAuth(); !ok || id != s.ClientID || secret != s.ClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	switch r.FormValue("grant_type") {
+	case "authorization_code":
+		if !s.codes[r.FormValue("code")] {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		delete(s.codes, r.FormValue("code"))
+
+	case "refresh_token":
+		if !s.refreshTokens[r.FormValue("refresh_token")] {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		// Refresh tokens are rotated on every use
+		delete(s.refreshTokens, r.FormValue("refresh_token"))
+
+	default:
+		http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+		return
+	}
+
+	at, rt, _ := s.issueToken()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{ //nolint:errchkjson,errcheck // Nothing to do when the client went away
+		"access_token":  at,
+		"expires_in":    int(s.TokenLifetime / time.Second),
+		"refresh_token": rt,
+		"token_type":    "Bearer",
+	})
+}
+
+func (s *Server) issueToken() (accessToken, refreshToken string, expiry time.Time) {
+	accessToken = uuid.Must(uuid.NewV4()).String()
+	refreshToken = uuid.Must(uuid.NewV4()).String()
+	expiry = time.Now().Add(s.TokenLifetime)
+
+	s.accessTokens[accessToken] = expiry
+	s.refreshTokens[refreshToken] = true
+
+	return accessToken, refreshToken, expiry
+}
@@ -0,0 +1,28 @@
+package scheduler
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/selfmetrics"
+)
+
+const (
+	metricsNamespace = "mercedes_byocar"
+	metricsSubsystem = "scheduler"
+)
+
+var (
+	nextInterval = selfmetrics.Factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "interval_seconds",
+		Help:      "Currently planned interval between two fetch cycles",
+	})
+
+	quotaRemaining = selfmetrics.Factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "quota_remaining_requests",
+		Help:      "Remaining API requests in the daily budget (UTC day)",
+	})
+)
@@ -0,0 +1,294 @@
+package scheduler
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	// maxUnchangedDoublings limits how often the interval is doubled
+	// when the API keeps reporting the same data
+	maxUnchangedDoublings = 4
+	// quotaDayPadding is added when waiting for the quota to reset
+	quotaDayPadding = time.Minute
+)
+
+type (
+	// Options configure the Planner
+	Options struct {
+		// DailyQuota is the number of API requests allowed per (UTC) day,
+		// zero disables budgeting
+		DailyQuota int
+		// MinInterval is the shortest interval between two cycles
+		MinInterval time.Duration
+		// MaxInterval caps the slowdown applied overnight and when the
+		// data did not change (the quota still might enforce longer
+		// intervals)
+		MaxInterval time.Duration
+		// QuietHours is a range of local hours ("22-6") in which the
+		// interval is multiplied by the QuietFactor
+		QuietHours  string
+		QuietFactor int
+	}
+
+	// Planner is a cron.Schedule computing the next fetch cycle from the
+	// remaining request budget and the freshness of the fetched data
+	Planner struct {
+		opts Options
+
+		quietStart, quietEnd int
+
+		lock             sync.Mutex
+		requestsPerCycle int
+		day              time.Time
+		used             int
+		lastData         time.Time
+		unchangedCycles  int
+		// pending is set when a cycle was scheduled but not yet recorded,
+		// its requests are charged in advance when computing the budget
+		pending bool
+	}
+)
+
+var _ cron.Schedule = (*Planner)(nil)
+
+// New creates a Planner for cycles issuing the given number of requests
+func New(opts Options, requestsPerCycle int) (*Planner, error) {
+	p := &Planner{
+		opts:             opts,
+		quietStart:       -1,
+		quietEnd:         -1,
+		requestsPerCycle: requestsPerCycle,
+	}
+
+	if p.opts.MaxInterval < p.opts.MinInterval {
+		p.opts.MaxInterval = p.opts.MinInterval
+	}
+
+	if p.opts.QuietFactor < 1 {
+		p.opts.QuietFactor = 1
+	}
+
+	if opts.QuietHours != "" {
+		var err error
+		if p.quietStart, p.quietEnd, err = ParseQuietHours(opts.QuietHours); err != nil {
+			return nil, errors.Wrap(err, "parsing quiet hours")
+		}
+	}
+
+	return p, nil
+}
+
+// ParseQuietHours parses a range of hours like "22-6" into start
+// (inclusive) and end (exclusive) hour
+func ParseQuietHours(s string) (start, end int, err error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 { //nolint:gomnd // Start and end
+		return 0, 0, errors.Errorf("expected format <start>-<end>, got %q", s)
+	}
+
+	if start, err = strconv.Atoi(strings.TrimSpace(parts[0])); err != nil || start < 0 || start > 23 {
+		return 0, 0, errors.Errorf("invalid start hour %q", parts[0])
+	}
+
+	if end, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil || end < 0 || end > 24 {
+		return 0, 0, errors.Errorf("invalid end hour %q", parts[1])
+	}
+
+	return start, end, nil
+}
+
+// CurrentInterval returns the delay until the next cycle when asked
+// at t without modifying the planner state or its metrics
+func (p *Planner) CurrentInterval(t time.Time) time.Duration {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.interval(t)
+}
+
+// Interval computes the delay until the next cycle when asked at t
+func (p *Planner) Interval(t time.Time) time.Duration {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.resetDay(t)
+
+	interval := p.interval(t)
+	nextInterval.Set(interval.Seconds())
+	return interval
+}
+
+// Next implements cron.Schedule. The cron calls it when starting the
+// cycle at t (and when the job is added, right before the initial
+// cycle), so the requests of that cycle are charged until it is
+// recorded.
+func (p *Planner) Next(t time.Time) time.Time {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.resetDay(t)
+	p.pending = true
+
+	interval := p.interval(t)
+	nextInterval.Set(interval.Seconds())
+	return t.Add(interval)
+}
+
+// RecordCycle accounts the requests issued in a cycle against the
+// budget and tracks whether the API reported new data. It must be
+// called for every cycle, also those without requests.
+func (p *Planner) RecordCycle(t time.Time, requests int, latestData time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.resetDay(t)
+	p.used += requests
+	p.pending = false
+
+	switch {
+	case latestData.After(p.lastData):
+		p.lastData = latestData
+		p.unchangedCycles = 0
+
+	case requests > 0:
+		p.unchangedCycles++
+
+	default:
+		// Nothing was due (or all requests were skipped), the cycle tells
+		// nothing about whether the data changed
+	}
+
+	if p.opts.DailyQuota > 0 {
+		quotaRemaining.Set(float64(p.remaining()))
+	}
+}
+
+// Remaining returns the number of requests left for the day of t or
+// -1 when no quota is configured
+func (p *Planner) Remaining(t time.Time) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.resetDay(t)
+	return p.remaining()
+}
+
+// SetRequestsPerCycle updates the number of requests issued in each
+// cycle (i.e. after the vehicle list changed)
+func (p *Planner) SetRequestsPerCycle(requests int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.requestsPerCycle = requests
+}
+
+// budgetInterval spreads the requests remaining in the day of t evenly
+// across the rest of that day
+func (p *Planner) budgetInterval(t time.Time) time.Duration {
+	if p.opts.DailyQuota <= 0 || p.requestsPerCycle <= 0 {
+		return 0
+	}
+
+	used := p.used
+	day := quotaDay(t)
+	if !day.Equal(p.day) {
+		// Day was not yet reset, the quota of the new day is untouched
+		used = 0
+	}
+	if p.pending {
+		used += p.requestsPerCycle
+	}
+
+	remaining := p.opts.DailyQuota - used
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	var (
+		leftInDay = day.Add(24 * time.Hour).Sub(t.UTC()) //nolint:gomnd // Hours per day
+		cycles    = remaining / p.requestsPerCycle
+	)
+
+	if cycles < 1 {
+		// Budget exhausted, wait for the quota to reset
+		return leftInDay + quotaDayPadding
+	}
+
+	return leftInDay / time.Duration(cycles)
+}
+
+// interval computes the delay until the next cycle from the current
+// state without modifying it
+func (p *Planner) interval(t time.Time) time.Duration {
+	interval := p.opts.MinInterval
+	if p.isQuiet(t) {
+		interval *= time.Duration(p.opts.QuietFactor)
+	}
+
+	doublings := p.unchangedCycles
+	if doublings > maxUnchangedDoublings {
+		doublings = maxUnchangedDoublings
+	}
+	interval <<= doublings
+
+	if interval > p.opts.MaxInterval {
+		interval = p.opts.MaxInterval
+	}
+
+	if budget := p.budgetInterval(t); budget > interval {
+		interval = budget
+	}
+
+	return interval
+}
+
+func (p *Planner) isQuiet(t time.Time) bool {
+	if p.quietStart < 0 {
+		return false
+	}
+
+	h := t.Local().Hour()
+	if p.quietStart <= p.quietEnd {
+		return h >= p.quietStart && h < p.quietEnd
+	}
+
+	// Range wraps around midnight
+	return h >= p.quietStart || h < p.quietEnd
+}
+
+func (p *Planner) remaining() int {
+	if p.opts.DailyQuota <= 0 {
+		return -1
+	}
+
+	if r := p.opts.DailyQuota - p.used; r > 0 {
+		return r
+	}
+
+	return 0
+}
+
+func (p *Planner) resetDay(t time.Time) {
+	day := quotaDay(t)
+	if day.Equal(p.day) {
+		return
+	}
+
+	p.day = day
+	p.used = 0
+
+	if p.opts.DailyQuota > 0 {
+		quotaRemaining.Set(float64(p.opts.DailyQuota))
+	}
+}
+
+// quotaDay returns the start of the (UTC) quota day t belongs to
+func quotaDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour) //nolint:gomnd // Hours per day
+}
@@ -0,0 +1,222 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPlannerQuietHours(t *testing.T) {
+	p, err := New(Options{
+		MinInterval: 5 * time.Minute,
+		MaxInterval: time.Hour,
+		QuietHours:  "22-6",
+		QuietFactor: 3,
+	}, 5)
+	if err != nil {
+		t.Fatalf("creating planner: %s", err)
+	}
+
+	for _, tc := range []struct {
+		hour, minute int
+		expect       time.Duration
+	}{
+		{21, 59, 5 * time.Minute},
+		{22, 0, 15 * time.Minute},
+		{23, 30, 15 * time.Minute},
+		{0, 0, 15 * time.Minute},
+		{3, 0, 15 * time.Minute},
+		{5, 59, 15 * time.Minute},
+		{6, 0, 5 * time.Minute},
+		{12, 0, 5 * time.Minute},
+	} {
+		at := time.Date(2023, 5, 1, tc.hour, tc.minute, 0, 0, time.Local)
+		if got := p.Interval(at); got != tc.expect {
+			t.Errorf("expected %s at %s, got %s", tc.expect, at.Format("15:04"), got)
+		}
+	}
+}
+
+func TestPlannerSlowsDownOnUnchangedData(t *testing.T) {
+	p, err := New(Options{
+		MinInterval: 5 * time.Minute,
+		MaxInterval: 30 * time.Minute,
+	}, 5)
+	if err != nil {
+		t.Fatalf("creating planner: %s", err)
+	}
+
+	var (
+		now  = time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+		data = now.Add(-time.Hour)
+	)
+
+	for i, tc := range []struct {
+		newData bool
+		expect  time.Duration
+	}{
+		{true, 5 * time.Minute},
+		{false, 10 * time.Minute},
+		{false, 20 * time.Minute},
+		{false, 30 * time.Minute}, // Capped at MaxInterval
+		{false, 30 * time.Minute},
+		{true, 5 * time.Minute},
+	} {
+		if tc.newData {
+			data = data.Add(time.Minute)
+		}
+
+		p.RecordCycle(now, 5, data)
+		if got := p.Interval(now); got != tc.expect {
+			t.Errorf("cycle %d: expected %s, got %s", i, tc.expect, got)
+		}
+	}
+}
+
+func TestPlannerBudget(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		used   int
+		expect time.Duration
+	}{
+		// 12h left in the day, 100 requests allow 20 cycles of 5 requests
+		{"full", 0, 36 * time.Minute},
+		// 40 requests allow 8 cycles
+		{"stretched", 60, 90 * time.Minute},
+		// Budget exhausted, wait for the reset of the quota
+		{"exhausted", 100, 12*time.Hour + quotaDayPadding},
+		{"exceeded", 120, 12*time.Hour + quotaDayPadding},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := New(Options{
+				DailyQuota:  100,
+				MinInterval: time.Minute,
+				MaxInterval: 10 * time.Minute,
+			}, 5)
+			if err != nil {
+				t.Fatalf("creating planner: %s", err)
+			}
+
+			now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+			p.RecordCycle(now, tc.used, now)
+
+			if got := p.Interval(now); got != tc.expect {
+				t.Errorf("expected %s, got %s", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestPlannerResetsQuotaOnUTCDay(t *testing.T) {
+	p, err := New(Options{
+		DailyQuota:  100,
+		MinInterval: time.Minute,
+		MaxInterval: 10 * time.Minute,
+	}, 5)
+	if err != nil {
+		t.Fatalf("creating planner: %s", err)
+	}
+
+	// Exhaust the budget shortly before the end of the UTC day
+	var (
+		evening = time.Date(2023, 5, 1, 23, 0, 0, 0, time.UTC)
+		morning = time.Date(2023, 5, 2, 0, 30, 0, 0, time.UTC)
+	)
+	p.RecordCycle(evening, 100, evening)
+
+	if r := p.Remaining(evening); r != 0 {
+		t.Fatalf("expected exhausted budget, %d requests left", r)
+	}
+
+	if got, expect := p.Interval(evening), time.Hour+quotaDayPadding; got != expect {
+		t.Errorf("expected %s before the reset, got %s", expect, got)
+	}
+
+	// 23.5h left in the new day for 20 cycles
+	expect := 23*time.Hour/20 + 30*time.Minute/20
+
+	// Reading the interval must not reset the day
+	if got := p.CurrentInterval(morning); got != expect {
+		t.Errorf("expected current interval %s after the reset, got %s", expect, got)
+	}
+	if r := p.Remaining(evening); r != 0 {
+		t.Errorf("expected CurrentInterval not to reset the day, %d requests left", r)
+	}
+
+	if got := p.Interval(morning); got != expect {
+		t.Errorf("expected %s after the reset, got %s", expect, got)
+	}
+	if r := p.Remaining(morning); r != 100 {
+		t.Errorf("expected full budget after the reset, %d requests left", r)
+	}
+}
+
+func TestPlannerRemainingResetsDay(t *testing.T) {
+	p, err := New(Options{DailyQuota: 100, MinInterval: time.Minute}, 5)
+	if err != nil {
+		t.Fatalf("creating planner: %s", err)
+	}
+
+	evening := time.Date(2023, 5, 1, 23, 0, 0, 0, time.UTC)
+	p.RecordCycle(evening, 60, evening)
+
+	if r := p.Remaining(evening.Add(2 * time.Hour)); r != 100 {
+		t.Errorf("expected full budget on the next day, %d requests left", r)
+	}
+}
+
+func TestPlannerChargesScheduledCycle(t *testing.T) {
+	p, err := New(Options{
+		DailyQuota:  100,
+		MinInterval: time.Minute,
+		MaxInterval: 10 * time.Minute,
+	}, 5)
+	if err != nil {
+		t.Fatalf("creating planner: %s", err)
+	}
+
+	// 12h left in the day, the cycle starting now leaves 95 requests
+	// for 19 cycles
+	var (
+		now    = time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+		expect = 12 * time.Hour / 19
+	)
+
+	if got := p.Next(now).Sub(now); got != expect {
+		t.Errorf("expected %s with the scheduled cycle charged, got %s", expect, got)
+	}
+
+	// Recording the cycle replaces the charge by the actual requests
+	p.RecordCycle(now, 5, now)
+	if got := p.CurrentInterval(now); got != expect {
+		t.Errorf("expected %s after recording the cycle, got %s", expect, got)
+	}
+
+	// A cycle without requests only clears the charge
+	p.Next(now)
+	p.RecordCycle(now, 0, time.Time{})
+	if got := p.CurrentInterval(now); got != expect {
+		t.Errorf("expected %s after recording an empty cycle, got %s", expect, got)
+	}
+	if r := p.Remaining(now); r != 95 {
+		t.Errorf("expected 95 requests left, got %d", r)
+	}
+}
+
+func TestPlannerIgnoresCyclesWithoutRequests(t *testing.T) {
+	p, err := New(Options{MinInterval: time.Minute, MaxInterval: time.Hour}, 5)
+	if err != nil {
+		t.Fatalf("creating planner: %s", err)
+	}
+
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	p.RecordCycle(now, 5, now)
+
+	for i := 0; i < 3; i++ {
+		p.RecordCycle(now, 0, time.Time{})
+	}
+
+	if got := p.CurrentInterval(now); got != time.Minute {
+		t.Errorf("expected cycles without requests not to slow down, got %s", got)
+	}
+}
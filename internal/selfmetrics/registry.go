@@ -0,0 +1,18 @@
+// Package selfmetrics contains the registry for the metrics about the
+// exporter itself. It is kept apart from the default registry so the
+// metrics endpoint does not expose the Go runtime and process metrics
+// next to the vehicle data.
+package selfmetrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// Registry contains all self-metrics
+	Registry = prometheus.NewRegistry()
+
+	// Factory creates metrics registered in Registry
+	Factory = promauto.With(Registry)
+)
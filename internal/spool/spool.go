@@ -0,0 +1,247 @@
+package spool
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	fileMode       = 0o600
+	dirMode        = 0o700
+	segmentSuffix  = ".spool"
+	tempFilePrefix = ".tmp-"
+)
+
+type (
+	// Spool is a directory based write-ahead queue storing segments of
+	// data until they are successfully delivered. Each segment carries
+	// the number of records contained to allow accounting.
+	Spool struct {
+		dir     string
+		maxAge  time.Duration
+		maxSize int64
+
+		lock sync.Mutex
+		seq  uint64
+	}
+
+	// Segment is one entry of the Spool
+	Segment struct {
+		Name    string
+		Created time.Time
+		Records int
+		Size    int64
+	}
+
+	// Stats describe the current contents of the Spool
+	Stats struct {
+		Segments int
+		Records  int
+		Size     int64
+	}
+)
+
+// Open creates the directory if required and returns a Spool limited
+// to maxSize bytes and segments younger than maxAge (zero disables the
+// respective limit)
+func Open(dir string, maxSize int64, maxAge time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return nil, errors.Wrap(err, "creating spool directory")
+	}
+
+	// Remove leftovers of writes interrupted by a crash
+	leftovers, err := filepath.Glob(filepath.Join(dir, tempFilePrefix+"*"))
+	if err != nil {
+		return nil, errors.Wrap(err, "listing temp files")
+	}
+	for _, f := range leftovers {
+		if err = os.Remove(f); err != nil {
+			return nil, errors.Wrap(err, "removing temp file")
+		}
+	}
+
+	return &Spool{dir: dir, maxAge: maxAge, maxSize: maxSize}, nil
+}
+
+// Add stores a new segment and enforces the limits afterwards. The
+// number of records dropped to stay within the limits is returned.
+func (s *Spool) Add(data []byte, records int) (dropped int, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d-%d%s", time.Now().UnixNano(), s.seq, records, segmentSuffix)
+
+	tmp, err := os.CreateTemp(s.dir, tempFilePrefix)
+	if err != nil {
+		return 0, errors.Wrap(err, "creating temp file")
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck // Is gone after successful rename
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close() //nolint:errcheck,gosec // Already failed
+		return 0, errors.Wrap(err, "writing segment")
+	}
+
+	if err = tmp.Sync(); err != nil {
+		tmp.Close() //nolint:errcheck,gosec // Already failed
+		return 0, errors.Wrap(err, "syncing segment")
+	}
+
+	if err = tmp.Close(); err != nil {
+		return 0, errors.Wrap(err, "closing segment")
+	}
+
+	if err = os.Rename(tmp.Name(), filepath.Join(s.dir, name)); err != nil {
+		return 0, errors.Wrap(err, "moving segment into place")
+	}
+
+	return s.prune()
+}
+
+// Oldest returns the oldest segment and its contents or nil if the
+// Spool is empty
+func (s *Spool) Oldest() (*Segment, []byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	segments, err := s.list()
+	if err != nil || len(segments) == 0 {
+		return nil, nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, segments[0].Name))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "reading segment")
+	}
+
+	return &segments[0], data, nil
+}
+
+// Prune removes segments exceeding the limits and returns the number
+// of records dropped
+func (s *Spool) Prune() (dropped int, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.prune()
+}
+
+// Remove deletes a delivered segment
+func (s *Spool) Remove(seg *Segment) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := os.Remove(filepath.Join(s.dir, seg.Name)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "removing segment")
+	}
+
+	return nil
+}
+
+// Stats returns the current size of the Spool
+func (s *Spool) Stats() (Stats, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var st Stats
+
+	segments, err := s.list()
+	if err != nil {
+		return st, err
+	}
+
+	for _, seg := range segments {
+		st.Segments++
+		st.Records += seg.Records
+		st.Size += seg.Size
+	}
+
+	return st, nil
+}
+
+// list returns all segments sorted from oldest to newest
+func (s *Spool) list() ([]Segment, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing spool directory")
+	}
+
+	var out []Segment
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), segmentSuffix) {
+			continue
+		}
+
+		parts := strings.Split(strings.TrimSuffix(e.Name(), segmentSuffix), "-")
+		if len(parts) != 3 { //nolint:gomnd // timestamp, sequence, records
+			continue
+		}
+
+		created, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		records, err := strconv.Atoi(parts[2])
+		if err != nil {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			// Segment vanished in between
+			continue
+		}
+
+		out = append(out, Segment{
+			Name:    e.Name(),
+			Created: time.Unix(0, created),
+			Records: records,
+			Size:    info.Size(),
+		})
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+
+	return out, nil
+}
+
+func (s *Spool) prune() (dropped int, err error) {
+	segments, err := s.list()
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, seg := range segments {
+		total += seg.Size
+	}
+
+	for _, seg := range segments {
+		expired := s.maxAge > 0 && time.Since(seg.Created) > s.maxAge
+		oversized := s.maxSize > 0 && total > s.maxSize
+
+		if !expired && !oversized {
+			// Segments are sorted, all remaining ones are newer
+			break
+		}
+
+		if err = os.Remove(filepath.Join(s.dir, seg.Name)); err != nil && !os.IsNotExist(err) {
+			return dropped, errors.Wrap(err, "removing segment")
+		}
+
+		total -= seg.Size
+		dropped += seg.Records
+	}
+
+	return dropped, nil
+}
@@ -0,0 +1,136 @@
+package spool
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSpoolReturnsSegmentsInOrder(t *testing.T) {
+	s, err := Open(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatalf("opening spool: %s", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err = s.Add([]byte(fmt.Sprintf("segment %d\n", i)), i+1); err != nil {
+			t.Fatalf("adding segment %d: %s", i, err)
+		}
+	}
+
+	st, err := s.Stats()
+	if err != nil {
+		t.Fatalf("getting stats: %s", err)
+	}
+	if st.Segments != 3 || st.Records != 6 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+
+	for i := 0; i < 3; i++ {
+		seg, data, err := s.Oldest()
+		if err != nil {
+			t.Fatalf("reading oldest segment: %s", err)
+		}
+
+		if seg == nil {
+			t.Fatalf("expected segment %d, spool is empty", i)
+		}
+
+		if expect := fmt.Sprintf("segment %d\n", i); string(data) != expect || seg.Records != i+1 {
+			t.Errorf("expected %q with %d records, got %q with %d records", expect, i+1, data, seg.Records)
+		}
+
+		if err = s.Remove(seg); err != nil {
+			t.Fatalf("removing segment: %s", err)
+		}
+	}
+
+	seg, _, err := s.Oldest()
+	if err != nil || seg != nil {
+		t.Errorf("expected empty spool, got %+v / %v", seg, err)
+	}
+}
+
+func TestSpoolPrunesBySize(t *testing.T) {
+	s, err := Open(t.TempDir(), 25, 0)
+	if err != nil {
+		t.Fatalf("opening spool: %s", err)
+	}
+
+	var dropped int
+	for i := 0; i < 3; i++ {
+		// Each segment has 10 bytes, only two fit into the limit
+		n, err := s.Add([]byte(fmt.Sprintf("segment-%d\n", i)), 2)
+		if err != nil {
+			t.Fatalf("adding segment %d: %s", i, err)
+		}
+		dropped += n
+	}
+
+	if dropped != 2 {
+		t.Errorf("expected 2 dropped records, got %d", dropped)
+	}
+
+	_, data, err := s.Oldest()
+	if err != nil {
+		t.Fatalf("reading oldest segment: %s", err)
+	}
+
+	if string(data) != "segment-1\n" {
+		t.Errorf("expected oldest segment to be dropped, got %q", data)
+	}
+}
+
+func TestSpoolPrunesByAge(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, 0, time.Hour)
+	if err != nil {
+		t.Fatalf("opening spool: %s", err)
+	}
+
+	old := fmt.Sprintf("%020d-%06d-%d%s", time.Now().Add(-2*time.Hour).UnixNano(), 1, 5, segmentSuffix)
+	if err = os.WriteFile(filepath.Join(dir, old), []byte("old\n"), fileMode); err != nil {
+		t.Fatalf("writing old segment: %s", err)
+	}
+
+	if _, err = s.Add([]byte("new\n"), 1); err != nil {
+		t.Fatalf("adding segment: %s", err)
+	}
+
+	st, err := s.Stats()
+	if err != nil {
+		t.Fatalf("getting stats: %s", err)
+	}
+	if st.Segments != 1 || st.Records != 1 {
+		t.Errorf("expected only the new segment to be kept, got %+v", st)
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, old)); !os.IsNotExist(err) {
+		t.Errorf("expected old segment to be removed, got %v", err)
+	}
+}
+
+func TestSpoolRemovesLeftoversOnOpen(t *testing.T) {
+	dir := t.TempDir()
+
+	leftover := filepath.Join(dir, tempFilePrefix+"123")
+	if err := os.WriteFile(leftover, []byte("partial"), fileMode); err != nil {
+		t.Fatalf("writing leftover: %s", err)
+	}
+
+	s, err := Open(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("opening spool: %s", err)
+	}
+
+	if _, err = os.Stat(leftover); !os.IsNotExist(err) {
+		t.Errorf("expected leftover to be removed, got %v", err)
+	}
+
+	if seg, _, err := s.Oldest(); err != nil || seg != nil {
+		t.Errorf("expected leftover not to be listed, got %+v / %v", seg, err)
+	}
+}
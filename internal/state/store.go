@@ -0,0 +1,187 @@
+package state
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/exporters"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+)
+
+type (
+	// Store keeps the latest data fetched for every vehicle in memory.
+	// It is fed as an exporter and through RecordFetch by the fetcher.
+	Store struct {
+		lock     sync.RWMutex
+		vehicles map[string]*VehicleState
+	}
+
+	// VehicleState contains the latest known state of one vehicle
+	VehicleState struct {
+		VehicleID string
+		// Name is the configured display name (might be empty)
+		Name       string
+		Containers map[mercedes.Container]ContainerState
+		// AddedAt is the time the vehicle was first seen by the store
+		AddedAt time.Time
+	}
+
+	// ContainerState contains the latest data of one container
+	ContainerState struct {
+		// Data holds the container struct (i.e. mercedes.FuelStatus) or
+		// nil if the container was never fetched successfully
+		Data any
+		// FetchedAt is the time of the last successful fetch
+		FetchedAt time.Time
+		// LastAttempt is the time of the last fetch
+		LastAttempt time.Time
+		// LastError contains the error of the last fetch if it failed
+		LastError error
+	}
+)
+
+var _ exporters.Exporter = (*Store)(nil)
+
+// New creates an empty Store
+func New() *Store {
+	return &Store{vehicles: make(map[string]*VehicleState)}
+}
+
+// RecordFetch stores the outcome of fetching a container
+func (s *Store) RecordFetch(vehicleID string, container mercedes.Container, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	v := s.vehicle(vehicleID)
+
+	cs := v.Containers[container]
+	cs.LastAttempt = time.Now()
+	cs.LastError = err
+	v.Containers[container] = cs
+}
+
+// SetVehicles registers the given vehicles (vehicle-id to display
+// name) and removes all others
+func (s *Store) SetVehicles(vehicles map[string]string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for id, name := range vehicles {
+		s.vehicle(id).Name = name
+	}
+
+	for id := range s.vehicles {
+		if _, ok := vehicles[id]; !ok {
+			delete(s.vehicles, id)
+		}
+	}
+}
+
+// Vehicle returns a copy of the state of the given vehicle
+func (s *Store) Vehicle(vehicleID string) (VehicleState, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	v, ok := s.vehicles[vehicleID]
+	if !ok {
+		return VehicleState{}, false
+	}
+
+	return v.copy(), true
+}
+
+// Vehicles returns a copy of the state of all vehicles sorted by ID
+func (s *Store) Vehicles() []VehicleState {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	out := make([]VehicleState, 0, len(s.vehicles))
+	for _, v := range s.vehicles {
+		out = append(out, v.copy())
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].VehicleID < out[j].VehicleID })
+
+	return out
+}
+
+func (s *Store) SetElectricStatus(vehicleID string, es mercedes.ElectricStatus) {
+	s.setData(vehicleID, mercedes.ContainerElectricStatus, es)
+}
+
+func (s *Store) SetFuelStatus(vehicleID string, fs mercedes.FuelStatus) {
+	s.setData(vehicleID, mercedes.ContainerFuelStatus, fs)
+}
+
+func (s *Store) SetLockStatus(vehicleID string, ls mercedes.LockStatus) {
+	s.setData(vehicleID, mercedes.ContainerLockStatus, ls)
+}
+
+func (s *Store) SetPayAsYouGo(vehicleID string, p mercedes.PayAsYouDriveInsurance) {
+	s.setData(vehicleID, mercedes.ContainerPayAsYouDrive, p)
+}
+
+func (s *Store) SetVehicleStatus(vehicleID string, vs mercedes.VehicleStatus) {
+	s.setData(vehicleID, mercedes.ContainerVehicleStatus, vs)
+}
+
+func (s *Store) setData(vehicleID string, container mercedes.Container, data any) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	v := s.vehicle(vehicleID)
+
+	cs := v.Containers[container]
+	cs.Data = data
+	cs.FetchedAt = time.Now()
+	v.Containers[container] = cs
+}
+
+// vehicle returns the state of the vehicle, creating it if required.
+// The caller must hold the write lock.
+func (s *Store) vehicle(vehicleID string) *VehicleState {
+	v, ok := s.vehicles[vehicleID]
+	if !ok {
+		v = &VehicleState{
+			VehicleID:  vehicleID,
+			Containers: make(map[mercedes.Container]ContainerState),
+			AddedAt:    time.Now(),
+		}
+		s.vehicles[vehicleID] = v
+	}
+
+	return v
+}
+
+// LastSuccess returns the time of the latest successful fetch of any
+// container of the vehicle (zero if none succeeded yet) and the error
+// of the latest failed fetch
+func (v VehicleState) LastSuccess() (fetchedAt time.Time, lastErr error) {
+	var lastErrAt time.Time
+	for _, cs := range v.Containers {
+		if cs.FetchedAt.After(fetchedAt) {
+			fetchedAt = cs.FetchedAt
+		}
+		if cs.LastError != nil && cs.LastAttempt.After(lastErrAt) {
+			lastErr, lastErrAt = cs.LastError, cs.LastAttempt
+		}
+	}
+	return fetchedAt, lastErr
+}
+
+func (v VehicleState) copy() VehicleState {
+	out := VehicleState{
+		VehicleID:  v.VehicleID,
+		Name:       v.Name,
+		Containers: make(map[mercedes.Container]ContainerState, len(v.Containers)),
+		AddedAt:    v.AddedAt,
+	}
+
+	// Container data are value types and therefore safe to copy
+	for c, cs := range v.Containers {
+		out.Containers[c] = cs
+	}
+
+	return out
+}
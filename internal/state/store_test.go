@@ -0,0 +1,121 @@
+package state_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/state"
+)
+
+const testVehicleID = "WDB111111ZZZ22222"
+
+func testFuelStatus(rangeLiquid int64) mercedes.FuelStatus {
+	return mercedes.FuelStatus{RangeLiquid: mercedes.NewTimedInt(rangeLiquid, time.Unix(1000, 0))}
+}
+
+func TestStoreRecordsFetches(t *testing.T) {
+	s := state.New()
+
+	s.SetFuelStatus(testVehicleID, testFuelStatus(500))
+	s.RecordFetch(testVehicleID, mercedes.ContainerFuelStatus, nil)
+	s.RecordFetch(testVehicleID, mercedes.ContainerLockStatus, errors.New("broken"))
+
+	v, ok := s.Vehicle(testVehicleID)
+	if !ok {
+		t.Fatal("expected vehicle to be known")
+	}
+
+	fs := v.Containers[mercedes.ContainerFuelStatus]
+	if data, ok := fs.Data.(mercedes.FuelStatus); !ok || data.RangeLiquid.Int() != 500 {
+		t.Errorf("unexpected fuel status %+v", fs.Data)
+	}
+	if fs.FetchedAt.IsZero() || fs.LastAttempt.IsZero() || fs.LastError != nil {
+		t.Errorf("unexpected fetch state %+v", fs)
+	}
+
+	ls := v.Containers[mercedes.ContainerLockStatus]
+	if ls.Data != nil || !ls.FetchedAt.IsZero() || ls.LastError == nil {
+		t.Errorf("expected failed fetch without data, got %+v", ls)
+	}
+
+	lastSuccess, lastErr := v.LastSuccess()
+	if !lastSuccess.Equal(fs.FetchedAt) || lastErr == nil {
+		t.Errorf("unexpected last success %s / %v", lastSuccess, lastErr)
+	}
+}
+
+func TestStoreSetVehicles(t *testing.T) {
+	s := state.New()
+
+	s.SetFuelStatus("WDB333333ZZZ44444", testFuelStatus(300))
+	s.SetFuelStatus(testVehicleID, testFuelStatus(500))
+	s.SetVehicles(map[string]string{testVehicleID: "Family Car", "WDB555555ZZZ66666": ""})
+
+	vs := s.Vehicles()
+	if len(vs) != 2 || vs[0].VehicleID != testVehicleID || vs[1].VehicleID != "WDB555555ZZZ66666" {
+		t.Fatalf("unexpected vehicles %+v", vs)
+	}
+
+	if vs[0].Name != "Family Car" {
+		t.Errorf("expected name to be set, got %q", vs[0].Name)
+	}
+
+	if _, ok := vs[0].Containers[mercedes.ContainerFuelStatus]; !ok {
+		t.Error("expected data of kept vehicle to be kept")
+	}
+}
+
+func TestStoreReturnsCopies(t *testing.T) {
+	s := state.New()
+	s.SetFuelStatus(testVehicleID, testFuelStatus(500))
+
+	v, _ := s.Vehicle(testVehicleID)
+	delete(v.Containers, mercedes.ContainerFuelStatus)
+
+	if v, _ = s.Vehicle(testVehicleID); len(v.Containers) != 1 {
+		t.Error("modifying the returned state changed the store")
+	}
+}
+
+func TestStoreConcurrentAccess(t *testing.T) {
+	var (
+		s  = state.New()
+		wg sync.WaitGroup
+	)
+
+	for i := 0; i < 4; i++ {
+		wg.Add(2) //nolint:gomnd // Writer and reader
+
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				s.SetFuelStatus(testVehicleID, testFuelStatus(int64(n)))
+				s.RecordFetch(testVehicleID, mercedes.Containers[i], nil)
+			}
+		}(i)
+
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				for _, v := range s.Vehicles() {
+					for c, cs := range v.Containers {
+						v.Containers[c] = cs
+					}
+					v.LastSuccess()
+				}
+				s.Vehicle(testVehicleID)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	v, ok := s.Vehicle(testVehicleID)
+	if !ok || len(v.Containers) != 4 { //nolint:gomnd // One per writer
+		t.Errorf("expected 4 containers, got %+v", v.Containers)
+	}
+}
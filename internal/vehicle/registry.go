@@ -0,0 +1,147 @@
+package vehicle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Label names attached to every series / point, metadata labels are
+// empty when not configured
+const (
+	LabelVehicleID = "vehicle_id"
+	LabelName      = "vehicle_name"
+	LabelOwner     = "owner"
+	LabelModel     = "model"
+	LabelFuelType  = "fuel_type"
+)
+
+// Available VIN modes
+const (
+	VINModePlain VINMode = "plain"
+	VINModeHash  VINMode = "hash"
+	VINModeHide  VINMode = "hide"
+)
+
+const hashLength = 16
+
+type (
+	// Metadata describes a vehicle beyond its VIN
+	Metadata struct {
+		Name     string
+		Owner    string
+		Model    string
+		FuelType string
+	}
+
+	// Registry maps vehicle-ids to their metadata and applies the
+	// VIN mode. A nil Registry yields the plain VIN without metadata.
+	Registry struct {
+		lock     sync.RWMutex
+		mode     VINMode
+		salt     string
+		metadata map[string]Metadata
+	}
+
+	// VINMode controls how the VIN is exposed in labels
+	VINMode string
+)
+
+// LabelNames contains all labels in the order returned by Labels
+var LabelNames = []string{LabelVehicleID, LabelName, LabelOwner, LabelModel, LabelFuelType}
+
+// ParseVINMode validates the given VIN mode
+func ParseVINMode(s string) (VINMode, error) {
+	switch m := VINMode(s); m {
+	case VINModePlain, VINModeHash, VINModeHide:
+		return m, nil
+	default:
+		return "", errors.Errorf("unknown vin mode %q", s)
+	}
+}
+
+// NewRegistry creates an empty Registry. The salt is prepended to the
+// VIN before hashing in VINModeHash.
+func NewRegistry(mode VINMode, salt string) *Registry {
+	return &Registry{
+		mode:     mode,
+		salt:     salt,
+		metadata: make(map[string]Metadata),
+	}
+}
+
+// ID returns the vehicle-id as it should be exposed
+func (r *Registry) ID(vehicleID string) string {
+	if r == nil {
+		return vehicleID
+	}
+
+	switch r.mode {
+	case VINModeHash:
+		sum := sha256.Sum256([]byte(r.salt + vehicleID))
+		return hex.EncodeToString(sum[:])[:hashLength]
+
+	case VINModeHide:
+		return ""
+
+	default:
+		return vehicleID
+	}
+}
+
+// PublicID returns the identifier to expose outside of labels (i.e.
+// in topics and URLs): the ID or, with the VIN hidden, the name of the
+// vehicle which is unique in that mode
+func (r *Registry) PublicID(vehicleID string) string {
+	if id := r.ID(vehicleID); id != "" {
+		return id
+	}
+
+	return r.Metadata(vehicleID).Name
+}
+
+// Labels returns the label names and values (alternating) for the
+// given vehicle in the order of LabelNames
+func (r *Registry) Labels(vehicleID string) []string {
+	values := r.LabelValues(vehicleID)
+
+	out := make([]string, 0, 2*len(LabelNames)) //nolint:gomnd // Pairs of name and value
+	for i, name := range LabelNames {
+		out = append(out, name, values[i])
+	}
+
+	return out
+}
+
+// LabelValues returns the label values for the given vehicle in the
+// order of LabelNames
+func (r *Registry) LabelValues(vehicleID string) []string {
+	m := r.Metadata(vehicleID)
+
+	return []string{r.ID(vehicleID), m.Name, m.Owner, m.Model, m.FuelType}
+}
+
+// Metadata returns the metadata of the given vehicle
+func (r *Registry) Metadata(vehicleID string) Metadata {
+	if r == nil {
+		return Metadata{}
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.metadata[vehicleID]
+}
+
+// Set replaces the metadata of all vehicles
+func (r *Registry) Set(metadata map[string]Metadata) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.metadata = make(map[string]Metadata, len(metadata))
+	for id, m := range metadata {
+		r.metadata[id] = m
+	}
+}
@@ -0,0 +1,91 @@
+package vehicle_test
+
+import (
+	"testing"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/vehicle"
+)
+
+const (
+	testVehicleID  = "WDB111111ZZZ22222"
+	otherVehicleID = "WDB333333ZZZ44444"
+)
+
+func TestRegistryHashesVIN(t *testing.T) {
+	var (
+		r     = vehicle.NewRegistry(vehicle.VINModeHash, "salt")
+		id    = r.ID(testVehicleID)
+		again = vehicle.NewRegistry(vehicle.VINModeHash, "salt").ID(testVehicleID)
+	)
+
+	if len(id) != 16 || id == testVehicleID {
+		t.Fatalf("expected 16 character hash, got %q", id)
+	}
+
+	if again != id {
+		t.Errorf("expected hash to be stable, got %q and %q", id, again)
+	}
+
+	if other := r.ID(otherVehicleID); other == id {
+		t.Errorf("expected different vehicles to have different hashes, got %q", other)
+	}
+
+	if salted := vehicle.NewRegistry(vehicle.VINModeHash, "pepper").ID(testVehicleID); salted == id {
+		t.Errorf("expected different salts to yield different hashes, got %q", salted)
+	}
+}
+
+func TestRegistryIDModes(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		r        *vehicle.Registry
+		id       string
+		publicID string
+	}{
+		{name: "nil", r: nil, id: testVehicleID, publicID: testVehicleID},
+		{name: "plain", r: vehicle.NewRegistry(vehicle.VINModePlain, ""), id: testVehicleID, publicID: testVehicleID},
+		{name: "hide", r: vehicle.NewRegistry(vehicle.VINModeHide, ""), id: "", publicID: "Daily"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.r != nil {
+				tc.r.Set(map[string]vehicle.Metadata{testVehicleID: {Name: "Daily"}})
+			}
+
+			if id := tc.r.ID(testVehicleID); id != tc.id {
+				t.Errorf("expected ID %q, got %q", tc.id, id)
+			}
+			if id := tc.r.PublicID(testVehicleID); id != tc.publicID {
+				t.Errorf("expected public ID %q, got %q", tc.publicID, id)
+			}
+		})
+	}
+}
+
+func TestRegistryLabelValues(t *testing.T) {
+	r := vehicle.NewRegistry(vehicle.VINModeHide, "")
+	r.Set(map[string]vehicle.Metadata{testVehicleID: {Name: "Daily", Owner: "Jo", Model: "EQA", FuelType: "electric"}})
+
+	values := r.LabelValues(testVehicleID)
+	expect := []string{"", "Daily", "Jo", "EQA", "electric"}
+
+	if len(values) != len(vehicle.LabelNames) {
+		t.Fatalf("expected %d values, got %v", len(vehicle.LabelNames), values)
+	}
+	for i := range expect {
+		if values[i] != expect[i] {
+			t.Errorf("expected %s to be %q, got %q", vehicle.LabelNames[i], expect[i], values[i])
+		}
+	}
+}
+
+func TestParseVINMode(t *testing.T) {
+	for _, s := range []string{"plain", "hash", "hide"} {
+		if _, err := vehicle.ParseVINMode(s); err != nil {
+			t.Errorf("expected %q to be valid: %s", s, err)
+		}
+	}
+
+	if _, err := vehicle.ParseVINMode("rot13"); err == nil {
+		t.Error("expected unknown mode to be rejected")
+	}
+}
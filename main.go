@@ -1,29 +1,35 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/robfig/cron/v3"
 	"github.com/sirupsen/logrus"
 
 	"github.com/Luzifer/mercedes-byocar-exporter/internal/credential"
-	"github.com/Luzifer/mercedes-byocar-exporter/internal/exporters"
-	"github.com/Luzifer/mercedes-byocar-exporter/internal/exporters/influxdb"
 	"github.com/Luzifer/mercedes-byocar-exporter/internal/exporters/prometheus"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/fetcher"
 	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/scheduler"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/state"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/vehicle"
 	"github.com/Luzifer/rconfig/v2"
 )
 
 var (
-	cfg     cliConfig
-	version = "dev"
-
-	enabledExporters exporters.Set
+	cfg cliConfig
+	// flagCfg is the config before applying the config file, used as
+	// base on reload
+	flagCfg  cliConfig
+	vehicles []vehicleConfig
+	version  = "dev"
 )
 
 func initApp() error {
@@ -37,16 +43,36 @@ func initApp() error {
 		os.Exit(0)
 	}
 
+	flagCfg = cfg
+	flagCfg.VehicleID = append([]string(nil), cfg.VehicleID...)
+
 	l, err := logrus.ParseLevel(cfg.LogLevel)
 	if err != nil {
 		return errors.Wrap(err, "parsing log-level")
 	}
 	logrus.SetLevel(l)
 
+	if cfg.Config != "" {
+		if vehicles, err = loadConfigFile(&cfg, cfg.Config); err != nil {
+			return errors.Wrap(err, "loading config file")
+		}
+	} else {
+		vehicles = vehiclesFromFlags(cfg)
+	}
+
+	if args := rconfig.Args(); len(args) > 1 {
+		// First argument is the program name, commands exit when finished
+		return runCommand(args[1:])
+	}
+
 	if err = cfg.Validate(); err != nil {
 		return errors.Wrap(err, "validating config")
 	}
 
+	if err = validateVehicles(cfg, vehicles); err != nil {
+		return errors.Wrap(err, "validating vehicles")
+	}
+
 	return nil
 }
 
@@ -60,61 +86,186 @@ func main() {
 	var creds credential.Store
 	switch {
 	case cfg.ClientID != "":
+		var key string
+		if key, err = cfg.credentialKey(); err != nil {
+			break
+		}
+		if key != "" {
+			logrus.WithField("method", "encrypted-json-file").Debug("opening credential store")
+			creds, err = credential.NewEncryptedJSONStore(cfg.CredentialFile, cfg.ClientID, cfg.ClientSecret, key)
+			break
+		}
 		logrus.WithField("method", "json-file").Debug("opening credential store")
 		creds, err = credential.NewJSONStore(cfg.CredentialFile, cfg.ClientID, cfg.ClientSecret)
 	case cfg.VaultKey != "":
 		logrus.WithField("method", "vault").Debug("opening credential store")
 		creds, err = credential.NewVaultStore(cfg.VaultKey)
+	case cfg.K8sSecret != "":
+		logrus.WithField("method", "kubernetes").Debug("opening credential store")
+		var k8sCfg credential.KubernetesConfig
+		if k8sCfg, err = credential.InClusterKubernetesConfig(); err == nil {
+			creds, err = credential.NewKubernetesStore(k8sCfg, cfg.K8sSecret)
+		}
 	}
 	if err != nil {
 		logrus.WithError(err).Fatal("initializing credential store")
 	}
-	logrus.WithField("method", "vault").Debug("credential store connected")
+	logrus.Debug("credential store connected")
 
 	// Initialize Mercedes API client
 	clientID, clientSecret, err := creds.GetClientCredentials()
 	if err != nil {
 		logrus.WithError(err).Fatal("getting client credentials")
 	}
-	mClient := mercedes.New(clientID, clientSecret, creds)
-
-	// Register Exporters
-	enabledExporters = append(enabledExporters, prometheus.Exporter)
-	if cfg.InfluxExport != "" {
-		logrus.Info("creating influxdb exporter")
-		influxExporter, err := influxdb.New(cfg.InfluxExport)
-		if err != nil {
-			logrus.WithError(err).Fatal("creating influxdb exporter")
-		}
-		go func() {
-			for err := range influxExporter.Errors() {
-				logrus.WithError(err).Error("processing influx metrics")
-			}
-		}()
-		enabledExporters = append(enabledExporters, influxExporter)
+	clientOpts := []mercedes.Option{
+		mercedes.WithRetryPolicy(mercedes.RetryPolicy{
+			MaxRetries: cfg.APIRetries,
+			BaseDelay:  cfg.APIRetryDelay,
+			MaxDelay:   cfg.APIRetryMax,
+		}),
+	}
+	if cfg.APIBaseURL != "" {
+		clientOpts = append(clientOpts, mercedes.WithBaseURL(cfg.APIBaseURL))
 	}
+	if cfg.OAuthAuthURL != "" {
+		clientOpts = append(clientOpts, mercedes.WithAuthURL(cfg.OAuthAuthURL))
+	}
+	if cfg.OAuthTokenURL != "" {
+		clientOpts = append(clientOpts, mercedes.WithTokenURL(cfg.OAuthTokenURL))
+	}
+	mClient := mercedes.New(clientID, clientSecret, creds, clientOpts...)
+
+	// Vehicle metadata attached as labels by the exporters, set by the
+	// pipeline
+	vinMode, _ := vehicle.ParseVINMode(cfg.VINMode) // Validated in cfg.Validate
+	vehicleRegistry := vehicle.NewRegistry(vinMode, cfg.VINHashSalt)
+
+	stateStore := state.New()
 
 	// Register HTTP handlers
+	http.DefaultServeMux.HandleFunc("/", getStatusPageHandler(stateStore, vehicleRegistry, creds))
+	http.DefaultServeMux.HandleFunc(apiPrefixV1, getAPIHandler(stateStore, vehicleRegistry))
+	http.DefaultServeMux.HandleFunc(apiPrefixV1+"/", getAPIHandler(stateStore, vehicleRegistry))
 	http.DefaultServeMux.HandleFunc("/auth", getAuthRedirectHandler(mClient))
 	http.DefaultServeMux.HandleFunc("/store-token", getAuthStoreTokenHandler(mClient, creds))
-	http.DefaultServeMux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("OK")) })
-	http.DefaultServeMux.Handle("/metrics", promhttp.Handler())
 
-	scheduler := cron.New()
-	scheduler.AddFunc(fmt.Sprintf("@every %s", cfg.FetchInterval), getCronFunc(mClient))
-	scheduler.Start()
+	metricsHandler := getMetricsHandler(stateStore, vehicleRegistry, prometheus.Options{
+		ExplicitTimestamps: cfg.PrometheusTimestamp,
+		SeriesTTL:          cfg.PrometheusSeriesTTL,
+	})
+	if cfg.MetricsListen == "" {
+		http.DefaultServeMux.Handle(cfg.MetricsPath, metricsHandler)
+	}
+
+	// Limit the cycle to the fetch interval so it never overlaps the next tick
+	cycleTimeout := cfg.FetchTimeout
+	if cycleTimeout == 0 {
+		cycleTimeout = cfg.FetchInterval
+	}
+
+	// The exporter is set by the pipeline
+	engine := fetcher.New(mClient, nil, fetcher.Options{
+		CycleTimeout: cycleTimeout,
+		OnFetch: func(vehicleID string, container mercedes.Container, err error, _ time.Duration) {
+			stateStore.RecordFetch(vehicleID, container, err)
+		},
+		Vehicles: vehicleRegistry,
+		Workers:  cfg.FetchWorkers,
+	})
+
+	// The requests per cycle are set by the pipeline
+	planner, err := scheduler.New(scheduler.Options{
+		DailyQuota:  cfg.DailyQuota,
+		MinInterval: cfg.FetchInterval,
+		MaxInterval: cfg.MaxFetchInterval,
+		QuietHours:  cfg.QuietHours,
+		QuietFactor: cfg.QuietSlowdown,
+	}, 0)
+	if err != nil {
+		logrus.WithError(err).Fatal("creating fetch planner")
+	}
+
+	// Stop on SIGINT / SIGTERM (i.e. Kubernetes terminating the pod)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fetchCtx, cancelFetch := context.WithCancel(context.Background())
+	defer cancelFetch()
+
+	pipe := &pipeline{
+		engine:      engine,
+		fetchCtx:    fetchCtx,
+		cancelFetch: cancelFetch,
+		planner:     planner,
+		registry:    vehicleRegistry,
+		sched:       cron.New(cron.WithChain(cron.SkipIfStillRunning(cron.DefaultLogger))),
+		stateStore:  stateStore,
+	}
+	if err = pipe.apply(cfg, vehicles); err != nil {
+		logrus.WithError(err).Fatal("setting up exporters")
+	}
+
+	healthHandler := getReadinessHandler(healthChecker{
+		exporterHealth: pipe.exporterHealth,
+		maxFetchAge:    cfg.MaxFetchAge,
+		planner:        planner,
+		registry:       vehicleRegistry,
+		stateStore:     stateStore,
+		tokenState:     mClient.Tokens().State,
+		vehicles:       pipe.currentVehicles,
+	})
+	http.DefaultServeMux.HandleFunc("/healthz", healthHandler)
+	http.DefaultServeMux.HandleFunc("/livez", getLivenessHandler())
+	http.DefaultServeMux.HandleFunc("/readyz", healthHandler)
+	pipe.sched.Start()
+
+	go mClient.Tokens().Run(ctx)
+	go pipe.watchReload(ctx, cfg.ConfigWatchInterval)
 
-	// Do an initial fetch to propagate metrics
-	getCronFunc(mClient)()
+	// Do an initial fetch to propagate metrics (the planner charged it
+	// when the scheduler computed the first run)
+	go pipe.runCycle()
 
-	// Start HTTP server
+	// Start HTTP servers
 	logrus.WithField("version", version).Info("mercedes-byocar-exporter started")
-	srv := http.Server{
-		Addr:              cfg.Listen,
-		Handler:           http.DefaultServeMux,
-		ReadHeaderTimeout: time.Second,
+	servers := []*http.Server{startServer(cfg.Listen, http.DefaultServeMux)}
+	if cfg.MetricsListen != "" {
+		mux := http.NewServeMux()
+		mux.Handle(cfg.MetricsPath, metricsHandler)
+		servers = append(servers, startServer(cfg.MetricsListen, mux))
+	}
+
+	<-ctx.Done()
+	logrus.WithField("timeout", cfg.ShutdownTimeout).Info("shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err = pipe.shutdown(shutdownCtx); err != nil {
+		logrus.WithError(err).Error("stopping fetcher and exporters")
+	}
+
+	for _, srv := range servers {
+		if err = srv.Shutdown(shutdownCtx); err != nil {
+			logrus.WithError(err).WithField("listen", srv.Addr).Error("shutting down HTTP server")
+		}
 	}
-	if err = srv.ListenAndServe(); err != nil {
-		logrus.WithError(err).Fatal("HTTP server exitted unexpectedly")
+
+	logrus.Info("shutdown complete")
+}
+
+func startServer(addr string, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: time.Second,
 	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.WithError(err).WithField("listen", addr).Fatal("HTTP server exitted unexpectedly")
+		}
+	}()
+
+	return srv
 }
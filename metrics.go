@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/exporters/prometheus"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/selfmetrics"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/state"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/vehicle"
+)
+
+// getMetricsHandler serves the vehicle metrics rendered from the state
+// store together with the self-metrics of the exporter
+func getMetricsHandler(store *state.Store, vehicles *vehicle.Registry, opts prometheus.Options) http.Handler {
+	return promhttp.HandlerFor(prom.Gatherers{
+		prometheus.NewRegistry(store, vehicles, opts),
+		selfmetrics.Registry,
+	}, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/exporters"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/exporters/changefilter"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/fetcher"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/scheduler"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/selfmetrics"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/state"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/vehicle"
+)
+
+type (
+	// pipeline connects the long-living parts (engine, planner, state
+	// store) with the parts defined by the configuration (vehicles and
+	// exporters) and swaps the latter on reload
+	pipeline struct {
+		changes    *changefilter.Filter
+		engine     *fetcher.Engine
+		planner    *scheduler.Planner
+		registry   *vehicle.Registry
+		sched      *cron.Cron
+		stateStore *state.Store
+
+		// fetchCtx is canceled to abort running fetches on shutdown
+		fetchCtx    context.Context
+		cancelFetch context.CancelFunc
+
+		// lock is held for reading during a fetch cycle so a reload is
+		// never applied in the middle of a cycle
+		lock    sync.RWMutex
+		entryID cron.EntryID
+
+		// applyLock serializes apply so exporters can be built without
+		// holding lock (and therefore without waiting for the cycle)
+		applyLock sync.Mutex
+		// current is read without lock by the health checks which must
+		// not wait for a running cycle
+		current atomic.Pointer[pipelineState]
+		// stopped is set when the shutdown begins, no config is applied
+		// afterwards
+		stopped atomic.Bool
+	}
+
+	// pipelineState is the configuration currently applied
+	pipelineState struct {
+		cfg       cliConfig
+		exporters *exporterSet
+		vehicles  []vehicleConfig
+	}
+)
+
+var (
+	configReloads = selfmetrics.Factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "mercedes_byocar",
+		Subsystem: "config",
+		Name:      "reloads_total",
+		Help:      "Number of configuration reloads by result (success, failure)",
+	}, []string{"result"})
+
+	configLastReloadSuccessful = selfmetrics.Factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: "mercedes_byocar",
+		Subsystem: "config",
+		Name:      "last_reload_successful",
+		Help:      "Whether the last configuration reload succeeded - 1 = success",
+	})
+
+	configLastReloadSuccess = selfmetrics.Factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: "mercedes_byocar",
+		Subsystem: "config",
+		Name:      "last_reload_success_timestamp_seconds",
+		Help:      "Timestamp of the last successful configuration load",
+	})
+)
+
+// apply builds the exporters for the given config and swaps them in
+// together with the vehicle list. On error nothing is changed.
+func (p *pipeline) apply(c cliConfig, vehicles []vehicleConfig) error {
+	p.applyLock.Lock()
+	defer p.applyLock.Unlock()
+
+	if p.stopped.Load() {
+		return errors.New("shutdown in progress")
+	}
+
+	var prev *exporterSet
+	if cur := p.current.Load(); cur != nil {
+		prev = cur.exporters
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), exporterStartTimeout)
+	defer cancel()
+
+	// Starting exporters might take a while, they are not used until
+	// swapped in below
+	exp, err := buildExporters(ctx, c, p.registry, prev)
+	if err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	if p.stopped.Load() {
+		// The shutdown began while the exporters were started and will
+		// only close the ones currently in use
+		p.lock.Unlock()
+
+		started := exp.set()
+		if prev != nil {
+			started = exp.replacedBy(prev)
+		}
+		closeExporters(started)
+
+		return errors.New("shutdown in progress")
+	}
+	defer p.lock.Unlock()
+
+	var (
+		ids      = make([]string, 0, len(vehicles))
+		metadata = make(map[string]vehicle.Metadata, len(vehicles))
+		names    = make(map[string]string, len(vehicles))
+	)
+	for _, v := range vehicles {
+		ids = append(ids, v.ID)
+		metadata[v.ID] = v.Metadata
+		names[v.ID] = v.Name
+	}
+	p.registry.Set(metadata)
+	p.stateStore.SetVehicles(names)
+
+	// The change filter is kept across reloads so unchanged values are
+	// not pushed again. Exporters created by this reload did not receive
+	// any data yet and need the full data once.
+	if p.changes == nil {
+		p.changes = changefilter.New(exp.set(), c.ForcePushInterval)
+	} else {
+		p.changes.SetNext(exp.set(), c.ForcePushInterval)
+		if prev == nil || len(exp.replacedBy(prev)) > 0 {
+			p.changes.Reset()
+		}
+	}
+
+	// Let the filter and exporters drop the data of removed vehicles
+	// (the filter passes the prune on to the exporters)
+	p.changes.PruneVehicles(ids)
+
+	// The state store (also rendering the Prometheus metrics) always
+	// receives the full data, exporters might only receive changes
+	var target exporters.Exporter = exp.set()
+	if c.SkipUnchanged {
+		target = p.changes
+	}
+	p.engine.SetExporter(exporters.Set{p.stateStore, target})
+
+	p.planner.SetRequestsPerCycle(fetcher.RequestsPerCycle(fetchVehicles(vehicles), nil, c.FetchInterval))
+
+	// Re-add the job so the next run is computed for the new setup
+	if p.entryID != 0 {
+		p.sched.Remove(p.entryID)
+	}
+	p.entryID = p.sched.Schedule(p.planner, cron.FuncJob(p.runCycle))
+
+	if prev != nil {
+		// Release exporters no longer in use (their pending points are
+		// written out on Close)
+		go closeExporters(prev.replacedBy(exp))
+	}
+
+	p.current.Store(&pipelineState{cfg: c, exporters: exp, vehicles: vehicles})
+
+	configLastReloadSuccess.SetToCurrentTime()
+	configLastReloadSuccessful.Set(1)
+	return nil
+}
+
+// reload reads the config file again and applies it on top of the
+// CLI flags given on startup
+func (p *pipeline) reload() error {
+	if flagCfg.Config == "" {
+		return errors.New("no config file given, nothing to reload")
+	}
+
+	c := flagCfg
+	c.VehicleID = append([]string(nil), flagCfg.VehicleID...)
+
+	vehicles, err := loadConfigFile(&c, c.Config)
+	if err != nil {
+		return errors.Wrap(err, "loading config file")
+	}
+
+	if err = c.Validate(); err != nil {
+		return errors.Wrap(err, "validating config")
+	}
+
+	if err = validateVehicles(c, vehicles); err != nil {
+		return errors.Wrap(err, "validating vehicles")
+	}
+
+	cur := p.current.Load().cfg
+	credsChanged := c.ClientID != cur.ClientID || c.ClientSecret != cur.ClientSecret ||
+		c.CredentialFile != cur.CredentialFile || c.CredentialKey != cur.CredentialKey ||
+		c.CredentialKeyFile != cur.CredentialKeyFile || c.K8sSecret != cur.K8sSecret ||
+		c.VaultKey != cur.VaultKey
+	if credsChanged {
+		logrus.Warn("credential settings changed, restart to apply them")
+	}
+
+	return p.apply(c, vehicles)
+}
+
+// watchReload reloads the config on SIGHUP and when the config file
+// changes (checked every interval, disabled if zero) until ctx is done
+func (p *pipeline) watchReload(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 && flagCfg.Config != "" {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	lastMod := configModTime()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-hup:
+			logrus.Info("SIGHUP received, reloading config")
+
+		case <-tick:
+			mod := configModTime()
+			if mod.Equal(lastMod) {
+				continue
+			}
+			lastMod = mod
+			logrus.Info("config file changed, reloading config")
+		}
+
+		if ctx.Err() != nil {
+			// Shutdown began while waiting, do not start new exporters
+			return
+		}
+
+		if err := p.reload(); err != nil {
+			logrus.WithError(err).Error("reloading config, keeping previous config")
+			configReloads.WithLabelValues("failure").Inc()
+			configLastReloadSuccessful.Set(0)
+			continue
+		}
+
+		logrus.WithField("vehicles", len(p.currentVehicles())).Info("config reloaded")
+		configReloads.WithLabelValues("success").Inc()
+	}
+}
+
+// shutdown stops the schedule, waits for running cycles (aborting them
+// when ctx is done) and flushes and closes all exporters
+func (p *pipeline) shutdown(ctx context.Context) error {
+	p.stopped.Store(true)
+
+	waitOrAbort := func(done <-chan struct{}) {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			logrus.Warn("fetch cycle did not finish in time, aborting")
+			p.cancelFetch()
+			<-done
+		}
+	}
+
+	// Stop waits for jobs started by the scheduler
+	waitOrAbort(p.sched.Stop().Done())
+
+	// Holding the write lock ensures no other cycle is running
+	locked := make(chan struct{})
+	go func() {
+		p.lock.Lock()
+		close(locked)
+	}()
+	waitOrAbort(locked)
+	defer p.lock.Unlock()
+
+	set := p.current.Load().exporters.set()
+
+	var errs *multierror.Error
+	errs = multierror.Append(errs, errors.Wrap(set.Flush(ctx), "flushing exporters"))
+	errs = multierror.Append(errs, errors.Wrap(set.Close(ctx), "closing exporters"))
+	return errs.ErrorOrNil()
+}
+
+// exporterHealth reports the health of the exporters currently in use
+func (p *pipeline) exporterHealth() []exporters.Health {
+	cur := p.current.Load()
+	if cur == nil {
+		return nil
+	}
+
+	return cur.exporters.set().Health()
+}
+
+func (p *pipeline) currentVehicles() []vehicleConfig {
+	cur := p.current.Load()
+	if cur == nil {
+		return nil
+	}
+
+	return cur.vehicles
+}
+
+func configModTime() time.Time {
+	if flagCfg.Config == "" {
+		return time.Time{}
+	}
+
+	// Stat follows symlinks so swapped ConfigMap mounts are noticed
+	fi, err := os.Stat(flagCfg.Config)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return fi.ModTime()
+}
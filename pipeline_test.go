@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/robfig/cron/v3"
+)
+
+func TestApplyAfterShutdown(t *testing.T) {
+	fetchCtx, cancelFetch := context.WithCancel(context.Background())
+	defer cancelFetch()
+
+	p := &pipeline{
+		fetchCtx:    fetchCtx,
+		cancelFetch: cancelFetch,
+		sched:       cron.New(),
+	}
+
+	cur := &pipelineState{exporters: &exporterSet{}}
+	p.current.Store(cur)
+
+	if err := p.shutdown(context.Background()); err != nil {
+		t.Fatalf("shutting down: %s", err)
+	}
+
+	if err := p.apply(cliConfig{}, nil); err == nil {
+		t.Error("expected apply to fail after shutdown")
+	}
+
+	if p.current.Load() != cur {
+		t.Error("expected config not to be swapped after shutdown")
+	}
+}
+
+func TestWatchReloadStopsOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Returns instead of waiting for the next reload
+	(&pipeline{}).watchReload(ctx, 0)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/credential"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/mercedes"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/state"
+	"github.com/Luzifer/mercedes-byocar-exporter/internal/vehicle"
+)
+
+type (
+	statusContainer struct {
+		Name      mercedes.Container
+		FetchedAt time.Time
+		LastError string
+	}
+
+	statusItem struct {
+		Label string
+		Value string
+		Time  time.Time
+	}
+
+	statusPage struct {
+		Authorized bool
+		AuthError  string
+		Vehicles   []statusVehicle
+		Version    string
+	}
+
+	statusVehicle struct {
+		VehicleID  string
+		Name       string
+		Items      []statusItem
+		Containers []statusContainer
+	}
+)
+
+var (
+	//go:embed templates/*.html
+	templateFS embed.FS
+
+	statusTemplate = template.Must(template.ParseFS(templateFS, "templates/status.html"))
+)
+
+func getStatusPageHandler(store *state.Store, vehicles *vehicle.Registry, creds credential.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		page := statusPage{Version: version}
+
+		authorized, err := creds.HasCredentials()
+		page.Authorized = authorized
+		if err != nil {
+			page.AuthError = err.Error()
+		}
+
+		for _, v := range store.Vehicles() {
+			page.Vehicles = append(page.Vehicles, statusVehicleFromState(v, vehicles))
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err = statusTemplate.Execute(w, page); err != nil {
+			logrus.WithError(err).Error("rendering status page")
+		}
+	}
+}
+
+func statusVehicleFromState(v state.VehicleState, vehicles *vehicle.Registry) statusVehicle {
+	// ID is empty with the VIN hidden, the name is shown instead
+	out := statusVehicle{VehicleID: vehicles.ID(v.VehicleID), Name: v.Name}
+
+	for _, c := range mercedes.Containers {
+		cs := v.Containers[c]
+
+		sc := statusContainer{Name: c, FetchedAt: cs.FetchedAt}
+		if cs.LastError != nil {
+			sc.LastError = cs.LastError.Error()
+		}
+		out.Containers = append(out.Containers, sc)
+	}
+
+	add := func(label string, value mercedes.MetricValue, format func(mercedes.MetricValue) string) {
+		if !value.IsValid() {
+			return
+		}
+		out.Items = append(out.Items, statusItem{Label: label, Value: format(value), Time: value.Time()})
+	}
+
+	if ls, ok := v.Containers[mercedes.ContainerLockStatus].Data.(mercedes.LockStatus); ok {
+		add("Lock status", ls.VehicleStatus, fmtEnum)
+		add("Deck lid lock", ls.DeckLidUnlocked, fmtBool("unlocked", "locked"))
+		add("Gas lid lock", ls.GasLidUnlocked, fmtBool("unlocked", "locked"))
+	}
+
+	if vs, ok := v.Containers[mercedes.ContainerVehicleStatus].Data.(mercedes.VehicleStatus); ok {
+		add("Door front left", vs.DoorFrontLeftOpen, fmtBool("open", "closed"))
+		add("Door front right", vs.DoorFrontRightOpen, fmtBool("open", "closed"))
+		add("Door rear left", vs.DoorRearLeftOpen, fmtBool("open", "closed"))
+		add("Door rear right", vs.DoorRearRightOpen, fmtBool("open", "closed"))
+		add("Deck lid", vs.DeckLidOpen, fmtBool("open", "closed"))
+		add("Window front left", vs.WindowStatusFrontLeft, fmtEnum)
+		add("Window front right", vs.WindowStatusFrontRight, fmtEnum)
+		add("Window rear left", vs.WindowStatusRearLeft, fmtEnum)
+		add("Window rear right", vs.WindowStatusRearRight, fmtEnum)
+		add("Sunroof", vs.SunRoofStatus, fmtEnum)
+	}
+
+	if fs, ok := v.Containers[mercedes.ContainerFuelStatus].Data.(mercedes.FuelStatus); ok {
+		add("Fuel level", fs.TanklevelPercent, fmtUnit("%"))
+		add("Fuel range", fs.RangeLiquid, fmtUnit("km"))
+	}
+
+	if es, ok := v.Containers[mercedes.ContainerElectricStatus].Data.(mercedes.ElectricStatus); ok {
+		add("State of charge", es.StateOfCharge, fmtUnit("%"))
+		add("Electric range", es.ElectricRange, fmtUnit("km"))
+	}
+
+	if p, ok := v.Containers[mercedes.ContainerPayAsYouDrive].Data.(mercedes.PayAsYouDriveInsurance); ok {
+		add("Odometer", p.Odometer, fmtUnit("km"))
+	}
+
+	return out
+}
+
+func fmtBool(on, off string) func(mercedes.MetricValue) string {
+	return func(v mercedes.MetricValue) string {
+		if v.ToFloat() != 0 {
+			return on
+		}
+		return off
+	}
+}
+
+func fmtEnum(v mercedes.MetricValue) string {
+	if e, ok := v.(mercedes.TimedEnum); ok {
+		return e.Value()
+	}
+	return fmt.Sprintf("%v", v.ToFloat())
+}
+
+func fmtUnit(unit string) func(mercedes.MetricValue) string {
+	return func(v mercedes.MetricValue) string {
+		return fmt.Sprintf("%v %s", v.ToFloat(), unit)
+	}
+}